@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -24,11 +25,6 @@ func main() {
 	c.HandleFunc("connected",
 		func(conn *irc.Conn, line *irc.Line) { conn.Join(*channel) })
 
-	// Set up a handler to notify of disconnect events.
-	quit := make(chan bool)
-	c.HandleFunc("disconnected",
-		func(conn *irc.Conn, line *irc.Line) { quit <- true })
-
 	// set up a goroutine to read commands from stdin
 	in := make(chan string, 4)
 	reallyquit := false
@@ -54,7 +50,7 @@ func main() {
 			if cmd[0] == ':' {
 				switch idx := strings.Index(cmd, " "); {
 				case cmd[1] == 'd':
-					fmt.Printf(c.String())
+					fmt.Print(c.String())
 				case cmd[1] == 'f':
 					if len(cmd) > 2 && cmd[2] == 'e' {
 						// enable flooding
@@ -86,13 +82,19 @@ func main() {
 	}()
 
 	for !reallyquit {
+		// subscribe to disconnect events before connecting so we can't
+		// miss one; the subscription is closed after disconnection.
+		disconnected := c.Subscribe(context.Background(),
+			irc.Commands(irc.DISCONNECTED))
+
 		// connect to server
 		if err := c.ConnectTo(*host); err != nil {
 			fmt.Printf("Connection error: %s\n", err)
 			return
 		}
 
-		// wait on quit channel
-		<-quit
+		// wait for the subscription to be closed
+		for range disconnected {
+		}
 	}
 }
@@ -0,0 +1,109 @@
+package client
+
+// This file contains the code that collects IRCv3 batches of lines.
+// http://ircv3.net/specs/extensions/batch-3.2.html
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// A Batch is a group of lines the server sent between BATCH +ref and
+// BATCH -ref, with a "batch" tag referring to it.
+type Batch struct {
+	Ref, Type string
+	Params    []string
+	Tags      map[string]string
+	Lines     []*Line
+}
+
+// batchHandlers are called with completed batches of the types they are
+// keyed by. The lines in these batches are not dispatched to handlers.
+var batchHandlers = map[string]func(*Conn, *Batch){
+	"chathistory": (*Conn).h_chathistoryBatch,
+	MULTILINE:     (*Conn).h_multilineBatch,
+}
+
+// batches holds the batches the server has started but not yet ended.
+type batches struct {
+	mu   sync.Mutex
+	open map[string]*Batch
+	// Nested batches are collected into the outermost batch being
+	// collected, if any, which root maps them to.
+	root map[string]*Batch
+}
+
+func newBatches() *batches {
+	return &batches{
+		open: make(map[string]*Batch),
+		root: make(map[string]*Batch),
+	}
+}
+
+func (b *batches) wipe() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open = make(map[string]*Batch)
+	b.root = make(map[string]*Batch)
+}
+
+// collect adds a line to the batch it belongs to if that batch is being
+// collected, returning true if so.
+func (b *batches) collect(line *Line) bool {
+	ref, ok := line.Tags["batch"]
+	if !ok || line.Cmd == BATCH {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r, ok := b.root[ref]; ok {
+		r.Lines = append(r.Lines, line)
+		return true
+	}
+	return false
+}
+
+// Handle BATCH lines starting and ending batches
+func (conn *Conn) h_BATCH(line *Line) {
+	if !line.argslen(0) || len(line.Args[0]) < 2 {
+		return
+	}
+	b := conn.batches
+	ref := line.Args[0][1:]
+	switch line.Args[0][0] {
+	case '+':
+		if !line.argslen(1) {
+			return
+		}
+		bt := &Batch{
+			Ref:    ref,
+			Type:   strings.ToLower(line.Args[1]),
+			Params: line.Args[2:],
+			Tags:   line.Tags,
+		}
+		b.mu.Lock()
+		b.open[ref] = bt
+		if r, ok := b.root[line.Tags["batch"]]; ok {
+			b.root[ref] = r
+		} else if _, ok := batchHandlers[bt.Type]; ok {
+			b.root[ref] = bt
+		}
+		b.mu.Unlock()
+	case '-':
+		b.mu.Lock()
+		bt, ok := b.open[ref]
+		r := b.root[ref]
+		delete(b.open, ref)
+		delete(b.root, ref)
+		b.mu.Unlock()
+		if !ok {
+			logging.Warn("irc.BATCH(): end of unknown batch %s", ref)
+			return
+		}
+		if r == bt {
+			batchHandlers[bt.Type](conn, bt)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestBatches(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	var got []*Batch
+	batchHandlers["test"] = func(_ *Conn, b *Batch) { got = append(got, b) }
+	defer delete(batchHandlers, "test")
+	dispatched := 0
+	c.HandleFunc(PRIVMSG, func(*Conn, *Line) { dispatched++ })
+
+	// Lines in batches without a handler are dispatched as usual.
+	c.dispatch(ParseLine(":irc.server.org BATCH +a netsplit irc.hub irc.leaf"))
+	c.dispatch(ParseLine("@batch=a :user1!ident1@host1.com PRIVMSG #test1 :hi"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -a"))
+	if dispatched != 1 || len(got) != 0 {
+		t.Errorf("Line in unhandled batch not dispatched.")
+	}
+
+	// Nested batches are collected into the outermost handled batch.
+	c.dispatch(ParseLine(":irc.server.org BATCH +b TEST param"))
+	c.dispatch(ParseLine("@batch=b :user1!ident1@host1.com PRIVMSG #test1 :one"))
+	c.dispatch(ParseLine("@batch=b :irc.server.org BATCH +c netsplit"))
+	c.dispatch(ParseLine("@batch=c :user1!ident1@host1.com PRIVMSG #test1 :two"))
+	c.dispatch(ParseLine("@batch=b :irc.server.org BATCH -c"))
+	if len(got) != 0 {
+		t.Errorf("Batch handled before it ended.")
+	}
+	c.dispatch(ParseLine(":irc.server.org BATCH -b"))
+	if dispatched != 1 || len(got) != 1 {
+		t.Fatalf("Handled batch not collected correctly.")
+	}
+	b := got[0]
+	if b.Ref != "b" || b.Type != "test" || len(b.Params) != 1 ||
+		b.Params[0] != "param" || len(b.Lines) != 2 ||
+		b.Lines[0].Text() != "one" || b.Lines[1].Text() != "two" {
+		t.Errorf("Batch incorrect: %#v", b)
+	}
+	if len(c.batches.open) != 0 || len(c.batches.root) != 0 {
+		t.Errorf("Ended batches not forgotten.")
+	}
+
+	// Unknown batches are ignored.
+	c.dispatch(ParseLine(":irc.server.org BATCH -d"))
+}
@@ -0,0 +1,173 @@
+package client
+
+// This file contains the IRCv3 capability negotiation.
+// http://ircv3.net/specs/core/capability-negotiation-3.2.html
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// stCaps are the capabilities requested when state tracking is enabled,
+// which let the tracker keep up with changes without polling.
+var stCaps = []string{
+	"account-notify",
+	"away-notify",
+	"chghost",
+	"extended-join",
+	"multi-prefix",
+	"setname",
+	"userhost-in-names",
+}
+
+// caps holds the capabilities the server supports and those enabled.
+type caps struct {
+	mu sync.Mutex
+	// True from sending CAP LS until sending CAP END during registration.
+	negotiating bool
+	// Capabilities listed by the server, with their values if any.
+	available map[string]string
+	enabled   map[string]bool
+	// The number of CAP REQs waiting for an ACK or NAK.
+	requested int
+}
+
+func newCaps() *caps {
+	return &caps{
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+	}
+}
+
+func (c *caps) wipe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negotiating = false
+	c.available = make(map[string]string)
+	c.enabled = make(map[string]bool)
+	c.requested = 0
+}
+
+// HasCap returns true if the server has acknowledged our request for an
+// IRCv3 capability. Capabilities in Config.Capabilities are requested
+// during registration, along with those needed to send and receive
+// multiline messages, and those the state tracker uses if it is enabled.
+// Others can be requested later with Cap("REQ", ...).
+func (conn *Conn) HasCap(capability string) bool {
+	conn.caps.mu.Lock()
+	defer conn.caps.mu.Unlock()
+	return conn.caps.enabled[capability]
+}
+
+// wantedCaps returns the capabilities to request from the server.
+func (conn *Conn) wantedCaps() []string {
+	lists := [][]string{conn.cfg.Capabilities}
+	if conn.st != nil {
+		lists = append(lists, stCaps, historyCaps)
+	}
+	lists = append(lists, multilineCaps)
+	seen := make(map[string]bool)
+	var want []string
+	for _, l := range lists {
+		for _, c := range l {
+			if !seen[c] {
+				seen[c] = true
+				want = append(want, c)
+			}
+		}
+	}
+	return want
+}
+
+// startCaps begins capability negotiation during registration, if there
+// are capabilities we want.
+func (conn *Conn) startCaps() {
+	if len(conn.wantedCaps()) == 0 {
+		return
+	}
+	conn.caps.mu.Lock()
+	conn.caps.negotiating = true
+	conn.caps.mu.Unlock()
+	conn.Raw(CAP + " LS 302")
+}
+
+// Handle CAP replies from the server
+func (conn *Conn) h_CAP(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	c := conn.caps
+	c.mu.Lock()
+	var req []string
+	end := false
+	switch sub, list := strings.ToUpper(line.Args[1]), capList(line); sub {
+	case "LS", "NEW":
+		for _, cp := range list {
+			kv := strings.SplitN(cp, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			c.available[kv[0]] = kv[1]
+		}
+		if sub == "LS" && len(line.Args) > 3 && line.Args[2] == "*" {
+			// There are more lines of capabilities to come.
+			break
+		}
+		for _, want := range conn.wantedCaps() {
+			if _, ok := c.available[want]; ok && !c.enabled[want] {
+				req = append(req, want)
+			}
+		}
+		if len(req) > 0 {
+			c.requested++
+		} else {
+			end = c.negotiating
+		}
+	case "ACK":
+		for _, cp := range list {
+			if strings.HasPrefix(cp, "-") {
+				delete(c.enabled, cp[1:])
+			} else {
+				c.enabled[cp] = true
+			}
+		}
+		fallthrough
+	case "NAK":
+		if sub == "NAK" {
+			logging.Warn("irc.CAP(): server refused capabilities: %s",
+				strings.Join(list, " "))
+		}
+		if c.requested > 0 {
+			c.requested--
+		}
+		end = c.negotiating && c.requested == 0
+	case "DEL":
+		for _, cp := range list {
+			delete(c.available, cp)
+			delete(c.enabled, cp)
+		}
+	}
+	if end {
+		c.negotiating = false
+	}
+	c.mu.Unlock()
+
+	// Don't hold the lock while sending, in case an OutHandler wants it.
+	if len(req) > 0 {
+		conn.Cap("REQ", strings.Join(req, " "))
+	}
+	if end {
+		conn.Cap("END")
+	}
+}
+
+// capList returns the capabilities listed in the last argument of a CAP
+// line, without any trailing space.
+func capList(line *Line) []string {
+	if len(line.Args) < 3 {
+		return nil
+	}
+	return strings.Fields(line.Args[len(line.Args)-1])
+}
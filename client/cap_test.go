@@ -0,0 +1,57 @@
+package client
+
+import (
+	"testing"
+)
+
+// Test capability negotiation during registration
+func TestCAP(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.Capabilities = []string{"server-time"}
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+
+	// Capabilities listed over multiple lines are requested together.
+	c.h_CAP(ParseLine(":irc.server.org CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL away-notify"))
+	s.nc.ExpectNothing()
+	c.h_CAP(ParseLine(":irc.server.org CAP * LS :server-time account-notify extended-join "))
+	s.nc.Expect("CAP REQ :server-time account-notify away-notify extended-join multi-prefix")
+	c.h_CAP(ParseLine(":irc.server.org CAP test ACK :server-time account-notify away-notify extended-join multi-prefix"))
+	s.nc.Expect("CAP END")
+
+	for _, cp := range []string{"server-time", "account-notify", "extended-join", "multi-prefix"} {
+		if !c.HasCap(cp) {
+			t.Errorf("Capability %s not enabled after ACK.", cp)
+		}
+	}
+	if c.HasCap("sasl") || c.HasCap("chghost") {
+		t.Errorf("Capability enabled without being requested.")
+	}
+
+	// cap-notify: new capabilities we want are requested, without CAP END.
+	c.h_CAP(ParseLine(":irc.server.org CAP test NEW :chghost batch"))
+	s.nc.Expect("CAP REQ :chghost batch")
+	c.h_CAP(ParseLine(":irc.server.org CAP test NAK :chghost"))
+	s.nc.ExpectNothing()
+	if c.HasCap("chghost") {
+		t.Errorf("Capability enabled after NAK.")
+	}
+	c.h_CAP(ParseLine(":irc.server.org CAP test DEL :away-notify"))
+	if c.HasCap("away-notify") {
+		t.Errorf("Capability still enabled after DEL.")
+	}
+
+	// If the server supports none of the capabilities we want, we should
+	// finish negotiating straight away.
+	c.caps.wipe()
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	c.h_CAP(ParseLine(":irc.server.org CAP * LS :sasl"))
+	s.nc.Expect("CAP END")
+}
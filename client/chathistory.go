@@ -0,0 +1,329 @@
+package client
+
+// This file contains the client for the IRCv3 chathistory extension, which
+// lets us fetch messages sent while we weren't around to see them.
+// http://ircv3.net/specs/extensions/chathistory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
+)
+
+const (
+	// The limit used when neither the caller nor the server sets one.
+	defaultHistoryLimit = 100
+	// The format of timestamps in the server-time tag and CHATHISTORY.
+	serverTimeFormat = "2006-01-02T15:04:05.000Z"
+	// How long to wait for the server to send history when backfilling.
+	backfillTimeout = time.Minute
+)
+
+// historyCaps are the capabilities needed to fetch history with
+// CHATHISTORY. They are requested along with stCaps when state tracking
+// is enabled, so that history can be backfilled.
+var historyCaps = []string{
+	"batch",
+	"draft/chathistory",
+	"message-tags",
+	"server-time",
+}
+
+// A HistoryRef identifies a point in a conversation's history, either by
+// a message's ID or by a time. Create them with MsgID and Timestamp.
+type HistoryRef string
+
+// MsgID refers to the message with the given ID.
+func MsgID(id string) HistoryRef { return HistoryRef("msgid=" + id) }
+
+// Timestamp refers to the given time.
+func Timestamp(t time.Time) HistoryRef {
+	return HistoryRef("timestamp=" + t.UTC().Format(serverTimeFormat))
+}
+
+// A HistorySelector chooses which messages History fetches. Create them
+// with Before, After, Between, Around and Latest.
+type HistorySelector struct {
+	sub  string
+	refs []HistoryRef
+}
+
+// Before selects the most recent messages sent before ref.
+func Before(ref HistoryRef) HistorySelector {
+	return HistorySelector{"BEFORE", []HistoryRef{ref}}
+}
+
+// After selects the messages sent after ref.
+func After(ref HistoryRef) HistorySelector {
+	return HistorySelector{"AFTER", []HistoryRef{ref}}
+}
+
+// Between selects the messages sent between start and end, starting from
+// start, which may be later than end.
+func Between(start, end HistoryRef) HistorySelector {
+	return HistorySelector{"BETWEEN", []HistoryRef{start, end}}
+}
+
+// Around selects the messages sent either side of ref.
+func Around(ref HistoryRef) HistorySelector {
+	return HistorySelector{"AROUND", []HistoryRef{ref}}
+}
+
+// Latest selects the most recent messages sent after ref, or the most
+// recent messages if ref is empty.
+func Latest(ref HistoryRef) HistorySelector {
+	if ref == "" {
+		ref = "*"
+	}
+	return HistorySelector{"LATEST", []HistoryRef{ref}}
+}
+
+// A historyRequest waits for the batch of messages the server sends in
+// reply to a CHATHISTORY command.
+type historyRequest struct {
+	done chan struct{}
+	msgs []*state.Message
+	err  error
+}
+
+// chatHistory holds the requests waiting for replies, keyed by target.
+// The server replies to requests for the same target in order.
+type chatHistory struct {
+	mu      sync.Mutex
+	waiting map[string][]*historyRequest
+}
+
+func newChatHistory() *chatHistory {
+	return &chatHistory{waiting: make(map[string][]*historyRequest)}
+}
+
+// wipe fails any requests still waiting.
+func (ch *chatHistory) wipe() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, reqs := range ch.waiting {
+		for _, req := range reqs {
+			req.err = fmt.Errorf("irc.History(): disconnected")
+			close(req.done)
+		}
+	}
+	ch.waiting = make(map[string][]*historyRequest)
+}
+
+// next removes and returns the oldest request waiting for target.
+func (ch *chatHistory) next(target string) *historyRequest {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	target = strings.ToLower(target)
+	reqs := ch.waiting[target]
+	if len(reqs) == 0 {
+		return nil
+	}
+	if len(reqs) == 1 {
+		delete(ch.waiting, target)
+	} else {
+		ch.waiting[target] = reqs[1:]
+	}
+	return reqs[0]
+}
+
+// SupportsHistory returns true if the server supports fetching history
+// with CHATHISTORY, and the capabilities it needs have been negotiated.
+func (conn *Conn) SupportsHistory() bool {
+	if !conn.HasCap("batch") {
+		return false
+	}
+	_, ok := conn.isupport.get("CHATHISTORY")
+	return ok || conn.HasCap("draft/chathistory") || conn.HasCap("chathistory")
+}
+
+// History asks the server for the messages sent to a channel, or in a
+// private conversation with a nick, that are chosen by sel, up to limit
+// of them. If limit is zero, the server's maximum is used. The messages
+// are returned oldest first, with their times from the server-time tag
+// and msgids, if the server sent them.
+//
+// History blocks until the server replies or ctx is done, so it must not
+// be called from a foreground handler. The server must support the
+// chathistory extension, and the batch capability must be enabled; these
+// are requested automatically when state tracking is enabled, but
+// otherwise "batch", "draft/chathistory", "message-tags" and
+// "server-time" should be added to Config.Capabilities.
+func (conn *Conn) History(ctx context.Context, target string, sel HistorySelector, limit int) ([]*state.Message, error) {
+	if !conn.Connected() {
+		return nil, fmt.Errorf("irc.History(): not connected")
+	}
+	if !conn.SupportsHistory() {
+		return nil, fmt.Errorf("irc.History(): server does not support chathistory")
+	}
+	max, _ := strconv.Atoi(conn.isupport.getDefault("CHATHISTORY", "0"))
+	if limit <= 0 || (max > 0 && limit > max) {
+		limit = max
+	}
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	req := &historyRequest{done: make(chan struct{})}
+	ch := conn.chathistory
+	ch.mu.Lock()
+	key := strings.ToLower(target)
+	ch.waiting[key] = append(ch.waiting[key], req)
+	ch.mu.Unlock()
+
+	args := []string{CHATHISTORY, sel.sub, target}
+	for _, r := range sel.refs {
+		args = append(args, string(r))
+	}
+	conn.Raw(strings.Join(append(args, strconv.Itoa(limit)), " "))
+
+	select {
+	case <-req.done:
+		return req.msgs, req.err
+	case <-ctx.Done():
+		// The request stays queued, so that the reply isn't mistaken
+		// for the reply to a later request for the same target.
+		return nil, ctx.Err()
+	}
+}
+
+// Handle the end of a chathistory batch, completing the oldest request
+func (conn *Conn) h_chathistoryBatch(b *Batch) {
+	if len(b.Params) < 1 {
+		logging.Warn("irc.BATCH(): chathistory batch %s has no target", b.Ref)
+		return
+	}
+	req := conn.chathistory.next(b.Params[0])
+	if req == nil {
+		logging.Warn("irc.BATCH(): unexpected chathistory batch for %s",
+			b.Params[0])
+		return
+	}
+	for _, line := range b.Lines {
+		if m := historyMessage(line); m != nil {
+			req.msgs = append(req.msgs, m)
+		}
+	}
+	close(req.done)
+}
+
+// Handle FAIL replies to CHATHISTORY, failing the request they refer to
+func (conn *Conn) h_FAIL(line *Line) {
+	if !line.argslen(2) || strings.ToUpper(line.Args[0]) != CHATHISTORY {
+		return
+	}
+	// The context arguments usually include the target, but not always.
+	var req *historyRequest
+	for _, a := range line.Args[2 : len(line.Args)-1] {
+		if req = conn.chathistory.next(a); req != nil {
+			break
+		}
+	}
+	if req == nil {
+		logging.Warn("irc.FAIL(): unexpected chathistory failure: %s",
+			strings.Join(line.Args, " "))
+		return
+	}
+	req.err = fmt.Errorf("irc.History(): %s: %s", line.Args[1], line.Text())
+	close(req.done)
+}
+
+// Handle disconnection, failing any requests still waiting for replies
+func (conn *Conn) h_chathistoryDisconnected(line *Line) {
+	conn.chathistory.wipe()
+}
+
+// historyMessage converts a PRIVMSG, NOTICE or ACTION line to a Message,
+// returning nil for other lines, which servers may include in history.
+func historyMessage(line *Line) *state.Message {
+	switch line.Cmd {
+	case PRIVMSG, NOTICE, ACTION:
+	default:
+		return nil
+	}
+	if len(line.Args) < 2 {
+		return nil
+	}
+	return &state.Message{
+		Time:   serverTime(line),
+		Kind:   line.Cmd,
+		Nick:   line.Nick,
+		Ident:  line.Ident,
+		Host:   line.Host,
+		Target: line.Args[0],
+		Text:   line.Text(),
+		MsgID:  line.Tags["msgid"],
+	}
+}
+
+// addSent adds a PRIVMSG, NOTICE or ACTION line we sent to the state
+// tracker's history, as the server doesn't send our own messages back to
+// us. Lines in multiline batches we send are added as one message when the
+// batch ends.
+func (conn *Conn) addSent(rawline string) {
+	if conn.st == nil || conn.cfg.HistorySize <= 0 {
+		return
+	}
+	line := ParseLine(rawline)
+	if line == nil {
+		return
+	}
+	if line = conn.sent.collect(line); line == nil {
+		return
+	}
+	m := historyMessage(line)
+	if m == nil {
+		return
+	}
+	me := conn.st.Me()
+	m.Time, m.Nick, m.Ident, m.Host = time.Now(), me.Nick, me.Ident, me.Host
+	conn.st.AddMessage(m)
+}
+
+// serverTime returns the time from a line's server-time tag, or the time
+// it was received if it doesn't have one.
+func serverTime(line *Line) time.Time {
+	if s, ok := line.Tags["time"]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	return line.Time
+}
+
+// backfill fetches the messages sent to a channel while we weren't on it,
+// and adds them to the state tracker's history, if Config.HistoryBackfill
+// is set. It is called when we join a channel.
+func (conn *Conn) backfill(channel string) {
+	if !conn.cfg.HistoryBackfill || conn.cfg.HistorySize <= 0 ||
+		!conn.SupportsHistory() {
+		return
+	}
+	sel := Latest("")
+	if h := conn.st.History(channel, 1); len(h) > 0 {
+		if h[0].MsgID != "" {
+			sel = Latest(MsgID(h[0].MsgID))
+		} else {
+			sel = Latest(Timestamp(h[0].Time))
+		}
+	}
+	st := conn.st
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backfillTimeout)
+		defer cancel()
+		msgs, err := conn.History(ctx, channel, sel, conn.cfg.HistorySize)
+		if err != nil {
+			logging.Warn("irc.backfill(): fetching history for %s: %s",
+				channel, err)
+			return
+		}
+		for _, m := range msgs {
+			st.AddMessage(m)
+		}
+	}()
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/state"
+)
+
+type historyResult struct {
+	msgs []*state.Message
+	err  error
+}
+
+// history calls History in a goroutine, as it blocks waiting for replies.
+func history(c *Conn, ctx context.Context, target string, sel HistorySelector, limit int) chan historyResult {
+	res := make(chan historyResult, 1)
+	go func() {
+		msgs, err := c.History(ctx, target, sel, limit)
+		res <- historyResult{msgs, err}
+	}()
+	return res
+}
+
+func enableHistory(c *Conn) {
+	c.caps.enabled["batch"] = true
+	c.caps.enabled["draft/chathistory"] = true
+	c.isupport.parse([]string{"CHATHISTORY=50"})
+}
+
+func TestHistoryRefs(t *testing.T) {
+	ts := time.Date(2019, 1, 2, 3, 4, 5, 6e6, time.FixedZone("X", 3600))
+	tests := []struct {
+		sel  HistorySelector
+		sub  string
+		refs []HistoryRef
+	}{
+		{Before(MsgID("abc")), "BEFORE", []HistoryRef{"msgid=abc"}},
+		{After(Timestamp(ts)), "AFTER",
+			[]HistoryRef{"timestamp=2019-01-02T02:04:05.006Z"}},
+		{Between(MsgID("a"), MsgID("b")), "BETWEEN",
+			[]HistoryRef{"msgid=a", "msgid=b"}},
+		{Around(MsgID("abc")), "AROUND", []HistoryRef{"msgid=abc"}},
+		{Latest(""), "LATEST", []HistoryRef{"*"}},
+	}
+	for i, test := range tests {
+		if test.sel.sub != test.sub || len(test.sel.refs) != len(test.refs) {
+			t.Errorf("%d: selector incorrect: %#v", i, test.sel)
+			continue
+		}
+		for j, r := range test.refs {
+			if test.sel.refs[j] != r {
+				t.Errorf("%d: ref %d = %q, expected %q", i, j,
+					test.sel.refs[j], r)
+			}
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	if _, err := c.History(context.Background(), "#test1", Latest(""), 10); err == nil {
+		t.Errorf("History succeeded without server support.")
+	}
+	enableHistory(c)
+
+	// Lines in the batch shouldn't be dispatched to handlers.
+	dispatched := 0
+	c.HandleFunc(PRIVMSG, func(*Conn, *Line) { dispatched++ })
+
+	res := history(c, context.Background(), "#test1", Latest(""), 100)
+	s.nc.Expect("CHATHISTORY LATEST #test1 * 50")
+	c.dispatch(ParseLine(":irc.server.org BATCH +abc chathistory #test1"))
+	c.dispatch(ParseLine("@batch=abc;msgid=m1;time=2019-01-02T03:04:05.000Z " +
+		":user1!ident1@host1.com PRIVMSG #test1 :hello"))
+	c.dispatch(ParseLine("@batch=abc;msgid=m2;time=2019-01-02T03:04:06.000Z " +
+		":user1!ident1@host1.com JOIN #test1"))
+	c.dispatch(ParseLine("@batch=abc;msgid=m3;time=2019-01-02T03:04:07.000Z " +
+		":user2!ident2@host2.com PRIVMSG #test1 :\001ACTION waves\001"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -abc"))
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("History failed: %s", r.err)
+	}
+	expected := []*state.Message{
+		{Time: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), Kind: PRIVMSG,
+			Nick: "user1", Ident: "ident1", Host: "host1.com",
+			Target: "#test1", Text: "hello", MsgID: "m1"},
+		{Time: time.Date(2019, 1, 2, 3, 4, 7, 0, time.UTC), Kind: ACTION,
+			Nick: "user2", Ident: "ident2", Host: "host2.com",
+			Target: "#test1", Text: "waves", MsgID: "m3"},
+	}
+	if len(r.msgs) != len(expected) {
+		t.Fatalf("History returned %d messages, expected %d.",
+			len(r.msgs), len(expected))
+	}
+	for i, m := range r.msgs {
+		if !m.Time.Equal(expected[i].Time) || m.Kind != expected[i].Kind ||
+			m.Nick != expected[i].Nick || m.Text != expected[i].Text ||
+			m.MsgID != expected[i].MsgID || m.Host != expected[i].Host {
+			t.Errorf("Message %d incorrect: %#v", i, m)
+		}
+	}
+	if dispatched != 0 {
+		t.Errorf("Batched lines dispatched to handlers.")
+	}
+	c.dispatch(ParseLine(":user1!ident1@host1.com PRIVMSG #test1 :live"))
+	if dispatched != 1 {
+		t.Errorf("Unbatched line not dispatched to handlers.")
+	}
+
+	// Failures are returned as errors.
+	res = history(c, context.Background(), "#test2", Before(MsgID("x")), 10)
+	s.nc.Expect("CHATHISTORY BEFORE #test2 msgid=x 10")
+	c.dispatch(ParseLine(":irc.server.org FAIL CHATHISTORY INVALID_TARGET BEFORE #test2 :No such channel"))
+	if r = <-res; r.err == nil || r.msgs != nil {
+		t.Errorf("History didn't return error for FAIL: %v", r)
+	}
+
+	// A cancelled request's reply isn't mistaken for a later one's.
+	ctx, cancel := context.WithCancel(context.Background())
+	res = history(c, ctx, "#test1", Around(MsgID("m1")), 10)
+	s.nc.Expect("CHATHISTORY AROUND #test1 msgid=m1 10")
+	cancel()
+	if r = <-res; r.err != context.Canceled {
+		t.Errorf("Cancelled History returned %v", r)
+	}
+	res = history(c, context.Background(), "#test1", Latest(""), 10)
+	s.nc.Expect("CHATHISTORY LATEST #test1 * 10")
+	c.dispatch(ParseLine(":irc.server.org BATCH +b1 chathistory #test1"))
+	c.dispatch(ParseLine("@batch=b1 :user1!ident1@host1.com PRIVMSG #test1 :one"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -b1"))
+	c.dispatch(ParseLine(":irc.server.org BATCH +b2 chathistory #test1"))
+	c.dispatch(ParseLine("@batch=b2 :user1!ident1@host1.com PRIVMSG #test1 :two"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -b2"))
+	if r = <-res; r.err != nil || len(r.msgs) != 1 || r.msgs[0].Text != "two" {
+		t.Errorf("History returned reply to cancelled request: %v", r)
+	}
+
+	// Disconnecting fails requests still waiting.
+	res = history(c, context.Background(), "#test1", Latest(""), 10)
+	s.nc.Expect("CHATHISTORY LATEST #test1 * 10")
+	c.dispatch(&Line{Cmd: DISCONNECTED})
+	if r = <-res; r.err == nil {
+		t.Errorf("History didn't fail on disconnect.")
+	}
+}
+
+func TestHistoryBackfill(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+	c.cfg.HistorySize = 10
+	c.cfg.HistoryBackfill = true
+	c.EnableStateTracking()
+	enableHistory(c)
+
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	s.nc.Expect("CHATHISTORY LATEST #test1 * 10")
+	c.dispatch(ParseLine(":irc.server.org BATCH +abc chathistory #test1"))
+	c.dispatch(ParseLine("@batch=abc;msgid=m1;time=2019-01-02T03:04:05.000Z " +
+		":user1!ident1@host1.com PRIVMSG #test1 :hello"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -abc"))
+	<-time.After(time.Millisecond)
+	if h := c.LocalHistory("#test1", 0); len(h) != 1 || h[0].MsgID != "m1" {
+		t.Errorf("History not backfilled: %#v", h)
+	}
+
+	// After reconnecting, only messages after the last one kept are
+	// fetched.
+	c.st.Wipe()
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	s.nc.Expect("CHATHISTORY LATEST #test1 msgid=m1 10")
+	c.dispatch(ParseLine(":irc.server.org BATCH +def chathistory #test1"))
+	c.dispatch(ParseLine(":irc.server.org BATCH -def"))
+}
@@ -2,7 +2,11 @@ package client
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
 )
 
 const (
@@ -10,11 +14,16 @@ const (
 	CONNECTED    = "CONNECTED"
 	DISCONNECTED = "DISCONNECTED"
 	ACTION       = "ACTION"
+	ACCOUNT      = "ACCOUNT"
 	AWAY         = "AWAY"
+	BATCH        = "BATCH"
 	CAP          = "CAP"
+	CHATHISTORY  = "CHATHISTORY"
+	CHGHOST      = "CHGHOST"
 	CTCP         = "CTCP"
 	CTCPREPLY    = "CTCPREPLY"
 	ERROR        = "ERROR"
+	FAIL         = "FAIL"
 	INVITE       = "INVITE"
 	JOIN         = "JOIN"
 	KICK         = "KICK"
@@ -28,6 +37,7 @@ const (
 	PONG         = "PONG"
 	PRIVMSG      = "PRIVMSG"
 	QUIT         = "QUIT"
+	SETNAME      = "SETNAME"
 	TOPIC        = "TOPIC"
 	USER         = "USER"
 	VERSION      = "VERSION"
@@ -35,6 +45,8 @@ const (
 	WHO          = "WHO"
 	WHOIS        = "WHOIS"
 	defaultSplit = 450
+
+	defaultSplitMarker = "..."
 )
 
 // cutNewLines() pares down a string to the part before the first "\r" or "\n".
@@ -64,31 +76,47 @@ func indexFragment(s string) int {
 	return -1
 }
 
-// splitMessage splits a message > splitLen chars at:
-//   1. the end of the last sentence fragment before splitLen
-//   2. the end of the last word before splitLen
-//   3. splitLen itself
-func splitMessage(msg string, splitLen int) (msgs []string) {
-	// This is quite short ;-)
-	if splitLen < 13 {
-		splitLen = defaultSplit
-	}
-	for len(msg) > splitLen {
-		idx := indexFragment(msg[:splitLen-3])
-		if idx < 0 {
-			idx = splitLen - 3
-		}
-		msgs = append(msgs, msg[:idx]+"...")
-		msg = msg[idx:]
-	}
-	return append(msgs, msg)
+// splitMessage splits a message > splitLen bytes with the default
+// Splitter, marking each part but the last with "...".
+func splitMessage(msg string, splitLen int) []string {
+	return NewSplitter(defaultSplitMarker)(msg, splitLen)
 }
 
 // Raw sends a raw line to the server, should really only be used for
-// debugging purposes but may well come in handy.
+// debugging purposes but may well come in handy. All the other commands
+// send their lines via Raw, so this is where OutHandlers are run.
 func (conn *Conn) Raw(rawline string) {
 	// Avoid command injection by enforcing one command per line.
-	conn.out <- cutNewLines(rawline)
+	rawline = cutNewLines(rawline)
+	if !conn.outHandlers.empty() {
+		var ok bool
+		if rawline, ok = conn.handleOut(rawline); !ok {
+			return
+		}
+	}
+	conn.addSent(rawline)
+	conn.out <- rawline
+}
+
+// handleOut parses an outgoing line and runs OutHandlers on it, returning
+// the line that should be sent and false if it was vetoed.
+func (conn *Conn) handleOut(rawline string) (string, bool) {
+	line := ParseLine(rawline)
+	if line == nil {
+		return rawline, true
+	}
+	line.Time = time.Now()
+	orig := line.Copy()
+	if !conn.outHandlers.dispatchOut(conn, line) {
+		logging.Info("irc.Raw(): line vetoed by handler: %s", rawline)
+		return "", false
+	}
+	if reflect.DeepEqual(orig, line) {
+		// Avoid any differences in formatting for unmodified lines.
+		return rawline, true
+	}
+	// Handlers may have added newlines to args or tags.
+	return cutNewLines(line.String()), true
 }
 
 // Pass sends a PASS command to the server.
@@ -154,15 +182,13 @@ func (conn *Conn) Whois(nick string) { conn.Raw(WHOIS + " " + nick) }
 func (conn *Conn) Who(nick string) { conn.Raw(WHO + " " + nick) }
 
 // Privmsg sends a PRIVMSG to the target nick or channel t.
-// If msg is longer than Config.SplitLen characters, multiple PRIVMSGs
+// If msg is longer than Config.SplitLen bytes, or too long to fit in one
+// line once the server adds our nick!ident@host to it, multiple PRIVMSGs
 // will be sent to the target containing sequential parts of msg.
+// If msg contains newlines, its lines are sent in a multiline batch if
+// the server supports them, and as separate PRIVMSGs otherwise.
 // PRIVMSG t :msg
-func (conn *Conn) Privmsg(t, msg string) {
-	prefix := PRIVMSG + " " + t + " :"
-	for _, s := range splitMessage(msg, conn.cfg.SplitLen) {
-		conn.Raw(prefix + s)
-	}
-}
+func (conn *Conn) Privmsg(t, msg string) { conn.message(PRIVMSG, t, msg) }
 
 // Privmsgln is the variadic version of Privmsg that formats the message
 // that is sent to the target nick or channel t using the
@@ -185,21 +211,21 @@ func (conn *Conn) Privmsgf(t, format string, a ...interface{}) {
 }
 
 // Notice sends a NOTICE to the target nick or channel t.
-// If msg is longer than Config.SplitLen characters, multiple NOTICEs
+// If msg is longer than Config.SplitLen bytes, or too long to fit in one
+// line once the server adds our nick!ident@host to it, multiple NOTICEs
 // will be sent to the target containing sequential parts of msg.
+// If msg contains newlines, its lines are sent in a multiline batch if
+// the server supports them, and as separate NOTICEs otherwise.
 //     NOTICE t :msg
-func (conn *Conn) Notice(t, msg string) {
-	for _, s := range splitMessage(msg, conn.cfg.SplitLen) {
-		conn.Raw(NOTICE + " " + t + " :" + s)
-	}
-}
+func (conn *Conn) Notice(t, msg string) { conn.message(NOTICE, t, msg) }
 
 // Ctcp sends a (generic) CTCP message to the target nick
 // or channel t, with an optional argument.
 //     PRIVMSG t :\001CTCP arg\001
 func (conn *Conn) Ctcp(t, ctcp string, arg ...string) {
 	// We need to split again here to ensure
-	for _, s := range splitMessage(strings.Join(arg, " "), conn.cfg.SplitLen) {
+	extra := len(ctcp) + 3
+	for _, s := range conn.split(strings.Join(arg, " "), conn.payloadLen(PRIVMSG, t, extra)) {
 		if s != "" {
 			s = " " + s
 		}
@@ -212,7 +238,8 @@ func (conn *Conn) Ctcp(t, ctcp string, arg ...string) {
 // or channel t, with an optional argument.
 //     NOTICE t :\001CTCP arg\001
 func (conn *Conn) CtcpReply(t, ctcp string, arg ...string) {
-	for _, s := range splitMessage(strings.Join(arg, " "), conn.cfg.SplitLen) {
+	extra := len(ctcp) + 3
+	for _, s := range conn.split(strings.Join(arg, " "), conn.payloadLen(NOTICE, t, extra)) {
 		if s != "" {
 			s = " " + s
 		}
@@ -225,7 +252,9 @@ func (conn *Conn) CtcpReply(t, ctcp string, arg ...string) {
 func (conn *Conn) Version(t string) { conn.Ctcp(t, VERSION) }
 
 // Action sends a CTCP "ACTION" to the target nick or channel t.
-func (conn *Conn) Action(t, msg string) { conn.Ctcp(t, ACTION, msg) }
+func (conn *Conn) Action(t, msg string) {
+	conn.Ctcp(t, ACTION, msg)
+}
 
 // Topic() sends a TOPIC command for a channel.
 // If no topic is provided this requests that a 332 response is sent by the
@@ -246,6 +275,7 @@ func (conn *Conn) Topic(channel string, topic ...string) {
 // by the server for the target. Otherwise the mode strings are concatenated
 // with spaces and sent to the server. This allows e.g.
 //     conn.Mode("#channel", "+nsk", "mykey")
+// To make many changes to a channel's modes, use ModeBuilder instead.
 //
 //     MODE t
 //     MODE t modestring
@@ -264,6 +294,8 @@ func (conn *Conn) Mode(t string, modestring ...string) {
 //     AWAY :message
 func (conn *Conn) Away(message ...string) {
 	msg := strings.Join(message, " ")
+	// The server doesn't repeat the message when it marks us as away.
+	conn.awayMsg.Store(msg)
 	if msg != "" {
 		msg = " :" + msg
 	}
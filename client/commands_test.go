@@ -2,6 +2,7 @@ package client
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -203,3 +204,55 @@ func TestClientCommands(t *testing.T) {
 	c.VHost("user", "pass")
 	s.nc.Expect("VHOST user pass")
 }
+
+func TestHandleOut(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	// With no OutHandlers, lines are sent verbatim.
+	c.Raw("PRIVMSG #chan  :spaces  preserved")
+	s.nc.Expect("PRIVMSG #chan  :spaces  preserved")
+
+	// Observe all outgoing lines.
+	seen := []string{}
+	c.HandleOut("*", OutHandlerFunc(func(_ *Conn, l *Line) bool {
+		seen = append(seen, l.Cmd)
+		return true
+	}))
+	// Veto KICKs and MODEs, as in a dry run.
+	c.HandleOut("*", OutHandlerFunc(func(*Conn, *Line) bool {
+		return false
+	}), Commands(KICK, MODE))
+	// Never talk in #announce.
+	c.HandleOut(PRIVMSG, OutHandlerFunc(func(*Conn, *Line) bool {
+		return false
+	}), InChannel("#announce"))
+	// Rewrite and tag messages to #loud.
+	c.HandleOut(PRIVMSG, OutHandlerFunc(func(_ *Conn, l *Line) bool {
+		l.Args[1] = strings.ToUpper(l.Args[1])
+		l.Tags = map[string]string{"+example/loud": ""}
+		return true
+	}), InChannel("#loud"))
+	// A panicking OutHandler doesn't prevent sending.
+	c.HandleOut(NICK, OutHandlerFunc(func(*Conn, *Line) bool {
+		panic("out!")
+	}))
+
+	c.Kick("#chan", "nick")
+	c.Mode("#chan", "+o", "nick")
+	c.Privmsg("#announce", "hello")
+	s.nc.ExpectNothing()
+
+	c.Privmsg("#chan", "hello")
+	s.nc.Expect("PRIVMSG #chan :hello")
+	c.Privmsg("#loud", "hello world")
+	s.nc.Expect("@+example/loud PRIVMSG #loud :HELLO WORLD")
+	c.Nick("new")
+	s.nc.Expect("NICK new")
+
+	// Handlers for PRIVMSG run before those for "*", so the observer
+	// never sees the line vetoed for #announce.
+	if strings.Join(seen, " ") != "KICK MODE PRIVMSG PRIVMSG NICK" {
+		t.Errorf("OutHandler saw unexpected lines: %v", seen)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fluffle/goirc/logging"
@@ -29,10 +30,37 @@ type Conn struct {
 	intHandlers *hSet
 	fgHandlers  *hSet
 	bgHandlers  *hSet
+	outHandlers *hSet
+	pool        *pool
+
+	// Tokens from the server's RPL_ISUPPORT replies, and IRCv3
+	// capabilities negotiated with CAP
+	isupport *isupport
+	caps     *caps
+
+	// Our own nick!ident@host as the server sees it, and the message we
+	// last sent with Away
+	hostmask *hostmask
+	awayMsg  atomic.Value // string
+
+	// Responders answering CTCP requests, and passive DCC offers waiting
+	// for a reply
+	ctcp *ctcp
+	dcc  *dcc
+
+	// Members of channels from NAMES replies that are not yet complete,
+	// batches that have not yet ended, and CHATHISTORY requests waiting
+	// for them; and multiline batches we are sending
+	names       *names
+	batches     *batches
+	chathistory *chatHistory
+	sent        *sentBatches
 
 	// State tracker for nicks and channels
 	st         state.Tracker
 	stRemovers []Remover
+	resyncs    *resyncs
+	whoq       *whoQueue
 
 	// I/O stuff to server
 	dialer      *net.Dialer
@@ -97,8 +125,35 @@ type Config struct {
 	// Set this to true to disable flood protection and false to re-enable.
 	Flood bool
 
-	// Sent as the reply to a CTCP VERSION message.
-	Version string
+	// Sent as the replies to CTCP VERSION, SOURCE and USERINFO messages.
+	// SOURCE and USERINFO aren't answered if they are empty. Responders
+	// for these and other CTCPs can be changed with SetCtcpResponder.
+	Version, Source, UserInfo string
+
+	// CTCP requests are answered at most CtcpBurst at a time, and then
+	// one per CtcpInterval, so that a flood of them doesn't slow down
+	// sending other lines. Requests over the limit are ignored. Default
+	// to 3 and 5s if not set; a negative CtcpBurst disables the limit.
+	CtcpBurst    int
+	CtcpInterval time.Duration
+
+	// DCC sessions we offer listen on DCCListenAddr, which defaults to all
+	// addresses, using the first free port from DCCPortMin to DCCPortMax,
+	// or any free port if they are zero. The address offered to the other
+	// client is DCCPublicIP if set, e.g. when behind NAT, and otherwise
+	// the local address of the connection to the server. DCC connections
+	// not made within DCCTimeout are given up on. Defaults to 2m; set to 0
+	// to wait indefinitely.
+	DCCListenAddr, DCCPublicIP string
+	DCCPortMin, DCCPortMax     int
+	DCCTimeout                 time.Duration
+
+	// Files received with DCC SEND are saved in DCCDir, and can't be
+	// received if it isn't set. Offers of files larger than DCCMaxSize
+	// are refused, and transfers stopped if they send more than that.
+	// Zero means there is no limit.
+	DCCDir     string
+	DCCMaxSize int64
 
 	// Sent as the default QUIT message if Quit is called with no args.
 	QuitMessage string
@@ -107,9 +162,72 @@ type Config struct {
 	// Defaults to logging an error, see LogPanic.
 	Recover func(*Conn, *Line)
 
-	// Split PRIVMSGs, NOTICEs and CTCPs longer than SplitLen characters
-	// over multiple lines. Default to 450 if not set.
+	// Split PRIVMSGs, NOTICEs and CTCPs longer than SplitLen bytes over
+	// multiple lines. They are also split so that they fit in the server's
+	// LINELEN once it adds our nick!ident@host in front of them. Default
+	// to 450 if not set.
 	SplitLen int
+
+	// Splits messages that are too long into parts. Defaults to the
+	// Splitter returned by NewSplitter(SplitMarker) if not set.
+	Splitter Splitter
+
+	// Appended to every part of a split message but the last by the
+	// default Splitter. Defaults to "..." if not set; to split messages
+	// without a marker, set Splitter to NewSplitter("").
+	SplitMarker string
+
+	// Maximum number of goroutines used to run handlers. When they are
+	// all busy, foreground handlers run in the event loop's goroutine.
+	// Defaults to 32. Changing this after calling Client has no effect.
+	DispatchWorkers int
+
+	// Number of lines that may be waiting for background handlers, and
+	// what to do when that many are waiting. Default to 256 and QueueBlock.
+	// Changing these after calling Client has no effect.
+	BGQueueLen    int
+	BGQueuePolicy QueuePolicy
+
+	// Background handlers normally run as soon as a worker is free, so
+	// two lines may be handled in the opposite order to their arrival.
+	// If BGOrderKey is set, lines for which it returns the same non-empty
+	// key are handled one at a time, in order, while lines with different
+	// keys are still handled in parallel. Up to BGQueueLen lines may be
+	// waiting to be handled in order, as well as those that aren't, and
+	// they are handled by the same DispatchWorkers. See KeyByTarget and
+	// KeyByNick.
+	BGOrderKey KeyFunc
+
+	// When the state tracker sees lines referring to channels or nicks
+	// it doesn't know about, it counts them in DriftStats. If this is
+	// set, the channels concerned are also resynced; see Resync.
+	ResyncOnDrift bool
+
+	// The state tracker asks for information about the nicks on each
+	// channel it joins with WHO, using WHOX if the server supports it.
+	// These queries are sent at most once per WhoInterval, to avoid
+	// bursts of WHO replies when rejoining many channels. Zero disables
+	// this limit.
+	WhoInterval time.Duration
+
+	// The state tracker keeps the last HistorySize messages sent to each
+	// channel and in each private conversation, discarding any older than
+	// HistoryMaxAge if it is not zero. See Conn.History. HistorySize is
+	// zero by default, which turns this off. Changes take effect when
+	// state tracking is next enabled.
+	HistorySize   int
+	HistoryMaxAge time.Duration
+
+	// If this is set and the server supports the chathistory extension,
+	// when we join a channel the messages sent since the last one kept
+	// in its history are fetched from the server and added to it.
+	HistoryBackfill bool
+
+	// IRCv3 capabilities to request when registering with the server.
+	// Those needed for multiline messages are requested too, as are
+	// those the state tracker uses to keep up to date if it is enabled.
+	// See HasCap.
+	Capabilities []string
 }
 
 // NewConfig creates a Config struct containing sensible defaults.
@@ -124,6 +242,17 @@ func NewConfig(nick string, args ...string) *Config {
 		Recover:  (*Conn).LogPanic, // in dispatch.go
 		SplitLen: defaultSplit,
 		Timeout:  60 * time.Second,
+
+		SplitMarker: defaultSplitMarker,
+
+		CtcpBurst:    defaultCtcpBurst,
+		CtcpInterval: defaultCtcpInterval,
+		DCCTimeout:   defaultDCCTimeout,
+
+		DispatchWorkers: defaultWorkers,
+		BGQueueLen:      defaultBGQueue,
+		BGQueuePolicy:   QueueBlock,
+		WhoInterval:     defaultWhoInterval,
 	}
 	cfg.Me.Ident = "goirc"
 	if len(args) > 0 && args[0] != "" {
@@ -134,6 +263,7 @@ func NewConfig(nick string, args ...string) *Config {
 		cfg.Me.Name = args[1]
 	}
 	cfg.Version = "Powered by GoIRC"
+	cfg.Source = defaultSource
 	cfg.QuitMessage = "GoBye!"
 	return cfg
 }
@@ -152,6 +282,9 @@ func Client(cfg *Config) *Conn {
 	if cfg == nil {
 		cfg = NewConfig("__idiot__")
 	}
+	if cfg.SplitMarker == "" {
+		cfg.SplitMarker = defaultSplitMarker
+	}
 	if cfg.Me == nil || cfg.Me.Nick == "" || cfg.Me.Ident == "" {
 		cfg.Me = &state.Nick{Nick: "__idiot__"}
 		cfg.Me.Ident = "goirc"
@@ -179,6 +312,19 @@ func Client(cfg *Config) *Conn {
 		intHandlers: handlerSet(),
 		fgHandlers:  handlerSet(),
 		bgHandlers:  handlerSet(),
+		outHandlers: handlerSet(),
+		pool:        newPool(cfg.DispatchWorkers, cfg.BGQueueLen, cfg.BGQueuePolicy),
+		isupport:    newISupport(),
+		caps:        newCaps(),
+		hostmask:    &hostmask{},
+		ctcp:        newCtcp(),
+		dcc:         newDCC(),
+		names:       newNames(),
+		batches:     newBatches(),
+		chathistory: newChatHistory(),
+		sent:        newSentBatches(),
+		resyncs:     newResyncs(),
+		whoq:        newWhoQueue(),
 		stRemovers:  make([]Remover, 0, len(stHandlers)),
 		lastsent:    time.Now(),
 	}
@@ -224,10 +370,24 @@ func (conn *Conn) StateTracker() state.Tracker {
 	return conn.st
 }
 
+// LocalHistory returns up to n of the most recent messages sent to a
+// channel, or in a private conversation with a nick, oldest first. If
+// n <= 0, all the messages kept are returned. Messages are only kept if
+// state tracking is enabled and Config.HistorySize is set, and include
+// those we send with Privmsg, Notice and Action. Use History to fetch
+// older messages from the server.
+func (conn *Conn) LocalHistory(target string, n int) []*state.Message {
+	if conn.st == nil {
+		return nil
+	}
+	return conn.st.History(target, n)
+}
+
 // EnableStateTracking causes the client to track information about
 // all channels it is joined to, and all the nicks in those channels.
 // This can be rather handy for a number of bot-writing tasks. See
-// the state package for more details.
+// the state package for more details. Changes to the tracked state are
+// dispatched as synthetic events, like NICKJOINED and PRIVCHANGED.
 //
 // NOTE: Calling this while connected to an IRC server may cause the
 // state tracker to become very confused all over STDERR if logging
@@ -238,13 +398,79 @@ func (conn *Conn) EnableStateTracking() {
 	defer conn.mu.Unlock()
 	if conn.st == nil {
 		n := conn.cfg.Me
-		conn.st = state.NewTracker(n.Nick)
+		st := state.NewTracker(n.Nick)
+		st.OnChange(conn.stateChanged)
+		st.SetHistory(conn.cfg.HistorySize, conn.cfg.HistoryMaxAge)
+		conn.st = st
 		conn.st.NickInfo(n.Nick, n.Ident, n.Host, n.Name)
 		conn.cfg.Me = conn.st.Me()
 		conn.addSTHandlers()
 	}
 }
 
+// RestoreState enables state tracking if necessary, and replaces the
+// tracker's state with the snapshot, e.g. one taken with
+// StateTracker().Snapshot() before a quick reconnect. Connecting wipes the
+// tracker, so call this once connected, for example from a CONNECTED
+// handler, then rejoin the snapshot's channels. When we rejoin a channel
+// found in the snapshot, its modes and nicks are requested again to bring
+// the restored state up to date. If our nick has changed since the
+// snapshot was taken, the state is restored with our current nick, without
+// a NICKRENAMED event, since we didn't change nick on this connection.
+func (conn *Conn) RestoreState(s *state.Snapshot) error {
+	conn.EnableStateTracking()
+	if me := conn.Me(); s != nil && s.Me != me.Nick {
+		s = renameMe(s, me.Nick)
+	}
+	if err := conn.st.Restore(s); err != nil {
+		return err
+	}
+	conn.cfg.Me = conn.st.Me()
+	return nil
+}
+
+// renameMe returns a copy of the snapshot in which our nick is nick. Anyone
+// else using that nick when the snapshot was taken no longer is.
+func renameMe(s *state.Snapshot, nick string) *state.Snapshot {
+	old, ok := s.Nicks[s.Me]
+	if !ok || old == nil {
+		// Restore will reject the snapshot anyway.
+		return s
+	}
+	r := &state.Snapshot{
+		Me:       nick,
+		Nicks:    make(map[string]*state.Nick, len(s.Nicks)),
+		Channels: make(map[string]*state.Channel, len(s.Channels)),
+	}
+	for n, nk := range s.Nicks {
+		if n != s.Me && n != nick {
+			r.Nicks[n] = nk
+		}
+	}
+	me := *old
+	me.Nick = nick
+	r.Nicks[nick] = &me
+	for c, ch := range s.Channels {
+		if ch == nil {
+			r.Channels[c] = ch
+			continue
+		}
+		rc := *ch
+		rc.Nicks = make(map[string]*state.ChanPrivs, len(ch.Nicks))
+		for n, cp := range ch.Nicks {
+			switch n {
+			case s.Me:
+				rc.Nicks[nick] = cp
+			case nick:
+			default:
+				rc.Nicks[n] = cp
+			}
+		}
+		r.Channels[c] = &rc
+	}
+	return r
+}
+
 // DisableStateTracking causes the client to stop tracking information
 // about the channels and nicks it knows of. It will also wipe current
 // state from the state tracker.
@@ -266,6 +492,15 @@ func (conn *Conn) initialise() {
 	conn.in = make(chan *Line, 32)
 	conn.out = make(chan string, 32)
 	conn.die = make(chan struct{})
+	conn.isupport.wipe()
+	conn.caps.wipe()
+	conn.hostmask.wipe()
+	conn.names.wipe()
+	conn.batches.wipe()
+	conn.chathistory.wipe()
+	conn.sent.wipe()
+	conn.resyncs.wipe()
+	conn.whoq.wipe()
 	if conn.st != nil {
 		conn.st.Wipe()
 	}
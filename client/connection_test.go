@@ -58,6 +58,7 @@ func setUp(t *testing.T, start ...bool) (*Conn, *testState) {
 	c.st = st
 	c.sock = nc
 	c.cfg.Flood = true // Tests can take a while otherwise
+	c.cfg.WhoInterval = 0
 	c.connected = true
 	// If a second argument is passed to setUp, we tell postConnect not to
 	// start the various goroutines that shuttle data around.
@@ -155,8 +156,9 @@ func TestClientAndStateTracking(t *testing.T) {
 
 	// Finally, check state tracking handlers were all removed correctly
 	for k, _ := range stHandlers {
-		if _, ok := c.intHandlers.set[strings.ToLower(k)]; ok && k != "NICK" {
-			// A bit leaky, because intHandlers adds a NICK handler.
+		_, internal := intHandlers[k]
+		if _, ok := c.intHandlers.set[strings.ToLower(k)]; ok && !internal {
+			// A bit leaky, because intHandlers adds NICK, 353 and 366 handlers.
 			t.Errorf("State handler for '%s' not removed correctly.", k)
 		}
 	}
@@ -350,10 +352,15 @@ func TestRecv(t *testing.T) {
 		t.Errorf("Bad first line received on input channel")
 	}
 
-	// Send a second line, just to be sure.
-	s.nc.Send(":irc.server.org 002 test :Second test line.")
+	// Send a second line, just to be sure. Lines are timed by when we
+	// receive them, even if the server says when it sent them.
+	s.nc.Send("@time=2019-01-02T03:04:05.000Z :irc.server.org 002 test :Second test line.")
 	if l := reader(); l == nil || l.Cmd != "002" {
 		t.Errorf("Bad second line received on input channel.")
+	} else if time.Since(l.Time) > time.Second {
+		t.Errorf("Line timed by server: %s", l.Time)
+	} else if m := serverTime(l); m.Year() != 2019 {
+		t.Errorf("serverTime() = %s", m)
 	}
 
 	// Test that recv does something useful with a line it can't parse
@@ -583,3 +590,152 @@ func TestRateLimit(t *testing.T) {
 		t.Errorf("l=%d, badness=%d", l, c.badness)
 	}
 }
+
+func TestRestoreState(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+
+	old := state.NewTracker("oldnick")
+	old.NewChannel("#test1")
+	old.NewNick("user1")
+	old.Associate("#test1", "oldnick")
+	old.Associate("#test1", "user1")
+	old.Topic("#test1", "a topic")
+
+	if err := c.RestoreState(&state.Snapshot{}); err == nil {
+		t.Errorf("Restoring an empty snapshot succeeded.")
+	}
+	// Restoring with our current nick isn't a change of nick.
+	renamed := callCheck(t)
+	c.HandleFunc(NICKRENAMED, func(*Conn, *Line) { renamed.call() })
+	snap := old.Snapshot()
+	if err := c.RestoreState(snap); err != nil {
+		t.Fatalf("Restoring snapshot failed: %s", err)
+	}
+	renamed.assertNotCalled("NICKRENAMED dispatched when restoring state.")
+	if snap.Me != "oldnick" {
+		t.Errorf("Restoring state changed the snapshot.")
+	}
+	if c.Me().Nick != "test" {
+		t.Errorf("Restored state didn't keep current nick, got %s.", c.Me().Nick)
+	}
+	ch := c.StateTracker().GetChannel("#test1")
+	if ch == nil || ch.Topic != "a topic" {
+		t.Fatalf("Channel not restored correctly: %v", ch)
+	}
+	if _, ok := ch.IsOn("test"); !ok {
+		t.Errorf("Renamed me not on restored channel.")
+	}
+
+	// Rejoining a restored channel should refresh our view of it.
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	// Others rejoining still update what extended-join tells us.
+	c.dispatch(ParseLine(":user1!ident1@host1.com JOIN #test1 acct :Real Name"))
+	if nk := c.StateTracker().GetNick("user1"); nk == nil || nk.Account != "acct" ||
+		nk.Name != "Real Name" || nk.Ident != "ident1" {
+		t.Errorf("Rejoining nick not updated: %#v", nk)
+	}
+}
+
+func TestLocalHistory(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+	if h := c.LocalHistory("#test1", 0); h != nil {
+		t.Errorf("History returned without state tracking: %v", h)
+	}
+	c.cfg.HistorySize = 2
+	c.EnableStateTracking()
+
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	c.dispatch(ParseLine(":user1!ident1@host1.com JOIN :#test1"))
+	s.nc.Expect("WHO user1")
+	c.dispatch(ParseLine(":user1!ident1@host1.com PRIVMSG #test1 :one"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com PRIVMSG #test1 :\001ACTION two\001"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com NOTICE #test1 :three"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com PRIVMSG test :hello"))
+
+	h := c.LocalHistory("#test1", 0)
+	if len(h) != 2 || h[0].Kind != ACTION || h[0].Text != "two" ||
+		h[1].Kind != NOTICE || h[1].Text != "three" {
+		t.Errorf("Channel history incorrect: %#v", h)
+	}
+	if h = c.LocalHistory("user1", 0); len(h) != 1 || h[0].Text != "hello" ||
+		h[0].Ident != "ident1" || h[0].Host != "host1.com" {
+		t.Errorf("Private history incorrect: %#v", h)
+	}
+	c.dispatch(ParseLine(":user1!ident1@host1.com NICK :user2"))
+	if h = c.LocalHistory("user2", 0); len(h) != 1 {
+		t.Errorf("Private history not renamed: %#v", h)
+	}
+
+	// Our own messages are kept too.
+	c.Privmsg("user2", "hi\nthere")
+	s.nc.Expect("PRIVMSG user2 :hi")
+	s.nc.Expect("PRIVMSG user2 :there")
+	c.Action("#test1", "waves")
+	s.nc.Expect("PRIVMSG #test1 :\001ACTION waves\001")
+	c.Notice("#test1", "four")
+	s.nc.Expect("NOTICE #test1 :four")
+	if h = c.LocalHistory("user2", 0); len(h) != 2 || h[1].Nick != "test" ||
+		h[1].Target != "user2" || h[0].Text != "hi" || h[1].Text != "there" {
+		t.Errorf("Private history missing our message: %#v", h)
+	}
+	h = c.LocalHistory("#test1", 0)
+	if len(h) != 2 || h[0].Kind != ACTION || h[0].Nick != "test" ||
+		h[0].Text != "waves" || h[1].Kind != NOTICE || h[1].Text != "four" {
+		t.Errorf("Channel history missing our messages: %#v", h)
+	}
+
+	// Private history is dropped when the other nick quits.
+	c.dispatch(ParseLine(":user2!ident1@host1.com QUIT :bye"))
+	if h = c.LocalHistory("user2", 0); h != nil {
+		t.Errorf("Private history kept after quit: %#v", h)
+	}
+}
+
+func TestLocalHistorySent(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+	c.cfg.HistorySize = 10
+	c.EnableStateTracking()
+
+	// Vetoed messages aren't kept, and rewritten ones are kept as sent.
+	c.HandleOut(PRIVMSG, OutHandlerFunc(func(*Conn, *Line) bool {
+		return false
+	}), InChannel("#quiet"))
+	c.HandleOut(PRIVMSG, OutHandlerFunc(func(_ *Conn, l *Line) bool {
+		l.Args[1] = strings.ToUpper(l.Args[1])
+		return true
+	}), InChannel("#loud"))
+	c.Privmsg("#quiet", "hello")
+	s.nc.ExpectNothing()
+	if h := c.LocalHistory("#quiet", 0); h != nil {
+		t.Errorf("Vetoed message kept: %#v", h)
+	}
+	c.Privmsg("#loud", "hello")
+	s.nc.Expect("PRIVMSG #loud :HELLO")
+	if h := c.LocalHistory("#loud", 0); len(h) != 1 || h[0].Text != "HELLO" {
+		t.Errorf("Rewritten message not kept as sent: %#v", h)
+	}
+
+	// Multiline batches are kept as one message.
+	c.caps.enabled["batch"] = true
+	c.caps.enabled[MULTILINE] = true
+	batchRef = 0
+	c.Notice("#loud", "line1\nline2")
+	s.nc.Expect("BATCH +ml1 draft/multiline #loud")
+	s.nc.Expect("@batch=ml1 NOTICE #loud :line1")
+	s.nc.Expect("@batch=ml1 NOTICE #loud :line2")
+	s.nc.Expect("BATCH -ml1")
+	if h := c.LocalHistory("#loud", 0); len(h) != 2 || h[1].Kind != NOTICE ||
+		h[1].Nick != "test" || h[1].Text != "line1\nline2" {
+		t.Errorf("Multiline message not kept as one: %#v", h)
+	}
+}
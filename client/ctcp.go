@@ -0,0 +1,165 @@
+package client
+
+// This file contains the registry of responders that answer CTCP requests,
+// and the rate limiting of their replies.
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CLIENTINFO = "CLIENTINFO"
+	SOURCE     = "SOURCE"
+	TIME       = "TIME"
+	USERINFO   = "USERINFO"
+
+	// By default we reply to at most defaultCtcpBurst CTCP requests in
+	// quick succession, then one per defaultCtcpInterval. This is slower
+	// than flood protection allows lines to be sent, so that replies
+	// can't use it all up.
+	defaultCtcpBurst    = 3
+	defaultCtcpInterval = 5 * time.Second
+	defaultSource       = "https://github.com/fluffle/goirc"
+)
+
+// A CtcpResponder answers a CTCP request, returning the argument of the
+// reply to send, and false if no reply should be sent.
+type CtcpResponder func(conn *Conn, line *Line) (string, bool)
+
+// defaultResponders answer the CTCP requests every client should, and
+// handle DCC offers.
+var defaultResponders = map[string]CtcpResponder{
+	CLIENTINFO: func(conn *Conn, _ *Line) (string, bool) {
+		return strings.Join(conn.CtcpResponders(), " "), true
+	},
+	DCC: func(conn *Conn, line *Line) (string, bool) {
+		// Offers are dispatched as events, and not replied to.
+		conn.h_DCC(line)
+		return "", false
+	},
+	PING: func(_ *Conn, line *Line) (string, bool) {
+		return line.ctcpText(), true
+	},
+	SOURCE: func(conn *Conn, _ *Line) (string, bool) {
+		return conn.cfg.Source, conn.cfg.Source != ""
+	},
+	TIME: func(_ *Conn, _ *Line) (string, bool) {
+		return time.Now().Format(time.RFC1123Z), true
+	},
+	USERINFO: func(conn *Conn, _ *Line) (string, bool) {
+		return conn.cfg.UserInfo, conn.cfg.UserInfo != ""
+	},
+	VERSION: func(conn *Conn, _ *Line) (string, bool) {
+		return conn.cfg.Version, true
+	},
+}
+
+// ctcp holds the responders for each CTCP request, and the state of the
+// token bucket limiting how often we reply.
+type ctcp struct {
+	mu         sync.Mutex
+	responders map[string]CtcpResponder
+	tokens     float64
+	last       time.Time
+}
+
+func newCtcp() *ctcp {
+	c := &ctcp{responders: make(map[string]CtcpResponder)}
+	for k, r := range defaultResponders {
+		c.responders[k] = r
+	}
+	return c
+}
+
+// allow returns true if a reply may be sent now, given that burst replies
+// may be sent in quick succession, then one per interval. Either is the
+// default if not set, and a negative burst means there's no limit.
+func (c *ctcp) allow(burst int, interval time.Duration) bool {
+	if burst < 0 {
+		return true
+	}
+	if burst == 0 {
+		burst = defaultCtcpBurst
+	}
+	if interval <= 0 {
+		interval = defaultCtcpInterval
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.last.IsZero() {
+		c.tokens = float64(burst)
+	} else {
+		c.tokens += float64(now.Sub(c.last)) / float64(interval)
+	}
+	if c.tokens > float64(burst) {
+		c.tokens = float64(burst)
+	}
+	c.last = now
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// SetCtcpResponder sets the responder that answers CTCP requests of the
+// given type, e.g. "VERSION", replacing any already set, including the
+// defaults for CLIENTINFO, PING, SOURCE, TIME, USERINFO and VERSION.
+// Setting a nil responder stops the client answering those requests; for
+// DCC, it stops the client handling DCC offers at all.
+func (conn *Conn) SetCtcpResponder(ctcp string, r CtcpResponder) {
+	c := conn.ctcp
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r == nil {
+		delete(c.responders, strings.ToUpper(ctcp))
+	} else {
+		c.responders[strings.ToUpper(ctcp)] = r
+	}
+}
+
+// CtcpResponders returns the types of CTCP request the client answers, in
+// alphabetical order, as sent in reply to CLIENTINFO.
+func (conn *Conn) CtcpResponders() []string {
+	c := conn.ctcp
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctcps := make([]string, 0, len(c.responders))
+	for k := range c.responders {
+		ctcps = append(ctcps, k)
+	}
+	sort.Strings(ctcps)
+	return ctcps
+}
+
+// Handle CTCP requests with the registered responders. Replies are rate
+// limited separately from other lines, and dropped when over the limit,
+// so a flood of CTCP requests can't delay anything else we send.
+func (conn *Conn) h_CTCP(line *Line) {
+	if !line.argslen(0) {
+		return
+	}
+	ctcp := strings.ToUpper(line.Args[0])
+	conn.ctcp.mu.Lock()
+	r, ok := conn.ctcp.responders[ctcp]
+	conn.ctcp.mu.Unlock()
+	if !ok {
+		return
+	}
+	reply, ok := r(conn, line)
+	if !ok {
+		return
+	}
+	if !conn.ctcp.allow(conn.cfg.CtcpBurst, conn.cfg.CtcpInterval) {
+		return
+	}
+	if reply == "" {
+		conn.CtcpReply(line.Nick, ctcp)
+	} else {
+		conn.CtcpReply(line.Nick, ctcp, reply)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCtcpResponders(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001CLIENTINFO\001"))
+	s.nc.Expect("NOTICE blah :\001CLIENTINFO CLIENTINFO DCC PING SOURCE TIME USERINFO VERSION\001")
+
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001SOURCE\001"))
+	s.nc.Expect("NOTICE blah :\001SOURCE https://github.com/fluffle/goirc\001")
+
+	// USERINFO isn't answered until it is set.
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001USERINFO\001"))
+	s.nc.ExpectNothing()
+	c.cfg.UserInfo = "A bot"
+	// CTCP requests are case-insensitive.
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001userinfo\001"))
+	s.nc.Expect("NOTICE blah :\001USERINFO A bot\001")
+
+	// PING without an argument is answered without one.
+	c.cfg.CtcpBurst = -1
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING\001"))
+	s.nc.Expect("NOTICE blah :\001PING\001")
+
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001TIME\001"))
+	select {
+	case l := <-s.nc.Out:
+		l = strings.TrimRight(l, "\r\n")
+		prefix := "NOTICE blah :\001TIME "
+		if !strings.HasPrefix(l, prefix) {
+			t.Fatalf("Unexpected TIME reply: %q", l)
+		}
+		if _, err := time.Parse(time.RFC1123Z, strings.Trim(l[len(prefix):], "\001")); err != nil {
+			t.Errorf("TIME reply not parsed: %s", err)
+		}
+	case <-time.After(5 * time.Millisecond):
+		t.Errorf("No reply to TIME.")
+	}
+
+	// Responders can be added, replaced and removed.
+	c.SetCtcpResponder("finger", func(conn *Conn, line *Line) (string, bool) {
+		return "poke " + line.Nick, true
+	})
+	c.SetCtcpResponder(VERSION, func(*Conn, *Line) (string, bool) {
+		return "", false
+	})
+	c.SetCtcpResponder(TIME, nil)
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001FINGER\001"))
+	s.nc.Expect("NOTICE blah :\001FINGER poke blah\001")
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001VERSION\001"))
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001TIME\001"))
+	s.nc.ExpectNothing()
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001CLIENTINFO\001"))
+	s.nc.Expect("NOTICE blah :\001CLIENTINFO CLIENTINFO DCC FINGER PING SOURCE USERINFO VERSION\001")
+}
+
+func TestCtcpRateLimit(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.CtcpBurst = 2
+	c.cfg.CtcpInterval = time.Hour
+
+	// Only the first two of a flood of requests are answered.
+	for i := 0; i < 5; i++ {
+		c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 1\001"))
+	}
+	s.nc.Expect("NOTICE blah :\001PING 1\001")
+	s.nc.Expect("NOTICE blah :\001PING 1\001")
+	s.nc.ExpectNothing()
+
+	// After the interval, another is answered.
+	c.ctcp.last = c.ctcp.last.Add(-time.Hour)
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 2\001"))
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 3\001"))
+	s.nc.Expect("NOTICE blah :\001PING 2\001")
+	s.nc.ExpectNothing()
+
+	// Requests without a reply don't count.
+	c.ctcp.last = c.ctcp.last.Add(-time.Hour)
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001USERINFO\001"))
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 4\001"))
+	s.nc.Expect("NOTICE blah :\001PING 4\001")
+
+	// Without a limit set, the defaults apply.
+	c.cfg.CtcpBurst, c.cfg.CtcpInterval = 0, 0
+	c.ctcp.last = time.Time{}
+	for i := 0; i < 5; i++ {
+		c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 5\001"))
+	}
+	for i := 0; i < defaultCtcpBurst; i++ {
+		s.nc.Expect("NOTICE blah :\001PING 5\001")
+	}
+	s.nc.ExpectNothing()
+	c.ctcp.last = c.ctcp.last.Add(-defaultCtcpInterval)
+	c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 6\001"))
+	s.nc.Expect("NOTICE blah :\001PING 6\001")
+}
@@ -0,0 +1,351 @@
+package client
+
+// This file contains the code common to all kinds of DCC session: parsing
+// offers sent to us with CTCP DCC, making offers of our own, and opening the
+// direct connections between clients that they describe.
+// https://modern.ircdocs.horse/dcc.html
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+const (
+	DCC = "DCC"
+
+	// By default, DCC connections are given up on if they aren't made
+	// within defaultDCCTimeout.
+	defaultDCCTimeout = 2 * time.Minute
+)
+
+// DCCOffer is an offer of a DCC session, sent to us with CTCP DCC.
+//     :nick!user@host PRIVMSG us :\001DCC CHAT chat <ip> <port> [token]\001
+//     :nick!user@host PRIVMSG us :\001DCC SEND <file> <ip> <port> [size [token]]\001
+// If the port is zero the offer is passive, or reverse: the sender can't
+// accept connections, so asks us to listen instead, and includes a token
+// to identify our reply. The requests to resume a DCC SEND part way through,
+// and the replies to them, are parsed into DCCOffers too, though they have
+// no IP address.
+//     :nick!user@host PRIVMSG us :\001DCC RESUME <file> <port> <position> [token]\001
+//     :nick!user@host PRIVMSG us :\001DCC ACCEPT <file> <port> <position> [token]\001
+type DCCOffer struct {
+	Event
+	// The type of session, e.g. "CHAT", and its argument, which is "chat"
+	// for a DCC CHAT and the name of the file for the others.
+	Type, Arg string
+	// The address to connect to. IPv4 addresses are sent as a decimal
+	// integer, and IPv6 addresses as text.
+	IP   net.IP
+	Port int
+	// The size of the file offered with DCC SEND, or 0 if not given, and
+	// the position to resume from given with DCC RESUME and ACCEPT.
+	Size, Position int64
+	// The token identifying a passive offer and the reply to it.
+	Token string
+}
+
+// Passive returns true if the sender of the offer wants us to listen for
+// the connection, rather than connecting to them.
+func (o *DCCOffer) Passive() bool {
+	return o.Port == 0
+}
+
+// Addr returns the address the offer asks us to connect to, as host:port.
+func (o *DCCOffer) Addr() string {
+	return net.JoinHostPort(o.IP.String(), strconv.Itoa(o.Port))
+}
+
+// newDCCOffer parses the CTCP DCC in line, whose Args are "DCC", the target
+// and the offer itself.
+func newDCCOffer(line *Line) (*DCCOffer, bool) {
+	if !eventArgs(line, 3) {
+		return nil, false
+	}
+	f := splitDCCArgs(line.Args[2])
+	if len(f) < 4 {
+		logging.Warn("irc.DCC: too few arguments in offer: %s", line.Args[2])
+		return nil, false
+	}
+	o := &DCCOffer{
+		Event: newEvent(line),
+		Type:  strings.ToUpper(f[0]),
+		Arg:   f[1],
+	}
+	var port string
+	var rest []string
+	var err error
+	switch o.Type {
+	case "RESUME", "ACCEPT":
+		// These have no address, and a position instead of a size.
+		port, rest = f[2], f[4:]
+		o.Position, err = parseDCCInt(f[3])
+	default:
+		port, rest = f[3], f[4:]
+		if o.Type == "SEND" && len(rest) > 0 {
+			o.Size, err = parseDCCInt(rest[0])
+			rest = rest[1:]
+		}
+		if o.IP = parseDCCIP(f[2]); o.IP == nil {
+			err = fmt.Errorf("bad address %q", f[2])
+		}
+	}
+	if len(rest) > 0 {
+		o.Token = rest[0]
+	}
+	if err == nil {
+		o.Port, err = strconv.Atoi(port)
+		if err == nil && (o.Port < 0 || o.Port > 65535) {
+			err = fmt.Errorf("bad port %d", o.Port)
+		}
+	}
+	if err != nil {
+		logging.Warn("irc.DCC: bad offer %q: %s", line.Args[2], err)
+		return nil, false
+	}
+	return o, true
+}
+
+// parseDCCInt parses a size or position in a DCC offer.
+func parseDCCInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("negative number %d", n)
+	}
+	return n, err
+}
+
+// key identifies the offer that a RESUME or ACCEPT refers to, by the port
+// it is on if it is active and its token if it is passive. It is the same
+// for the offer itself.
+func (o *DCCOffer) key() string {
+	return strconv.Itoa(o.Port) + " " + o.Token
+}
+
+// splitDCCArgs splits the arguments of a CTCP DCC at spaces, except those
+// inside double quotes, which some clients put around file names.
+func splitDCCArgs(s string) []string {
+	var args []string
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		end := strings.IndexByte(s, ' ')
+		if s[0] == '"' {
+			if idx := strings.IndexByte(s[1:], '"'); idx != -1 {
+				args = append(args, s[1:idx+1])
+				s = s[idx+2:]
+				continue
+			}
+		}
+		if end == -1 {
+			end = len(s)
+		}
+		args = append(args, s[:end])
+		s = s[end:]
+	}
+	return args
+}
+
+// parseDCCIP parses the address in a DCC offer, which is an IPv4 address as
+// a decimal integer, or an IPv6 address in the usual form. Some clients send
+// IPv4 addresses in dotted form too. It returns nil if s is neither.
+func parseDCCIP(s string) net.IP {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip
+	}
+	return net.ParseIP(s)
+}
+
+// dccIP formats ip for a DCC offer.
+func dccIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	return ip.String()
+}
+
+// dcc keeps track of the DCC requests we've sent that are waiting for a
+// reply: passive offers waiting to be told where to connect, offers of
+// files that may be asked to resume, and requests to resume them.
+type dcc struct {
+	mu      sync.Mutex
+	token   uint64
+	pending map[string]*dccPending
+}
+
+type dccPending struct {
+	nick  string
+	reply func(*DCCOffer)
+	fail  func(error)
+	timer *time.Timer
+}
+
+func newDCC() *dcc {
+	return &dcc{pending: make(map[string]*dccPending)}
+}
+
+// newToken returns a token for a passive offer.
+func (d *dcc) newToken() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.token++
+	return strconv.FormatUint(d.token, 10)
+}
+
+// expect calls reply when nick replies to the request identified by key,
+// which is the type of the reply and the key of the offer it refers to. If
+// they don't reply within timeout, fail is called with an error instead.
+func (d *dcc) expect(key, nick string, timeout time.Duration, reply func(*DCCOffer), fail func(error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := &dccPending{nick: nick, reply: reply, fail: fail}
+	if timeout > 0 {
+		p.timer = time.AfterFunc(timeout, func() {
+			if p := d.take(key, nil); p != nil && p.fail != nil {
+				p.fail(fmt.Errorf("irc.DCC: no reply from %s", nick))
+			}
+		})
+	}
+	d.pending[key] = p
+}
+
+// take removes and returns the request with key, if from is nil or returns
+// true for the nick it was sent to.
+func (d *dcc) take(key string, from func(nick string) bool) *dccPending {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.pending[key]
+	if !ok || (from != nil && !from(p.nick)) {
+		return nil
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(d.pending, key)
+	return p
+}
+
+// cancel withdraws the request with key, calling its fail func.
+func (d *dcc) cancel(key string) {
+	if p := d.take(key, nil); p != nil && p.fail != nil {
+		p.fail(fmt.Errorf("irc.DCC: request to %s closed", p.nick))
+	}
+}
+
+// dccReply passes o to the request it is a reply to, if any, returning
+// false if there isn't one.
+func (conn *Conn) dccReply(key string, o *DCCOffer) bool {
+	m := conn.Matcher()
+	p := conn.dcc.take(key, func(nick string) bool {
+		return m.Equal(nick, o.Sender.Nick)
+	})
+	if p == nil {
+		return false
+	}
+	p.reply(o)
+	return true
+}
+
+// dccListen listens for a DCC connection on Config.DCCListenAddr, using the
+// first free port between Config.DCCPortMin and Config.DCCPortMax.
+func (conn *Conn) dccListen() (net.Listener, error) {
+	min, max := conn.cfg.DCCPortMin, conn.cfg.DCCPortMax
+	if max < min {
+		max = min
+	}
+	var err error
+	for port := min; port <= max; port++ {
+		var ln net.Listener
+		addr := net.JoinHostPort(conn.cfg.DCCListenAddr, strconv.Itoa(port))
+		if ln, err = net.Listen("tcp", addr); err == nil {
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("irc.DCC: cannot listen for connections: %s", err)
+}
+
+// dccPublicIP returns the address to put in our offers: Config.DCCPublicIP
+// if set, otherwise the address ln is listening on, or failing that the
+// local address of our connection to the server. ln may be nil.
+func (conn *Conn) dccPublicIP(ln net.Listener) (net.IP, error) {
+	if conn.cfg.DCCPublicIP != "" {
+		if ip := net.ParseIP(conn.cfg.DCCPublicIP); ip != nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("irc.DCC: invalid DCCPublicIP %q", conn.cfg.DCCPublicIP)
+	}
+	if ln != nil {
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP, nil
+		}
+	}
+	conn.mu.RLock()
+	sock := conn.sock
+	conn.mu.RUnlock()
+	if sock != nil {
+		if addr, ok := sock.LocalAddr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("irc.DCC: cannot find an address to offer; set DCCPublicIP")
+}
+
+// dccAccept waits in the background for a connection to ln, for at most
+// Config.DCCTimeout, then closes ln and calls done.
+func (conn *Conn) dccAccept(ln net.Listener, done func(net.Conn, error)) {
+	if t := conn.cfg.DCCTimeout; t > 0 {
+		if d, ok := ln.(interface{ SetDeadline(time.Time) error }); ok {
+			d.SetDeadline(time.Now().Add(t))
+		}
+	}
+	go func() {
+		s, err := ln.Accept()
+		ln.Close()
+		done(s, err)
+	}()
+}
+
+// dccDial connects to addr in the background, from the same local address
+// as our connection to the server, then calls done.
+func (conn *Conn) dccDial(addr string, done func(net.Conn, error)) {
+	d := *conn.dialer
+	d.Timeout = conn.cfg.DCCTimeout
+	go func() {
+		done(d.Dial("tcp", addr))
+	}()
+}
+
+// Handle CTCP DCC offers. Replies to our own requests are passed on to
+// them; other offers are dispatched as events for each type.
+func (conn *Conn) h_DCC(line *Line) {
+	if line.Public() {
+		// Offers are only ever made to nicks.
+		return
+	}
+	o, ok := newDCCOffer(line)
+	if !ok {
+		return
+	}
+	if o.Token != "" && !o.Passive() && conn.dccReply(o.Type+" "+o.Token, o) {
+		return
+	}
+	switch o.Type {
+	case "CHAT", "SEND":
+		line = line.Copy()
+		line.Cmd = DCC + o.Type
+		conn.dispatch(line)
+	case "RESUME", "ACCEPT":
+		if !conn.dccReply(o.Type+" "+o.key(), o) {
+			logging.Warn("irc.DCC: %s from %s for unknown offer: %s",
+				o.Type, line.Nick, line.Args[2])
+		}
+	default:
+		logging.Debug("irc.DCC: ignoring unsupported offer %s from %s", o.Type, line.Nick)
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDCCOffer(t *testing.T) {
+	tests := []struct {
+		in      string
+		ok      bool
+		typ     string
+		arg     string
+		ip      string
+		port    int
+		token   string
+		passive bool
+	}{
+		{"CHAT chat 2130706433 1234", true, "CHAT", "chat", "127.0.0.1", 1234, "", false},
+		{"chat chat 3232235777 0 42", true, "CHAT", "chat", "192.168.1.1", 0, "42", true},
+		{"CHAT chat ::1 1234", true, "CHAT", "chat", "::1", 1234, "", false},
+		{"CHAT chat 2001:db8::1 0 7", true, "CHAT", "chat", "2001:db8::1", 0, "7", true},
+		{"CHAT chat 10.0.0.1 1234", true, "CHAT", "chat", "10.0.0.1", 1234, "", false},
+		{"CHAT chat nowhere 1234", false, "", "", "", 0, "", false},
+		{"CHAT chat 2130706433 65536", false, "", "", "", 0, "", false},
+		{"CHAT chat 2130706433", false, "", "", "", 0, "", false},
+		{"CHAT chat 2130706433 x", false, "", "", "", 0, "", false},
+	}
+	for _, test := range tests {
+		line := ParseLine(":nick!ident@host PRIVMSG test :\001DCC " + test.in + "\001")
+		o, ok := newDCCOffer(line)
+		if ok != test.ok {
+			t.Errorf("newDCCOffer(%q) ok = %t", test.in, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if o.Type != test.typ || o.Arg != test.arg || o.Port != test.port ||
+			o.Token != test.token || o.Passive() != test.passive ||
+			!o.IP.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("newDCCOffer(%q) = %#v", test.in, o)
+		}
+		if o.Sender.Nick != "nick" || o.Sender.Host != "host" {
+			t.Errorf("newDCCOffer(%q) sender = %#v", test.in, o.Sender)
+		}
+	}
+	o, _ := newDCCOffer(ParseLine(":n!i@h PRIVMSG test :\001DCC CHAT chat ::1 1234\001"))
+	if addr := o.Addr(); addr != "[::1]:1234" {
+		t.Errorf("Addr() = %q", addr)
+	}
+}
+
+func TestDCCOfferSend(t *testing.T) {
+	tests := []struct {
+		in             string
+		ok             bool
+		typ, arg       string
+		port           int
+		size, position int64
+		token, key     string
+	}{
+		{`SEND file.txt 2130706433 1234 5000`, true, "SEND", "file.txt", 1234, 5000, 0, "", "1234 "},
+		{`SEND "my file.txt" 2130706433 0 5000 9`, true, "SEND", "my file.txt", 0, 5000, 0, "9", "0 9"},
+		{`SEND file.txt 2130706433 1234`, true, "SEND", "file.txt", 1234, 0, 0, "", "1234 "},
+		{`RESUME file.txt 1234 2000`, true, "RESUME", "file.txt", 1234, 0, 2000, "", "1234 "},
+		{`ACCEPT "my file.txt" 0 2000 9`, true, "ACCEPT", "my file.txt", 0, 0, 2000, "9", "0 9"},
+		{`SEND file.txt 2130706433 1234 -1`, false, "", "", 0, 0, 0, "", ""},
+		{`SEND file.txt 2130706433 1234 big`, false, "", "", 0, 0, 0, "", ""},
+		{`RESUME file.txt 1234 -5`, false, "", "", 0, 0, 0, "", ""},
+		{`RESUME file.txt 1234`, false, "", "", 0, 0, 0, "", ""},
+	}
+	for _, test := range tests {
+		o, ok := newDCCOffer(ParseLine(":nick!ident@host PRIVMSG test :\001DCC " + test.in + "\001"))
+		if ok != test.ok {
+			t.Errorf("newDCCOffer(%q) ok = %t", test.in, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if o.Type != test.typ || o.Arg != test.arg || o.Port != test.port ||
+			o.Size != test.size || o.Position != test.position ||
+			o.Token != test.token || o.key() != test.key {
+			t.Errorf("newDCCOffer(%q) = %#v", test.in, o)
+		}
+	}
+}
+
+func TestSplitDCCArgs(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{"CHAT chat 1 2", []string{"CHAT", "chat", "1", "2"}},
+		{"  CHAT  chat 1 2 ", []string{"CHAT", "chat", "1", "2"}},
+		{`SEND "my file.txt" 1 2 3`, []string{"SEND", "my file.txt", "1", "2", "3"}},
+		{`SEND "unterminated 1 2`, []string{"SEND", `"unterminated`, "1", "2"}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		if got := splitDCCArgs(test.in); !reflect.DeepEqual(got, test.out) {
+			t.Errorf("splitDCCArgs(%q) = %q, expected %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestDCCIP(t *testing.T) {
+	for ip, s := range map[string]string{
+		"127.0.0.1":   "2130706433",
+		"192.168.1.1": "3232235777",
+		"::1":         "::1",
+		"2001:db8::1": "2001:db8::1",
+	} {
+		if got := dccIP(net.ParseIP(ip)); got != s {
+			t.Errorf("dccIP(%s) = %q, expected %q", ip, got, s)
+		}
+		if got := parseDCCIP(s); !got.Equal(net.ParseIP(ip)) {
+			t.Errorf("parseDCCIP(%q) = %s, expected %s", s, got, ip)
+		}
+	}
+}
+
+func TestDCCListen(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCListenAddr = "127.0.0.1"
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	c.cfg.DCCPortMin, c.cfg.DCCPortMax = port, port
+	if ln, err := c.dccListen(); err == nil {
+		ln.Close()
+		t.Errorf("Listened on busy port %d.", port)
+	}
+	// The next free port in the range is used.
+	c.cfg.DCCPortMax = port + 10
+	ln, err := c.dccListen()
+	if err != nil {
+		t.Fatalf("Couldn't listen on ports %d-%d: %s", port, port+10, err)
+	}
+	defer ln.Close()
+	if p := ln.Addr().(*net.TCPAddr).Port; p <= port || p > port+10 {
+		t.Errorf("Listened on port %d, outside %d-%d.", p, port+1, port+10)
+	}
+
+	// The listener's address is offered if it is specific, otherwise
+	// that of our connection to the server, unless DCCPublicIP is set.
+	if ip, err := c.dccPublicIP(ln); err != nil || !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("dccPublicIP() = %s, %v", ip, err)
+	}
+	c.cfg.DCCPublicIP = "192.0.2.1"
+	if ip, err := c.dccPublicIP(ln); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("dccPublicIP() = %s, %v", ip, err)
+	}
+	c.cfg.DCCPublicIP = "not an ip"
+	if _, err := c.dccPublicIP(ln); err == nil {
+		t.Errorf("dccPublicIP() didn't fail with bad DCCPublicIP.")
+	}
+}
+
+func TestDCCPending(t *testing.T) {
+	d := newDCC()
+	replies := make(chan *DCCOffer, 1)
+	fails := make(chan error, 1)
+	reply := func(o *DCCOffer) { replies <- o }
+	fail := func(err error) { fails <- err }
+
+	d.expect("CHAT 1", "nick", 0, reply, fail)
+	if d.take("CHAT 1", func(nick string) bool { return nick == "other" }) != nil {
+		t.Errorf("Request to nick taken by other.")
+	}
+	if d.take("CHAT 1", func(nick string) bool { return nick == "nick" }) == nil {
+		t.Errorf("Request to nick not taken.")
+	}
+	if d.take("CHAT 1", nil) != nil {
+		t.Errorf("Request taken twice.")
+	}
+
+	// Requests are given up on after the timeout.
+	d.expect("CHAT 2", "nick", time.Millisecond, reply, fail)
+	select {
+	case err := <-fails:
+		if err == nil || !strings.Contains(err.Error(), "no reply") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Request didn't time out.")
+	}
+	if d.take("CHAT 2", nil) != nil {
+		t.Errorf("Request still pending after timeout.")
+	}
+
+	// And can be cancelled.
+	d.expect("CHAT 3", "nick", 0, reply, fail)
+	d.cancel("CHAT 3")
+	if err := <-fails; err == nil {
+		t.Errorf("Cancelled request had no error.")
+	}
+
+	// Replies are matched to requests by the nick they came from.
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.dcc.expect("ACCEPT 1234 ", "Nick", 0, reply, fail)
+	o, _ := newDCCOffer(ParseLine(":other!i@h PRIVMSG test :\001DCC ACCEPT file 1234 10\001"))
+	if c.dccReply("ACCEPT "+o.key(), o) {
+		t.Errorf("Reply from other accepted.")
+	}
+	o, _ = newDCCOffer(ParseLine(":nick!i@h PRIVMSG test :\001DCC ACCEPT file 1234 10\001"))
+	if !c.dccReply("ACCEPT "+o.key(), o) || <-replies != o {
+		t.Errorf("Reply from nick not accepted.")
+	}
+
+	for i := 1; i < 3; i++ {
+		if token, err := strconv.Atoi(d.newToken()); err != nil || token != i {
+			t.Errorf("Token %d is %d, %v.", i, token, err)
+		}
+	}
+}
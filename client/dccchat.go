@@ -0,0 +1,294 @@
+package client
+
+// This file contains DCC CHAT sessions, which send lines of text directly
+// between two clients rather than through the server.
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DCCCHAT is dispatched when someone offers us a DCC CHAT session. Its Args
+// are those of the CTCP DCC: "DCC", our nick, and the offer itself. Use
+// OnDCCChat to receive these as parsed DCCOffers, and DCCChat.Accept to
+// accept them.
+const DCCCHAT = "DCCCHAT"
+
+// DCCChat is a DCC CHAT session with another client. Each line they send is
+// dispatched to the session's own handlers as a PRIVMSG from them to us, or
+// an ACTION if it is a CTCP ACTION. CONNECTED is dispatched when the
+// connection is made, and DISCONNECTED when it is closed, or if it couldn't
+// be made, in which case Args[0] is the reason why. For example:
+//
+//     chat := conn.NewDCCChat("nick")
+//     chat.HandleFunc(PRIVMSG, func(conn *Conn, line *Line) {
+//         chat.Privmsg("You said: " + line.Text())
+//     })
+//     if err := chat.Offer(); err != nil {
+//         // We couldn't listen for a connection.
+//     }
+//
+type DCCChat struct {
+	conn     *Conn
+	handlers *hSet
+	// The other client's nick, and nick!ident@host if known.
+	nick, src string
+
+	mu      sync.Mutex
+	sock    net.Conn
+	started bool
+	closed  bool
+	// Stops us waiting for the connection.
+	cancel func()
+}
+
+// NewDCCChat creates a DCC CHAT session with nick. Add handlers to it before
+// calling Offer, OfferPassive or Accept to open the connection.
+func (conn *Conn) NewDCCChat(nick string) *DCCChat {
+	return &DCCChat{
+		conn:     conn,
+		handlers: handlerSet(),
+		nick:     nick,
+		src:      nick,
+	}
+}
+
+// OnDCCChat registers a foreground handler for offers of DCC CHAT sessions.
+// Offers aren't accepted unless the handler calls DCCChat.Accept.
+func (conn *Conn) OnDCCChat(f func(*Conn, *DCCOffer), filters ...Filter) Remover {
+	return conn.HandleFunc(DCCCHAT, func(conn *Conn, line *Line) {
+		if o, ok := newDCCOffer(line); ok {
+			f(conn, o)
+		}
+	}, filters...)
+}
+
+// Nick returns the nick of the other client.
+func (c *DCCChat) Nick() string {
+	return c.nick
+}
+
+// Handle adds the provided handler to the session for the named event,
+// optionally restricted by filters, as Conn.Handle does for the server.
+// It will return a Remover that allows that handler to be removed again.
+func (c *DCCChat) Handle(name string, h Handler, filters ...Filter) Remover {
+	return c.handlers.add(name, h, filters...)
+}
+
+// HandleFunc adds the provided function as a handler for the named event.
+// It will return a Remover that allows that handler to be removed again.
+func (c *DCCChat) HandleFunc(name string, hf HandlerFunc, filters ...Filter) Remover {
+	return c.Handle(name, hf, filters...)
+}
+
+// start marks the session as started, so it can only be opened once, and
+// sets the func that stops us waiting for the connection.
+func (c *DCCChat) start(cancel func()) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started || c.closed {
+		return fmt.Errorf("irc.DCCChat: session with %s already started or closed", c.nick)
+	}
+	c.started, c.cancel = true, cancel
+	return nil
+}
+
+// Offer offers the session to the other client, listening for them to
+// connect to us.
+//     PRIVMSG nick :\001DCC CHAT chat <ip> <port>\001
+func (c *DCCChat) Offer() error {
+	ln, err := c.conn.dccListen()
+	if err != nil {
+		return err
+	}
+	ip, err := c.conn.dccPublicIP(ln)
+	if err == nil {
+		err = c.start(func() { ln.Close() })
+	}
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	c.conn.dccAccept(ln, c.connected)
+	c.conn.Ctcp(c.nick, DCC, "CHAT chat", dccIP(ip), strconv.Itoa(port))
+	return nil
+}
+
+// OfferPassive offers the session to the other client, asking them to
+// listen for us to connect to them, for when we can't accept connections.
+//     PRIVMSG nick :\001DCC CHAT chat <ip> 0 <token>\001
+func (c *DCCChat) OfferPassive() error {
+	ip, err := c.conn.dccPublicIP(nil)
+	if err != nil {
+		// The address in a passive offer isn't used.
+		ip = net.IPv4zero
+	}
+	token := c.conn.dcc.newToken()
+	key := "CHAT " + token
+	c.conn.dcc.expect(key, c.nick, c.conn.cfg.DCCTimeout, func(o *DCCOffer) {
+		c.conn.dccDial(o.Addr(), c.connected)
+	}, func(err error) {
+		c.connected(nil, err)
+	})
+	if err := c.start(func() { c.conn.dcc.cancel(key) }); err != nil {
+		c.conn.dcc.take(key, nil)
+		return err
+	}
+	c.conn.Ctcp(c.nick, DCC, "CHAT chat", dccIP(ip), "0", token)
+	return nil
+}
+
+// Accept accepts an offer of a DCC CHAT session from the other client,
+// connecting to them, or if the offer is passive, listening for them to
+// connect to us and replying with where to do so.
+//     PRIVMSG nick :\001DCC CHAT chat <ip> <port> <token>\001
+func (c *DCCChat) Accept(o *DCCOffer) error {
+	if o.Type != "CHAT" {
+		return fmt.Errorf("irc.DCCChat: cannot accept DCC %s offer", o.Type)
+	}
+	c.src = o.Sender.String()
+	if !o.Passive() {
+		if err := c.start(nil); err != nil {
+			return err
+		}
+		c.conn.dccDial(o.Addr(), c.connected)
+		return nil
+	}
+	ln, err := c.conn.dccListen()
+	if err != nil {
+		return err
+	}
+	ip, err := c.conn.dccPublicIP(ln)
+	if err == nil {
+		err = c.start(func() { ln.Close() })
+	}
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	c.conn.dccAccept(ln, c.connected)
+	c.conn.Ctcp(c.nick, DCC, "CHAT chat", dccIP(ip), strconv.Itoa(port), o.Token)
+	return nil
+}
+
+// connected is called when the connection is made, or fails to be.
+func (c *DCCChat) connected(sock net.Conn, err error) {
+	c.mu.Lock()
+	c.cancel = nil
+	if err == nil && c.closed {
+		sock.Close()
+		err = fmt.Errorf("irc.DCCChat: session with %s closed", c.nick)
+	}
+	if err != nil {
+		c.closed = true
+		c.mu.Unlock()
+		c.dispatch(&Line{Cmd: DISCONNECTED, Args: []string{err.Error()}})
+		return
+	}
+	c.sock = sock
+	c.mu.Unlock()
+	c.dispatch(&Line{Cmd: CONNECTED})
+	c.recv()
+}
+
+// recv reads lines from the other client, ending with "\n" or "\r\n", and
+// dispatches them until the connection is closed.
+func (c *DCCChat) recv() {
+	me, _, _ := c.conn.hostmask.get()
+	if me == "" {
+		me = c.conn.cfg.Me.Nick
+	}
+	r := bufio.NewScanner(c.sock)
+	for r.Scan() {
+		// Parsing the text as a message to us turns CTCP ACTIONs into
+		// ACTIONs, as for the server.
+		if line := ParseLine(":" + c.src + " " + PRIVMSG + " " + me + " :" +
+			trimCR(r.Text())); line != nil {
+			if line.Nick == "" {
+				// We only know the nick of those we made the offer to.
+				line.Nick, line.Host = c.nick, ""
+			}
+			line.Time = time.Now()
+			c.dispatch(line)
+		}
+	}
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.sock.Close()
+	c.dispatch(&Line{Cmd: DISCONNECTED})
+}
+
+func trimCR(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '\r' {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+func (c *DCCChat) dispatch(line *Line) {
+	if line.Time.IsZero() {
+		line.Time = time.Now()
+	}
+	c.handlers.dispatch(c.conn, line)
+}
+
+// write sends the lines of text to the other client.
+func (c *DCCChat) write(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sock == nil || c.closed {
+		return fmt.Errorf("irc.DCCChat: not connected to %s", c.nick)
+	}
+	_, err := c.sock.Write([]byte(text))
+	return err
+}
+
+// Privmsg sends msg to the other client, one line at a time. There's no
+// limit on the length of lines in a DCC CHAT, so they are not split.
+func (c *DCCChat) Privmsg(msg string) error {
+	text := ""
+	for _, l := range splitLines(msg) {
+		text += l + "\n"
+	}
+	return c.write(text)
+}
+
+// Action sends a CTCP ACTION to the other client.
+func (c *DCCChat) Action(msg string) error {
+	return c.write("\001" + ACTION + " " + msg + "\001\n")
+}
+
+// Connected returns true while the session's connection is open.
+func (c *DCCChat) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sock != nil && !c.closed
+}
+
+// Close closes the session, or stops waiting for the other client to
+// connect if it isn't open yet. DISCONNECTED is dispatched once it has
+// closed.
+func (c *DCCChat) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	sock, cancel := c.sock, c.cancel
+	c.mu.Unlock()
+	if sock != nil {
+		return sock.Close()
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectOffer reads a line sent to the server that should start with
+// prefix, returning the rest of it split into fields.
+func expectOffer(t *testing.T, s *testState, prefix string) []string {
+	select {
+	case l := <-s.nc.Out:
+		l = strings.Trim(l, "\r\n")
+		if !strings.HasPrefix(l, prefix) {
+			t.Fatalf("Expected line starting %q, got %q", prefix, l)
+		}
+		return strings.Fields(strings.Trim(l[len(prefix):], "\001"))
+	case <-time.After(time.Second):
+		t.Fatalf("Expected line starting %q, got nothing.", prefix)
+	}
+	return nil
+}
+
+// chatLines collects the lines dispatched to a DCC CHAT session.
+type chatLines chan *Line
+
+func collectChat(chat *DCCChat) chatLines {
+	lines := make(chatLines, 16)
+	chat.HandleFunc("*", func(_ *Conn, line *Line) { lines <- line })
+	return lines
+}
+
+func (lines chatLines) expect(t *testing.T, cmd string, args ...string) *Line {
+	select {
+	case l := <-lines:
+		if l.Cmd != cmd || (len(args) > 0 && !reflect.DeepEqual(l.Args, args)) {
+			t.Fatalf("Expected %s %q, got %s %q", cmd, args, l.Cmd, l.Args)
+		}
+		return l
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %s %q, got nothing.", cmd, args)
+	}
+	return nil
+}
+
+// expectRead reads lines sent by a DCC CHAT session from the other end.
+func expectRead(t *testing.T, r *bufio.Reader, lines ...string) {
+	for _, want := range lines {
+		got, err := r.ReadString('\n')
+		if err != nil || got != want+"\n" {
+			t.Fatalf("Expected %q, got %q, %v", want, got, err)
+		}
+	}
+}
+
+func TestDCCChatOffer(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCListenAddr = "127.0.0.1"
+
+	chat := c.NewDCCChat("peer")
+	lines := collectChat(chat)
+	if err := chat.Offer(); err != nil {
+		t.Fatal(err)
+	}
+	f := expectOffer(t, s, "PRIVMSG peer :\001DCC CHAT chat ")
+	if len(f) != 2 || f[0] != "2130706433" {
+		t.Fatalf("Unexpected offer: %q", f)
+	}
+	if err := chat.Offer(); err == nil {
+		t.Errorf("Session offered twice.")
+	}
+
+	peer, err := net.Dial("tcp", "127.0.0.1:"+f[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	lines.expect(t, CONNECTED)
+	if !chat.Connected() {
+		t.Errorf("Session not connected.")
+	}
+
+	fmt.Fprint(peer, "hello\r\n\001ACTION waves\001\n\n")
+	if l := lines.expect(t, PRIVMSG, "test", "hello"); l.Nick != "peer" {
+		t.Errorf("PRIVMSG from %q, not peer.", l.Nick)
+	}
+	lines.expect(t, ACTION, "test", "waves")
+	lines.expect(t, PRIVMSG, "test", "")
+
+	r := bufio.NewReader(peer)
+	chat.Privmsg("hi\r\nthere")
+	chat.Action("waves back")
+	expectRead(t, r, "hi", "there", "\001ACTION waves back\001")
+
+	chat.Close()
+	lines.expect(t, DISCONNECTED)
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Errorf("Connection still open after Close.")
+	}
+	if err := chat.Privmsg("gone"); err == nil {
+		t.Errorf("Sent to closed session.")
+	}
+}
+
+func TestDCCChatAccept(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	chats := make(chan chatLines, 1)
+	c.OnDCCChat(func(conn *Conn, o *DCCOffer) {
+		chat := conn.NewDCCChat(o.Sender.Nick)
+		chats <- collectChat(chat)
+		if err := chat.Accept(o); err != nil {
+			t.Error(err)
+		}
+	})
+	s.nc.Send(fmt.Sprintf(":peer!ident@host PRIVMSG test :\001DCC CHAT chat 2130706433 %d\001",
+		ln.Addr().(*net.TCPAddr).Port))
+
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := <-chats
+	lines.expect(t, CONNECTED)
+	fmt.Fprint(peer, "hello\n")
+	if l := lines.expect(t, PRIVMSG, "test", "hello"); l.Src != "peer!ident@host" {
+		t.Errorf("PRIVMSG from %q, not peer!ident@host.", l.Src)
+	}
+	peer.Close()
+	lines.expect(t, DISCONNECTED)
+
+	// Offers to channels are ignored.
+	s.nc.Send(":peer!ident@host PRIVMSG #chan :\001DCC CHAT chat 2130706433 1\001")
+	select {
+	case <-chats:
+		t.Errorf("Offer to channel dispatched.")
+	case <-time.After(5 * time.Millisecond):
+	}
+}
+
+func TestDCCChatAcceptPassive(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCListenAddr = "127.0.0.1"
+
+	chats := make(chan chatLines, 1)
+	c.OnDCCChat(func(conn *Conn, o *DCCOffer) {
+		chat := conn.NewDCCChat(o.Sender.Nick)
+		chats <- collectChat(chat)
+		if err := chat.Accept(o); err != nil {
+			t.Error(err)
+		}
+	})
+	s.nc.Send(":peer!ident@host PRIVMSG test :\001DCC CHAT chat 3232235777 0 77\001")
+	f := expectOffer(t, s, "PRIVMSG peer :\001DCC CHAT chat ")
+	if len(f) != 3 || f[0] != "2130706433" || f[2] != "77" {
+		t.Fatalf("Unexpected reply: %q", f)
+	}
+
+	peer, err := net.Dial("tcp", "127.0.0.1:"+f[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	lines := <-chats
+	lines.expect(t, CONNECTED)
+	fmt.Fprint(peer, "hello\n")
+	lines.expect(t, PRIVMSG, "test", "hello")
+}
+
+func TestDCCChatOfferPassive(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCPublicIP = "192.168.1.1"
+
+	offers := make(chan *DCCOffer, 1)
+	c.OnDCCChat(func(_ *Conn, o *DCCOffer) { offers <- o })
+
+	chat := c.NewDCCChat("peer")
+	lines := collectChat(chat)
+	if err := chat.OfferPassive(); err != nil {
+		t.Fatal(err)
+	}
+	f := expectOffer(t, s, "PRIVMSG peer :\001DCC CHAT chat ")
+	if len(f) != 3 || f[0] != "3232235777" || f[1] != "0" {
+		t.Fatalf("Unexpected offer: %q", f)
+	}
+	token := f[2]
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	reply := fmt.Sprintf("PRIVMSG test :\001DCC CHAT chat 2130706433 %d %s\001",
+		ln.Addr().(*net.TCPAddr).Port, token)
+
+	// Replies from anyone else are just offers.
+	s.nc.Send(":other!ident@host " + reply)
+	select {
+	case o := <-offers:
+		if o.Sender.Nick != "other" {
+			t.Errorf("Unexpected offer from %q.", o.Sender.Nick)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Reply from other not dispatched as an offer.")
+	}
+
+	s.nc.Send(":peer!ident@host " + reply)
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	lines.expect(t, CONNECTED)
+	fmt.Fprint(peer, "hello\n")
+	lines.expect(t, PRIVMSG, "test", "hello")
+	chat.Privmsg("hi")
+	expectRead(t, bufio.NewReader(peer), "hi")
+	select {
+	case o := <-offers:
+		t.Errorf("Reply from peer dispatched as an offer: %#v", o)
+	default:
+	}
+}
+
+func TestDCCChatNotConnected(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCListenAddr = "127.0.0.1"
+
+	// Closing a session stops us waiting for the connection.
+	chat := c.NewDCCChat("peer")
+	lines := collectChat(chat)
+	if err := chat.Offer(); err != nil {
+		t.Fatal(err)
+	}
+	f := expectOffer(t, s, "PRIVMSG peer :\001DCC CHAT chat ")
+	chat.Close()
+	lines.expect(t, DISCONNECTED)
+	if _, err := net.Dial("tcp", "127.0.0.1:"+f[1]); err == nil {
+		t.Errorf("Still listening after Close.")
+	}
+
+	// As does a passive offer getting no reply.
+	c.cfg.DCCTimeout = 5 * time.Millisecond
+	chat = c.NewDCCChat("peer")
+	lines = collectChat(chat)
+	if err := chat.OfferPassive(); err != nil {
+		t.Fatal(err)
+	}
+	expectOffer(t, s, "PRIVMSG peer :\001DCC CHAT chat ")
+	if l := lines.expect(t, DISCONNECTED); len(l.Args) != 1 {
+		t.Errorf("No reason for DISCONNECTED: %q", l.Args)
+	}
+
+	// Or an offer we accept not being connectable.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+	chat = c.NewDCCChat("peer")
+	lines = collectChat(chat)
+	o, _ := newDCCOffer(ParseLine(fmt.Sprintf(
+		":peer!ident@host PRIVMSG test :\001DCC CHAT chat 2130706433 %d\001", addr.Port)))
+	if err := chat.Accept(o); err != nil {
+		t.Fatal(err)
+	}
+	lines.expect(t, DISCONNECTED)
+}
@@ -0,0 +1,709 @@
+package client
+
+// This file contains DCC SEND file transfers, in both directions, including
+// resuming transfers that were interrupted part way through.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+const (
+	// DCCSEND is dispatched when someone offers to send us a file. Its
+	// Args are those of the CTCP DCC: "DCC", our nick, and the offer
+	// itself. Use OnDCCSend to receive these as parsed DCCOffers, and
+	// NewDCCReceive to accept them.
+	DCCSEND = "DCCSEND"
+
+	// DCCPROGRESS is dispatched to a DCCTransfer's handlers as the file is
+	// transferred. Args[0] is the name of the file, Args[1] the number of
+	// bytes of it transferred so far, and Args[2] its size, or 0 if that
+	// isn't known.
+	DCCPROGRESS = "DCCPROGRESS"
+
+	// Progress is dispatched at most once per dccProgressInterval, and
+	// when the transfer completes.
+	dccProgressInterval = time.Second
+	dccBufSize          = 32 * 1024
+)
+
+// DCCTransfer is a file being sent or received with DCC SEND. As with
+// DCCChat, it has its own handlers: CONNECTED is dispatched when the
+// connection is made, DCCPROGRESS as the file is transferred, and
+// DISCONNECTED when the transfer is over, with the reason it failed in
+// Args[0] if it did. For example, to send a file:
+//
+//     t, err := conn.NewDCCSend("nick", "/var/log/bot.log")
+//     if err != nil {
+//         // We couldn't open the file.
+//     }
+//     t.HandleFunc(DCCPROGRESS, func(conn *Conn, line *Line) {
+//         log.Printf("Sent %s of %s bytes.", line.Args[1], line.Args[2])
+//     })
+//     if err := t.Offer(); err != nil {
+//         // We couldn't listen for a connection.
+//     }
+//
+// Both ends of a transfer compute the SHA-256 checksum of the whole file,
+// which Sum returns once it is complete. The receiving end can be told the
+// checksum to expect with SetChecksum, and fails the transfer and removes
+// the file if it doesn't match.
+type DCCTransfer struct {
+	conn     *Conn
+	handlers *hSet
+	nick     string
+	// The name the file is offered with, its size, which is 0 if it
+	// isn't known, and where it is on disk.
+	name string
+	size int64
+	path string
+	// The offer we are receiving the file from, or nil if sending it.
+	offer *DCCOffer
+	// The key other requests use to refer to our offer, if we made one.
+	key string
+
+	mu          sync.Mutex
+	sock        net.Conn
+	started     bool
+	closed      bool
+	cancel      func()
+	pos         int64
+	transferred int64
+	reported    time.Time
+	want, sum   []byte
+	err         error
+	done        chan struct{}
+}
+
+func (conn *Conn) newDCCTransfer(nick, name, path string, size int64) *DCCTransfer {
+	return &DCCTransfer{
+		conn:     conn,
+		handlers: handlerSet(),
+		nick:     nick,
+		name:     name,
+		size:     size,
+		path:     path,
+		done:     make(chan struct{}),
+	}
+}
+
+// NewDCCSend creates a transfer sending the file at path to nick, which is
+// offered with the last element of path as its name. Add handlers to it
+// before calling Offer or OfferPassive to offer the file.
+func (conn *Conn) NewDCCSend(nick, path string) (*DCCTransfer, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("irc.DCCTransfer: %s is not a regular file", path)
+	}
+	return conn.newDCCTransfer(nick, filepath.Base(path), path, fi.Size()), nil
+}
+
+// NewDCCReceive creates a transfer receiving the file offered with o. It is
+// saved in Config.DCCDir as name, which may include directories within it,
+// or if name is empty, with the name it was offered with. Files can't be
+// received unless DCCDir is set, nor saved anywhere outside it, and files
+// larger than Config.DCCMaxSize are refused. Add handlers to the transfer
+// before calling Accept or Resume to accept the offer.
+func (conn *Conn) NewDCCReceive(o *DCCOffer, name string) (*DCCTransfer, error) {
+	if o.Type != "SEND" {
+		return nil, fmt.Errorf("irc.DCCTransfer: cannot receive DCC %s offer", o.Type)
+	}
+	if max := conn.cfg.DCCMaxSize; max > 0 && o.Size > max {
+		return nil, fmt.Errorf("irc.DCCTransfer: %s is %d bytes, more than %d",
+			o.Arg, o.Size, max)
+	}
+	if name == "" {
+		// Offers aren't supposed to include directories, but some do.
+		name = path.Base(strings.Replace(o.Arg, "\\", "/", -1))
+	}
+	p, err := conn.dccPath(name)
+	if err != nil {
+		return nil, err
+	}
+	t := conn.newDCCTransfer(o.Sender.Nick, o.Arg, p, o.Size)
+	t.offer = o
+	return t, nil
+}
+
+// dccPath returns where to save a file called name, which must be within
+// Config.DCCDir once any symbolic links are followed.
+func (conn *Conn) dccPath(name string) (string, error) {
+	if conn.cfg.DCCDir == "" {
+		return "", fmt.Errorf("irc.DCCTransfer: DCCDir not set")
+	}
+	dir, err := filepath.Abs(conn.cfg.DCCDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("irc.DCCTransfer: bad DCCDir: %s", err)
+	}
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if !within(dir, p) {
+		return "", fmt.Errorf("irc.DCCTransfer: %q is outside DCCDir", name)
+	}
+	// The file's directory may not exist yet, so follow links in as much
+	// of its path as does.
+	for d := filepath.Dir(p); d != dir; d = filepath.Dir(d) {
+		resolved, err := filepath.EvalSymlinks(d)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || !within(dir, resolved) {
+			return "", fmt.Errorf("irc.DCCTransfer: %q is outside DCCDir", name)
+		}
+		break
+	}
+	if fi, err := os.Lstat(p); err == nil && !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("irc.DCCTransfer: %q is not a regular file", name)
+	}
+	return p, nil
+}
+
+// within returns true if p is inside dir, and isn't dir itself.
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// OnDCCSend registers a foreground handler for offers to send us files.
+// Offers aren't accepted unless the handler creates a DCCTransfer for them
+// with NewDCCReceive and calls its Accept or Resume method.
+func (conn *Conn) OnDCCSend(f func(*Conn, *DCCOffer), filters ...Filter) Remover {
+	return conn.HandleFunc(DCCSEND, func(conn *Conn, line *Line) {
+		if o, ok := newDCCOffer(line); ok {
+			f(conn, o)
+		}
+	}, filters...)
+}
+
+// Nick returns the nick of the other client.
+func (t *DCCTransfer) Nick() string {
+	return t.nick
+}
+
+// Name returns the name the file was offered with.
+func (t *DCCTransfer) Name() string {
+	return t.name
+}
+
+// Path returns where the file is being sent from or saved to.
+func (t *DCCTransfer) Path() string {
+	return t.path
+}
+
+// Progress returns how much of the file has been transferred, including
+// any part of it transferred before the transfer was resumed, and its
+// size, which is 0 if it isn't known.
+func (t *DCCTransfer) Progress() (transferred, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.transferred, t.size
+}
+
+// Handle adds the provided handler to the transfer for the named event,
+// optionally restricted by filters, as Conn.Handle does for the server.
+// It will return a Remover that allows that handler to be removed again.
+func (t *DCCTransfer) Handle(name string, h Handler, filters ...Filter) Remover {
+	return t.handlers.add(name, h, filters...)
+}
+
+// HandleFunc adds the provided function as a handler for the named event.
+// It will return a Remover that allows that handler to be removed again.
+func (t *DCCTransfer) HandleFunc(name string, hf HandlerFunc, filters ...Filter) Remover {
+	return t.Handle(name, hf, filters...)
+}
+
+// SetChecksum sets the SHA-256 checksum, in hex, that a file being
+// received must have.
+func (t *DCCTransfer) SetChecksum(sum string) error {
+	b, err := hex.DecodeString(sum)
+	if err == nil && len(b) != sha256.Size {
+		err = fmt.Errorf("%d bytes long", len(b))
+	}
+	if err != nil {
+		return fmt.Errorf("irc.DCCTransfer: bad SHA-256 checksum %q: %s", sum, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.want = b
+	return nil
+}
+
+// Sum returns the SHA-256 checksum of the file, in hex, once it has been
+// transferred.
+func (t *DCCTransfer) Sum() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return hex.EncodeToString(t.sum)
+}
+
+// Wait waits for the transfer to finish, returning why it failed if it did.
+func (t *DCCTransfer) Wait() error {
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// start marks the transfer as started, so it can only be started once.
+func (t *DCCTransfer) start(send bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if (t.offer == nil) != send {
+		return fmt.Errorf("irc.DCCTransfer: cannot offer a file being received or accept one being sent")
+	}
+	if t.started || t.closed {
+		return fmt.Errorf("irc.DCCTransfer: transfer of %s already started or closed", t.name)
+	}
+	t.started = true
+	return nil
+}
+
+// waitFor sets the func that stops us waiting for the next step of the
+// transfer, calling it straight away if the transfer has been closed.
+func (t *DCCTransfer) waitFor(cancel func()) {
+	t.mu.Lock()
+	closed := t.closed
+	t.cancel = cancel
+	t.mu.Unlock()
+	if closed {
+		cancel()
+	}
+}
+
+// offerName returns the name of the file for an offer, quoted if needed.
+func (t *DCCTransfer) offerName() string {
+	if strings.Contains(t.name, " ") {
+		return `"` + t.name + `"`
+	}
+	return t.name
+}
+
+// Offer offers the file to the other client, listening for them to
+// connect to us.
+//     PRIVMSG nick :\001DCC SEND <file> <ip> <port> <size>\001
+func (t *DCCTransfer) Offer() error {
+	if err := t.start(true); err != nil {
+		return err
+	}
+	ln, err := t.listen()
+	if err != nil {
+		t.finish(err)
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	t.offered(strconv.Itoa(port) + " ")
+	t.conn.Ctcp(t.nick, DCC, "SEND", t.offerName(), dccIP(t.ip(ln)),
+		strconv.Itoa(port), strconv.FormatInt(t.size, 10))
+	return nil
+}
+
+// OfferPassive offers the file to the other client, asking them to listen
+// for us to connect to them, for when we can't accept connections.
+//     PRIVMSG nick :\001DCC SEND <file> <ip> 0 <size> <token>\001
+func (t *DCCTransfer) OfferPassive() error {
+	if err := t.start(true); err != nil {
+		return err
+	}
+	ip, err := t.conn.dccPublicIP(nil)
+	if err != nil {
+		// The address in a passive offer isn't used.
+		ip = net.IPv4zero
+	}
+	token := t.conn.dcc.newToken()
+	key := "SEND " + token
+	t.conn.dcc.expect(key, t.nick, t.conn.cfg.DCCTimeout, func(o *DCCOffer) {
+		t.conn.dccDial(o.Addr(), t.connected)
+	}, t.finish)
+	t.waitFor(func() { t.conn.dcc.cancel(key) })
+	t.offered("0 " + token)
+	t.conn.Ctcp(t.nick, DCC, "SEND", t.offerName(), dccIP(ip), "0",
+		strconv.FormatInt(t.size, 10), token)
+	return nil
+}
+
+// offered waits for the other client to ask to resume the transfer of the
+// file we've offered them, identified by key.
+//     PRIVMSG nick :\001DCC RESUME <file> <port> <position> [token]\001
+// We agree, as long as the file is at least that long.
+//     PRIVMSG nick :\001DCC ACCEPT <file> <port> <position> [token]\001
+func (t *DCCTransfer) offered(key string) {
+	t.key = key
+	t.conn.dcc.expect("RESUME "+key, t.nick, 0, func(o *DCCOffer) {
+		t.mu.Lock()
+		ok := t.sock == nil && o.Position <= t.size
+		if ok {
+			t.pos = o.Position
+		}
+		t.mu.Unlock()
+		if !ok {
+			logging.Warn("irc.DCCTransfer: %s asked to resume %s from %d",
+				t.nick, t.name, o.Position)
+			return
+		}
+		args := []string{"ACCEPT", t.offerName(), strconv.Itoa(o.Port), strconv.FormatInt(o.Position, 10)}
+		if o.Token != "" {
+			args = append(args, o.Token)
+		}
+		t.conn.Ctcp(t.nick, DCC, args...)
+	}, nil)
+}
+
+// Accept accepts the offer of the file, replacing any existing file with
+// the same name, by connecting to the other client, or if the offer is
+// passive, listening for them to connect to us and replying with where to
+// do so.
+//     PRIVMSG nick :\001DCC SEND <file> <ip> <port> <size> <token>\001
+func (t *DCCTransfer) Accept() error {
+	if err := t.start(false); err != nil {
+		return err
+	}
+	return t.accept()
+}
+
+// Resume accepts the offer of the file, asking the other client to send
+// only the part of it we don't already have, if a partial copy of it has
+// been saved already. It is like Accept otherwise.
+//     PRIVMSG nick :\001DCC RESUME <file> <port> <position> [token]\001
+func (t *DCCTransfer) Resume() error {
+	if err := t.start(false); err != nil {
+		return err
+	}
+	fi, err := os.Stat(t.path)
+	if os.IsNotExist(err) || (err == nil && fi.Size() == 0) {
+		return t.accept()
+	}
+	if err == nil && t.size > 0 && fi.Size() >= t.size {
+		err = fmt.Errorf("irc.DCCTransfer: %s is already %d bytes", t.path, fi.Size())
+	}
+	if err != nil {
+		t.finish(err)
+		return err
+	}
+	o := t.offer
+	key := "ACCEPT " + o.key()
+	t.conn.dcc.expect(key, t.nick, t.conn.cfg.DCCTimeout, func(a *DCCOffer) {
+		if a.Position > fi.Size() {
+			t.finish(fmt.Errorf("irc.DCCTransfer: cannot resume %s from %d", t.name, a.Position))
+			return
+		}
+		t.mu.Lock()
+		t.pos = a.Position
+		t.mu.Unlock()
+		if err := t.accept(); err != nil {
+			t.finish(err)
+		}
+	}, t.finish)
+	t.waitFor(func() { t.conn.dcc.cancel(key) })
+	args := []string{"RESUME", t.offerName(), strconv.Itoa(o.Port), strconv.FormatInt(fi.Size(), 10)}
+	if o.Token != "" {
+		args = append(args, o.Token)
+	}
+	t.conn.Ctcp(t.nick, DCC, args...)
+	return nil
+}
+
+// accept connects to the other client to receive the file, or listens for
+// them to connect if their offer is passive.
+func (t *DCCTransfer) accept() error {
+	o := t.offer
+	if !o.Passive() {
+		t.conn.dccDial(o.Addr(), t.connected)
+		return nil
+	}
+	ln, err := t.listen()
+	if err != nil {
+		t.finish(err)
+		return err
+	}
+	t.conn.Ctcp(t.nick, DCC, "SEND", t.offerName(), dccIP(t.ip(ln)),
+		strconv.Itoa(ln.Addr().(*net.TCPAddr).Port), strconv.FormatInt(o.Size, 10), o.Token)
+	return nil
+}
+
+// listen listens for the other client to connect to us.
+func (t *DCCTransfer) listen() (net.Listener, error) {
+	ln, err := t.conn.dccListen()
+	if err == nil {
+		_, err = t.conn.dccPublicIP(ln)
+		if err != nil {
+			ln.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.waitFor(func() { ln.Close() })
+	t.conn.dccAccept(ln, t.connected)
+	return ln, nil
+}
+
+func (t *DCCTransfer) ip(ln net.Listener) net.IP {
+	ip, _ := t.conn.dccPublicIP(ln)
+	return ip
+}
+
+// connected is called when the connection is made, or fails to be, and
+// transfers the file.
+func (t *DCCTransfer) connected(sock net.Conn, err error) {
+	t.mu.Lock()
+	t.cancel = nil
+	if err == nil && t.closed {
+		sock.Close()
+		err = fmt.Errorf("irc.DCCTransfer: transfer of %s closed", t.name)
+	}
+	if err == nil {
+		t.sock = sock
+		t.transferred = t.pos
+	}
+	t.mu.Unlock()
+	if err != nil {
+		t.finish(err)
+		return
+	}
+	t.dispatch(&Line{Cmd: CONNECTED})
+	if t.offer == nil {
+		err = t.send(sock)
+	} else {
+		err = t.receive(sock)
+	}
+	t.finish(err)
+}
+
+// hashFrom returns a hash of the first pos bytes of f, leaving f at pos.
+func hashFrom(f *os.File, pos int64) (hash.Hash, error) {
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, pos); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// deadline sets a deadline for the next read from sock, so that stalled
+// transfers fail after Config.DCCTimeout.
+func (t *DCCTransfer) deadline(sock net.Conn) {
+	if d := t.conn.cfg.DCCTimeout; d > 0 {
+		sock.SetReadDeadline(time.Now().Add(d))
+	}
+}
+
+// send sends the file, from the position the other client asked to resume
+// from, and waits for them to acknowledge receiving all of it. They send
+// the number of bytes they have received so far as a 4 byte integer,
+// which wraps around for files over 4GB.
+func (t *DCCTransfer) send(sock net.Conn) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := hashFrom(f, t.pos)
+	if err != nil {
+		return err
+	}
+	acked := make(chan error, 1)
+	go func() {
+		var ack [4]byte
+		for {
+			t.deadline(sock)
+			if _, err := io.ReadFull(sock, ack[:]); err != nil {
+				acked <- err
+				return
+			}
+			if binary.BigEndian.Uint32(ack[:]) == uint32(t.size) {
+				acked <- nil
+				return
+			}
+		}
+	}()
+	buf := make([]byte, dccBufSize)
+	r := io.LimitReader(f, t.size-t.pos)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := sock.Write(buf[:n]); err != nil {
+				return err
+			}
+			h.Write(buf[:n])
+			t.progress(int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if t.pos < t.size {
+		// Some clients close the connection rather than sending the
+		// last acknowledgement.
+		if err := <-acked; err != nil && err != io.EOF {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.transferred != t.size {
+		return fmt.Errorf("irc.DCCTransfer: %s changed size while being sent", t.path)
+	}
+	t.sum = h.Sum(nil)
+	return nil
+}
+
+// receive saves the file, appending to the part we already have if the
+// transfer was resumed, acknowledging what we've received as we go.
+func (t *DCCTransfer) receive(sock net.Conn) error {
+	flags := os.O_RDWR | os.O_CREATE
+	if t.pos == 0 {
+		flags |= os.O_TRUNC
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := hashFrom(f, t.pos)
+	if err == nil {
+		// Anything after where we resumed from is replaced.
+		err = f.Truncate(t.pos)
+	}
+	if err != nil {
+		return err
+	}
+	got, max := t.pos, t.conn.cfg.DCCMaxSize
+	buf := make([]byte, dccBufSize)
+	var ack [4]byte
+	for t.size == 0 || got < t.size {
+		t.deadline(sock)
+		n, err := sock.Read(buf)
+		if n > 0 {
+			got += int64(n)
+			if (t.size > 0 && got > t.size) || (max > 0 && got > max) {
+				return fmt.Errorf("irc.DCCTransfer: %s is larger than expected", t.name)
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			h.Write(buf[:n])
+			t.progress(int64(n))
+			binary.BigEndian.PutUint32(ack[:], uint32(got))
+			if _, err := sock.Write(ack[:]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF && (t.size == 0 || got == t.size) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("irc.DCCTransfer: %s incomplete at %d bytes: %s", t.name, got, err)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sum = h.Sum(nil)
+	if t.want != nil && !bytes.Equal(t.sum, t.want) {
+		f.Close()
+		os.Remove(t.path)
+		return fmt.Errorf("irc.DCCTransfer: %s has SHA-256 checksum %x, not %x",
+			t.name, t.sum, t.want)
+	}
+	return nil
+}
+
+// progress records that n more bytes have been transferred, dispatching
+// DCCPROGRESS if it has been long enough since we last did.
+func (t *DCCTransfer) progress(n int64) {
+	t.mu.Lock()
+	t.transferred += n
+	now := time.Now()
+	if t.transferred != t.size && now.Sub(t.reported) < dccProgressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.reported = now
+	args := []string{t.name, strconv.FormatInt(t.transferred, 10), strconv.FormatInt(t.size, 10)}
+	t.mu.Unlock()
+	t.dispatch(&Line{Cmd: DCCPROGRESS, Args: args})
+}
+
+func (t *DCCTransfer) dispatch(line *Line) {
+	if line.Time.IsZero() {
+		line.Time = time.Now()
+	}
+	t.handlers.dispatch(t.conn, line)
+}
+
+// finish ends the transfer, with err if it failed, dispatching DISCONNECTED.
+func (t *DCCTransfer) finish(err error) {
+	t.mu.Lock()
+	select {
+	case <-t.done:
+		// Already finished.
+		t.mu.Unlock()
+		return
+	default:
+	}
+	t.closed, t.err = true, err
+	sock := t.sock
+	close(t.done)
+	t.mu.Unlock()
+	if sock != nil {
+		sock.Close()
+	}
+	if t.key != "" {
+		t.conn.dcc.take("RESUME "+t.key, nil)
+	}
+	line := &Line{Cmd: DISCONNECTED}
+	if err != nil {
+		line.Args = []string{err.Error()}
+	}
+	t.dispatch(line)
+}
+
+// Close stops the transfer, or stops waiting for it to start.
+// DISCONNECTED is dispatched once it has stopped.
+func (t *DCCTransfer) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	sock, cancel, started := t.sock, t.cancel, t.started
+	t.mu.Unlock()
+	switch {
+	case sock != nil:
+		return sock.Close()
+	case cancel != nil:
+		cancel()
+	case started:
+		// We're connecting to the other client, and will stop when the
+		// connection is made.
+	default:
+		t.finish(fmt.Errorf("irc.DCCTransfer: transfer of %s closed", t.name))
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// relay passes the next line one test connection sends to the other, as if
+// the server relayed it from src.
+func relay(t *testing.T, from, to *testState, src string) string {
+	select {
+	case l := <-from.nc.Out:
+		l = strings.Trim(l, "\r\n")
+		to.nc.Send(":" + src + " " + l)
+		return l
+	case <-time.After(time.Second):
+		t.Fatalf("Nothing to relay from %s.", src)
+	}
+	return ""
+}
+
+// tempDir makes a temporary directory, which the caller must remove.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "goirc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// setUpDCCSend sets up alice, who sends file.bin from dir to bob, who
+// accepts it into dir/recv with accept. It returns the transfers at each end.
+func setUpDCCSend(t *testing.T, dir string, data []byte, accept func(*DCCTransfer) error) (
+	a, b *testState, send *DCCTransfer, recv chan *DCCTransfer) {
+	ac, a := setUp(t)
+	bc, b := setUp(t)
+	ac.cfg.DCCListenAddr, bc.cfg.DCCListenAddr = "127.0.0.1", "127.0.0.1"
+	ac.cfg.DCCPublicIP, bc.cfg.DCCPublicIP = "127.0.0.1", "127.0.0.1"
+	bc.cfg.DCCDir = filepath.Join(dir, "recv")
+	if err := os.Mkdir(bc.cfg.DCCDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "file name.bin")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	send, err := ac.NewDCCSend("bob", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv = make(chan *DCCTransfer, 1)
+	bc.OnDCCSend(func(conn *Conn, o *DCCOffer) {
+		r, err := conn.NewDCCReceive(o, "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		recv <- r
+		if err := accept(r); err != nil {
+			t.Error(err)
+		}
+	})
+	return a, b, send, recv
+}
+
+func testData(n int) ([]byte, string) {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+// checkTransfer checks both ends of a transfer completed successfully.
+func checkTransfer(t *testing.T, send, recv *DCCTransfer, data []byte, sum string) {
+	if err := send.Wait(); err != nil {
+		t.Errorf("Sending failed: %s", err)
+	}
+	if err := recv.Wait(); err != nil {
+		t.Errorf("Receiving failed: %s", err)
+	}
+	got, err := ioutil.ReadFile(recv.Path())
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Received %d bytes, not %d: %v", len(got), len(data), err)
+	}
+	if send.Sum() != sum || recv.Sum() != sum {
+		t.Errorf("Checksums %s and %s, not %s", send.Sum(), recv.Sum(), sum)
+	}
+	for _, tr := range []*DCCTransfer{send, recv} {
+		if n, size := tr.Progress(); n != int64(len(data)) || size != int64(len(data)) {
+			t.Errorf("Progress() = %d, %d", n, size)
+		}
+	}
+}
+
+func TestDCCSend(t *testing.T) {
+	data, sum := testData(100000)
+	for _, passive := range []bool{false, true} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		a, b, send, recv := setUpDCCSend(t, dir, data, func(r *DCCTransfer) error {
+			if err := r.SetChecksum(sum); err != nil {
+				return err
+			}
+			return r.Accept()
+		})
+		progress := make(chan []string, 10)
+		send.HandleFunc(DCCPROGRESS, func(_ *Conn, line *Line) {
+			progress <- line.Args
+		})
+
+		offer := send.Offer
+		if passive {
+			offer = send.OfferPassive
+		}
+		if err := offer(); err != nil {
+			t.Fatal(err)
+		}
+		l := relay(t, a, b, "alice!a@host")
+		if want := "PRIVMSG bob :\001DCC SEND \"file name.bin\" 2130706433 "; !strings.HasPrefix(l, want) ||
+			!strings.Contains(l, " 100000") {
+			t.Errorf("Unexpected offer: %q", l)
+		}
+		r := <-recv
+		if r.Name() != "file name.bin" || r.Nick() != "alice" {
+			t.Errorf("Receiving %q from %q.", r.Name(), r.Nick())
+		}
+		if passive {
+			l = relay(t, b, a, "bob!b@host")
+			if !strings.HasSuffix(l, " 100000 1\001") {
+				t.Errorf("Unexpected reply: %q", l)
+			}
+		}
+		checkTransfer(t, send, r, data, sum)
+		var last []string
+		for len(progress) > 0 {
+			last = <-progress
+		}
+		if strings.Join(last, " ") != "file name.bin 100000 100000" {
+			t.Errorf("Last progress was %q", last)
+		}
+		a.tearDown()
+		b.tearDown()
+	}
+}
+
+func TestDCCResume(t *testing.T) {
+	data, sum := testData(100000)
+	for _, passive := range []bool{false, true} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		a, b, send, recv := setUpDCCSend(t, dir, data, func(r *DCCTransfer) error {
+			// We already have some of it.
+			if err := ioutil.WriteFile(r.Path(), data[:40000], 0644); err != nil {
+				return err
+			}
+			return r.Resume()
+		})
+		offer := send.Offer
+		if passive {
+			offer = send.OfferPassive
+		}
+		if err := offer(); err != nil {
+			t.Fatal(err)
+		}
+		relay(t, a, b, "alice!a@host")
+		r := <-recv
+		l := relay(t, b, a, "bob!b@host")
+		want := "PRIVMSG alice :\001DCC RESUME \"file name.bin\" "
+		if !strings.HasPrefix(l, want) || !strings.Contains(l, " 40000") {
+			t.Errorf("Unexpected resume: %q", l)
+		}
+		l = relay(t, a, b, "alice!a@host")
+		want = "PRIVMSG bob :\001DCC ACCEPT \"file name.bin\" "
+		if !strings.HasPrefix(l, want) || !strings.Contains(l, " 40000") {
+			t.Errorf("Unexpected accept: %q", l)
+		}
+		if passive {
+			relay(t, b, a, "bob!b@host")
+		}
+		checkTransfer(t, send, r, data, sum)
+		a.tearDown()
+		b.tearDown()
+	}
+}
+
+func TestDCCReceiveChecksum(t *testing.T) {
+	data, sum := testData(1000)
+	wrong := strings.Repeat("0", len(sum))
+	disconnected := make(chan []string, 1)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a, b, send, recv := setUpDCCSend(t, dir, data, func(r *DCCTransfer) error {
+		r.HandleFunc(DISCONNECTED, func(_ *Conn, line *Line) {
+			disconnected <- line.Args
+		})
+		if err := r.SetChecksum("not hex"); err == nil {
+			t.Errorf("Bad checksum set.")
+		}
+		if err := r.SetChecksum(sum[:10]); err == nil {
+			t.Errorf("Short checksum set.")
+		}
+		if err := r.SetChecksum(wrong); err != nil {
+			return err
+		}
+		return r.Accept()
+	})
+	defer a.tearDown()
+	defer b.tearDown()
+	if err := send.Offer(); err != nil {
+		t.Fatal(err)
+	}
+	relay(t, a, b, "alice!a@host")
+	r := <-recv
+	if err := r.Wait(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(r.Path()); !os.IsNotExist(err) {
+		t.Errorf("File with wrong checksum kept: %v", err)
+	}
+	select {
+	case args := <-disconnected:
+		if len(args) != 1 || !strings.Contains(args[0], "checksum") {
+			t.Errorf("Unexpected DISCONNECTED: %q", args)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("No DISCONNECTED.")
+	}
+	send.Wait()
+}
+
+func TestDCCReceiveLimits(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCDir = tempDir(t)
+	defer os.RemoveAll(c.cfg.DCCDir)
+	c.cfg.DCCMaxSize = 1000
+
+	offer := func(port, size int) *DCCOffer {
+		o, _ := newDCCOffer(ParseLine(fmt.Sprintf(
+			":nick!i@h PRIVMSG test :\001DCC SEND file 2130706433 %d %d\001", port, size)))
+		return o
+	}
+	if _, err := c.NewDCCReceive(offer(1, 1001), ""); err == nil {
+		t.Errorf("Received file larger than DCCMaxSize.")
+	}
+
+	// Files that turn out to be larger than offered, or than DCCMaxSize
+	// if their size isn't known, are rejected part way through.
+	for _, size := range []int{10, 0} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := c.NewDCCReceive(offer(ln.Addr().(*net.TCPAddr).Port, size), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Accept(); err != nil {
+			t.Fatal(err)
+		}
+		peer, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go peer.Write(make([]byte, 2000))
+		if err := r.Wait(); err == nil || !strings.Contains(err.Error(), "larger") {
+			t.Errorf("Unexpected error for size %d: %v", size, err)
+		}
+		peer.Close()
+		ln.Close()
+	}
+
+	// Files that turn out to be smaller are incomplete.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	r, _ := c.NewDCCReceive(offer(ln.Addr().(*net.TCPAddr).Port, 100), "")
+	if err := r.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Accept(); err == nil {
+		t.Errorf("Accepted twice.")
+	}
+	if err := r.Offer(); err == nil {
+		t.Errorf("Offered file being received.")
+	}
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Write(make([]byte, 50))
+	peer.Close()
+	if err := r.Wait(); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n, size := r.Progress(); n != 50 || size != 100 {
+		t.Errorf("Progress() = %d, %d", n, size)
+	}
+}
+
+func TestDCCPath(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	if _, err := c.dccPath("file"); err == nil {
+		t.Errorf("Saved file without DCCDir.")
+	}
+
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "dcc")
+	outside := filepath.Join(root, "outside")
+	for _, d := range []string{dir, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Symlink(outside, filepath.Join(dir, "link"))
+	os.Symlink(filepath.Join(outside, "file"), filepath.Join(dir, "filelink"))
+	os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "sublink"))
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	c.cfg.DCCDir = dir
+
+	tests := []struct {
+		name, path string
+	}{
+		{"file", "file"},
+		{"sub/file", "sub/file"},
+		{"new/dir/file", "new/dir/file"},
+		{"/etc/passwd", "etc/passwd"},
+		{"sublink/file", "sublink/file"},
+		{"../file", ""},
+		{"sub/../../file", ""},
+		{".", ""},
+		{"", ""},
+		{"link/file", ""},
+		{"link/new/file", ""},
+		{"filelink", ""},
+		{"sub", ""},
+	}
+	for _, test := range tests {
+		p, err := c.dccPath(test.name)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("dccPath(%q) = %q, expected error", test.name, p)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, test.path); err != nil || p != want {
+			t.Errorf("dccPath(%q) = %q, %v, expected %q", test.name, p, err, want)
+		}
+	}
+
+	// Directories in offered names are ignored.
+	for _, name := range []string{"../../evil", `..\..\evil`, "/tmp/evil"} {
+		o, _ := newDCCOffer(ParseLine(":nick!i@h PRIVMSG test :\001DCC SEND " +
+			name + " 2130706433 1 10\001"))
+		if r, err := c.NewDCCReceive(o, ""); err != nil || r.Path() != filepath.Join(dir, "evil") {
+			t.Errorf("NewDCCReceive(%q) saves to %v, %v", name, r, err)
+		}
+	}
+}
+
+func TestDCCSendClose(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.DCCListenAddr, c.cfg.DCCPublicIP = "127.0.0.1", "127.0.0.1"
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "file")
+	ioutil.WriteFile(src, []byte("data"), 0644)
+	if _, err := c.NewDCCSend("nick", filepath.Dir(src)); err == nil {
+		t.Errorf("Sent a directory.")
+	}
+
+	for _, passive := range []bool{false, true} {
+		send, err := c.NewDCCSend("nick", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offer := send.Offer
+		if passive {
+			offer = send.OfferPassive
+		}
+		if err := offer(); err != nil {
+			t.Fatal(err)
+		}
+		f := expectOffer(t, s, "PRIVMSG nick :\001DCC SEND file 2130706433 ")
+		send.Close()
+		if err := send.Wait(); err == nil {
+			t.Errorf("Closed transfer had no error.")
+		}
+		if passive {
+			// The reply to a closed passive offer is just an offer.
+			if c.dccReply("SEND "+f[2], &DCCOffer{Event: Event{Sender: Source{Nick: "nick"}}}) {
+				t.Errorf("Reply to closed offer accepted.")
+			}
+		} else if _, err := net.Dial("tcp", "127.0.0.1:"+f[0]); err == nil {
+			t.Errorf("Still listening after Close.")
+		}
+		// Asking to resume it does nothing.
+		if c.dccReply("RESUME "+f[0]+" "+strings.Join(f[2:], ""), &DCCOffer{}) {
+			t.Errorf("Resumed closed transfer.")
+		}
+	}
+
+	// Transfers can be closed before they start, too.
+	send, _ := c.NewDCCSend("nick", src)
+	send.Close()
+	if err := send.Wait(); err == nil {
+		t.Errorf("Closed transfer had no error.")
+	}
+	if err := send.Offer(); err == nil {
+		t.Errorf("Closed transfer offered.")
+	}
+	if send.Sum() != "" {
+		t.Errorf("Closed transfer has checksum %q.", send.Sum())
+	}
+}
@@ -14,13 +14,26 @@ import (
 // strings of digits like "332" (mainly because I really didn't feel like
 // putting massive constant tables in).
 //
+// Handlers may also be registered for patterns rather than single events:
+// the name "*" matches every event, and numerics may be matched with an "x"
+// standing in for any digit, so "4xx" matches all the 400-series errors.
+// Filters can be supplied when registering a handler to restrict the lines
+// it will be called for; see filters.go.
+//
 // Foreground handlers have a guarantee of protocol consistency: all the
 // handlers for one event will have finished before the handlers for the
 // next start processing. They are run in parallel but block the event
 // loop, so care should be taken to ensure these handlers are quick :-)
 //
 // Background handlers are run in parallel and do not block the event loop.
-// This is useful for things that may need to do significant work.
+// This is useful for things that may need to do significant work. Lines
+// destined for background handlers wait in a bounded queue; see
+// Config.BGQueueLen and Config.BGQueuePolicy for what happens when it fills.
+// Background handlers may process lines out of order unless Config.BGOrderKey
+// is set, in which case lines that share a key are handled in sequence.
+//
+// All handlers are run by a bounded pool of worker goroutines, the size of
+// which is set by Config.DispatchWorkers.
 type Handler interface {
 	Handle(*Conn, *Line)
 }
@@ -39,6 +52,37 @@ func (hf HandlerFunc) Handle(conn *Conn, line *Line) {
 	hf(conn, line)
 }
 
+// OutHandlers are triggered on outgoing Lines before they are sent to the
+// server, and are registered in the same way as Handlers, with the "name"
+// being the outgoing Line.Cmd (which, as with incoming lines, will be ACTION,
+// CTCP or CTCPREPLY for CTCP messages rather than PRIVMSG or NOTICE).
+//
+// OutHandlers are run one at a time in the order they were added, in the
+// goroutine that is sending the line. Each receives the same *Line and may
+// modify it -- e.g. to rewrite the text or attach tags -- before it is passed
+// to the next. Returning false vetoes the line: it is not sent, and no further
+// OutHandlers are run. A panicking OutHandler is recovered as with Handlers,
+// and the line continues on its way.
+type OutHandler interface {
+	HandleOut(*Conn, *Line) bool
+}
+
+// OutHandlerFunc allows a bare function to implement OutHandler.
+type OutHandlerFunc func(*Conn, *Line) bool
+
+func (ohf OutHandlerFunc) HandleOut(conn *Conn, line *Line) bool {
+	return ohf(conn, line)
+}
+
+// An outHandler adapts an OutHandler for storage in a hSet.
+type outHandler struct {
+	OutHandler
+}
+
+func (oh outHandler) Handle(conn *Conn, line *Line) {
+	oh.HandleOut(conn, line)
+}
+
 // Handlers are organised using a map of linked-lists, with each map
 // key representing an IRC verb or numeric, and the linked list values
 // being handlers that are executed in parallel when a Line from the
@@ -59,6 +103,7 @@ type hNode struct {
 	set        *hSet
 	event      string
 	handler    Handler
+	filters    []Filter
 }
 
 // A hNode implements both Handler (with configurable panic recovery)...
@@ -67,6 +112,19 @@ func (hn *hNode) Handle(conn *Conn, line *Line) {
 	hn.handler.Handle(conn, line)
 }
 
+// ... and checks the line against its filters before it is scheduled.
+// A panicking filter is recovered in the same way as a handler, and the
+// line is not accepted.
+func (hn *hNode) accepts(conn *Conn, line *Line) (ok bool) {
+	defer conn.cfg.Recover(conn, line)
+	for _, f := range hn.filters {
+		if !f(line) {
+			return false
+		}
+	}
+	return true
+}
+
 // ... and Remover.
 func (hn *hNode) Remove() {
 	hn.set.remove(hn)
@@ -78,7 +136,7 @@ func handlerSet() *hSet {
 
 // When a new Handler is added for an event, it is wrapped in a hNode and
 // returned as a Remover so the caller can remove it at a later time.
-func (hs *hSet) add(ev string, h Handler) Remover {
+func (hs *hSet) add(ev string, h Handler, filters ...Filter) Remover {
 	hs.Lock()
 	defer hs.Unlock()
 	ev = strings.ToLower(ev)
@@ -90,6 +148,7 @@ func (hs *hSet) add(ev string, h Handler) Remover {
 		set:     hs,
 		event:   ev,
 		handler: h,
+		filters: filters,
 	}
 	if !ok {
 		l.start = hn
@@ -131,33 +190,124 @@ func (hs *hSet) remove(hn *hNode) {
 func (hs *hSet) dispatch(conn *Conn, line *Line) {
 	hs.RLock()
 	defer hs.RUnlock()
-	ev := strings.ToLower(line.Cmd)
-	list, ok := hs.set[ev]
-	if !ok {
-		return
-	}
 	wg := &sync.WaitGroup{}
-	for hn := list.start; hn != nil; hn = hn.next {
-		wg.Add(1)
-		go func(hn *hNode) {
-			hn.Handle(conn, line.Copy())
-			wg.Done()
-		}(hn)
+	for _, ev := range eventKeys(line.Cmd) {
+		list, ok := hs.set[ev]
+		if !ok {
+			continue
+		}
+		for hn := list.start; hn != nil; hn = hn.next {
+			if !hn.accepts(conn, line) {
+				continue
+			}
+			wg.Add(1)
+			hn := hn
+			conn.pool.run(func() {
+				hn.Handle(conn, line.Copy())
+				wg.Done()
+			})
+		}
 	}
 	wg.Wait()
 }
 
+// dispatchOut runs OutHandlers for an outgoing line sequentially. It returns
+// false if any of them vetoed the line.
+func (hs *hSet) dispatchOut(conn *Conn, line *Line) bool {
+	hs.RLock()
+	defer hs.RUnlock()
+	for _, ev := range eventKeys(line.Cmd) {
+		list, ok := hs.set[ev]
+		if !ok {
+			continue
+		}
+		for hn := list.start; hn != nil; hn = hn.next {
+			if !hn.accepts(conn, line) {
+				continue
+			}
+			if oh, ok := hn.handler.(OutHandler); ok && !hn.handleOut(oh, conn, line) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// handleOut calls oh with panic recovery, passing the line on if it panics.
+func (hn *hNode) handleOut(oh OutHandler, conn *Conn, line *Line) (send bool) {
+	send = true
+	defer conn.cfg.Recover(conn, line)
+	return oh.HandleOut(conn, line)
+}
+
+// empty returns true if there are no handlers in the set.
+func (hs *hSet) empty() bool {
+	hs.RLock()
+	defer hs.RUnlock()
+	return len(hs.set) == 0
+}
+
+// eventKeys returns the keys in a hSet whose handlers should be called
+// for cmd: the lowercased command itself, every "x" pattern that matches
+// it if it is a numeric, and finally the "*" wildcard.
+func eventKeys(cmd string) []string {
+	ev := strings.ToLower(cmd)
+	keys := []string{ev}
+	if isNumeric(ev) {
+		// Replace each combination of digits with "x", skipping 0
+		// because that's the exact match we already have.
+		for mask := 1; mask < 1<<uint(len(ev)); mask++ {
+			k := []byte(ev)
+			for i := range k {
+				if mask&(1<<uint(i)) != 0 {
+					k[i] = 'x'
+				}
+			}
+			keys = append(keys, string(k))
+		}
+	}
+	return append(keys, "*")
+}
+
+// isNumeric returns true if s is a three-digit IRC numeric.
+func isNumeric(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // Handle adds the provided handler to the foreground set for the named event.
+// If any filters are provided, the handler is only called for lines that
+// pass all of them.
 // It will return a Remover that allows that handler to be removed again.
-func (conn *Conn) Handle(name string, h Handler) Remover {
-	return conn.fgHandlers.add(name, h)
+func (conn *Conn) Handle(name string, h Handler, filters ...Filter) Remover {
+	return conn.fgHandlers.add(name, h, filters...)
 }
 
 // HandleBG adds the provided handler to the background set for the named
 // event. It may go away in the future.
 // It will return a Remover that allows that handler to be removed again.
-func (conn *Conn) HandleBG(name string, h Handler) Remover {
-	return conn.bgHandlers.add(name, h)
+func (conn *Conn) HandleBG(name string, h Handler, filters ...Filter) Remover {
+	return conn.bgHandlers.add(name, h, filters...)
+}
+
+// HandleOut adds the provided OutHandler to the set for the named outgoing
+// event, optionally restricted by filters. For example, to prevent the
+// client from ever talking in #announce:
+//
+//     conn.HandleOut(PRIVMSG, OutHandlerFunc(func(*Conn, *Line) bool {
+//         return false
+//     }), InChannel("#announce"))
+//
+// It will return a Remover that allows that handler to be removed again.
+func (conn *Conn) HandleOut(name string, h OutHandler, filters ...Filter) Remover {
+	return conn.outHandlers.add(name, outHandler{h}, filters...)
 }
 
 func (conn *Conn) handle(name string, h Handler) Remover {
@@ -165,18 +315,32 @@ func (conn *Conn) handle(name string, h Handler) Remover {
 }
 
 // HandleFunc adds the provided function as a handler in the foreground set
-// for the named event.
+// for the named event, optionally restricted by filters.
 // It will return a Remover that allows that handler to be removed again.
-func (conn *Conn) HandleFunc(name string, hf HandlerFunc) Remover {
-	return conn.Handle(name, hf)
+func (conn *Conn) HandleFunc(name string, hf HandlerFunc, filters ...Filter) Remover {
+	return conn.Handle(name, hf, filters...)
 }
 
 func (conn *Conn) dispatch(line *Line) {
+	// Lines in batches that are handled as a whole, like chathistory,
+	// are collected rather than dispatched.
+	if conn.batches.collect(line) {
+		return
+	}
 	// We run the internal handlers first, including all state tracking ones.
 	// This ensures that user-supplied handlers that use the tracker have a
 	// consistent view of the connection state in handlers that mutate it.
 	conn.intHandlers.dispatch(conn, line)
-	go conn.bgHandlers.dispatch(conn, line)
+	bg := func() { conn.bgHandlers.dispatch(conn, line) }
+	key := ""
+	if kf := conn.cfg.BGOrderKey; kf != nil {
+		key = kf(line)
+	}
+	if key != "" {
+		conn.pool.enqueueKeyed(key, bg)
+	} else {
+		conn.pool.enqueue(bg)
+	}
 	conn.fgHandlers.dispatch(conn, line)
 }
 
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"reflect"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -199,3 +200,54 @@ func TestPanicRecovery(t *testing.T) {
 	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :OH NO PIGEONS")
 	recovered.assertWasCalled("Failed to recover panic!")
 }
+
+func TestEventKeys(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{"PRIVMSG", []string{"privmsg", "*"}},
+		{"disconnected", []string{"disconnected", "*"}},
+		{"404", []string{"404", "x04", "4x4", "xx4", "40x", "x0x", "4xx", "xxx", "*"}},
+		{"40", []string{"40", "*"}},
+	}
+	for i, test := range tests {
+		if out := eventKeys(test.in); !reflect.DeepEqual(out, test.out) {
+			t.Errorf("test %d: expected %v, got %v", i, test.out, out)
+		}
+	}
+}
+
+func TestPatternsAndFilters(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	counts := map[string]*int32{}
+	count := func(name string) HandlerFunc {
+		counts[name] = new(int32)
+		return func(_ *Conn, _ *Line) { atomic.AddInt32(counts[name], 1) }
+	}
+	c.HandleFunc("*", count("all"))
+	c.HandleFunc("4xx", count("4xx"))
+	c.HandleFunc("40X", count("40x"))
+	c.HandleFunc(PRIVMSG, count("ops"), InChannel("#ops"))
+	c.HandleFunc(PRIVMSG, count("never"), InChannel("#ops"), FromNick("nobody"))
+	c.HandleFunc(PRIVMSG, count("panic"), func(*Line) bool { panic("filter!") })
+
+	for _, l := range []string{
+		":irc.server.org 401 test nobody :No such nick",
+		":irc.server.org 433 test test :Nickname is already in use.",
+		":irc.server.org 501 test :Unknown MODE flag",
+		":nick!user@host.com PRIVMSG #ops :hello",
+		":nick!user@host.com PRIVMSG #dev :hello",
+	} {
+		c.fgHandlers.dispatch(c, ParseLine(l))
+	}
+	for name, want := range map[string]int32{
+		"all": 5, "4xx": 2, "40x": 1, "ops": 1, "never": 0, "panic": 0,
+	} {
+		if got := atomic.LoadInt32(counts[name]); got != want {
+			t.Errorf("Handler %q called %d times, expected %d.", name, got, want)
+		}
+	}
+}
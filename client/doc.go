@@ -14,14 +14,12 @@
 // soemthing like this, for the simple case:
 //
 //     // Create a new client, which will connect with the nick "myNick"
-//     irc := client.SimpleClient("myNick")
+//     c := client.SimpleClient("myNick")
 //
-//     // Add a handler that waits for the "disconnected" event and
-//     // closes a channel to signal everything is done.
-//     disconnected := make(chan struct{})
-//     c.HandleFunc("disconnected", func(c *client.Conn, l *client.Line) {
-//         close(disconnected)
-//     })
+//     // Subscribe to the "disconnected" event. The subscription's channel
+//     // is closed once the client has disconnected.
+//     disconnected := c.Subscribe(context.Background(),
+//         client.Commands(client.DISCONNECTED))
 //
 //     // Connect to an IRC server.
 //     if err := c.ConnectTo("irc.freenode.net"); err != nil {
@@ -29,6 +27,7 @@
 //     }
 //
 //     // Wait for disconnection.
-//     <-disconnected
+//     for range disconnected {
+//     }
 //
 package client
@@ -0,0 +1,456 @@
+package client
+
+// This file contains typed wrappers around the most commonly handled
+// lines, so handlers needn't index into Line.Args themselves.
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// Source identifies the sender of a line. For lines from the server itself,
+// only Host is set.
+type Source struct {
+	Nick, Ident, Host string
+}
+
+// String returns the source as nick!ident@host, or just the host for
+// server sources.
+func (s Source) String() string {
+	if s.Nick == "" {
+		return s.Host
+	}
+	return s.Nick + "!" + s.Ident + "@" + s.Host
+}
+
+// Event contains the fields common to all typed events. It is embedded
+// in each of them.
+type Event struct {
+	Sender Source
+	Tags   map[string]string
+	Time   time.Time
+	// The line the event was created from, for anything not covered
+	// by the typed fields.
+	Line *Line
+}
+
+func newEvent(line *Line) Event {
+	return Event{
+		Sender: Source{Nick: line.Nick, Ident: line.Ident, Host: line.Host},
+		Tags:   line.Tags,
+		Time:   line.Time,
+		Line:   line,
+	}
+}
+
+// PrivmsgEvent is a PRIVMSG or NOTICE sent to a channel or to us.
+//     :nick!user@host PRIVMSG target :text
+type PrivmsgEvent struct {
+	Event
+	Target, Text string
+	// Public is true when Target is a channel.
+	Public bool
+}
+
+// JoinEvent is a nick joining a channel. Account and Realname are only
+// available when the server supports the extended-join capability;
+// Account is empty if the nick is not logged in to services.
+//     :nick!user@host JOIN #channel [account :realname]
+type JoinEvent struct {
+	Event
+	Channel, Account, Realname string
+}
+
+// PartEvent is a nick leaving a channel.
+//     :nick!user@host PART #channel [:reason]
+type PartEvent struct {
+	Event
+	Channel, Reason string
+}
+
+// KickEvent is a nick being removed from a channel by Sender.
+//     :nick!user@host KICK #channel nick [:reason]
+type KickEvent struct {
+	Event
+	Channel, Nick, Reason string
+}
+
+// QuitEvent is a nick disconnecting from the server.
+//     :nick!user@host QUIT [:reason]
+type QuitEvent struct {
+	Event
+	Reason string
+}
+
+// NickEvent is a nick changing to a new one.
+//     :old!user@host NICK new
+type NickEvent struct {
+	Event
+	Old, New string
+}
+
+// AccountEvent is a nick logging in to or out of a services account, sent
+// to clients with the account-notify capability. Account is empty if the
+// nick logged out.
+//     :nick!user@host ACCOUNT account
+type AccountEvent struct {
+	Event
+	Account string
+}
+
+// AwayEvent is a nick marking themselves as away or back, sent to clients
+// with the away-notify capability.
+//     :nick!user@host AWAY [:message]
+type AwayEvent struct {
+	Event
+	Away    bool
+	Message string
+}
+
+// ChghostEvent is a nick's ident or host changing, sent to clients with
+// the chghost capability. Sender contains the old ident and host.
+//     :nick!user@host CHGHOST ident host
+type ChghostEvent struct {
+	Event
+	Ident, Host string
+}
+
+// SetnameEvent is a nick changing their real name, sent to clients with
+// the setname capability.
+//     :nick!user@host SETNAME :realname
+type SetnameEvent struct {
+	Event
+	Realname string
+}
+
+// ModeChange is a single mode being set or unset by a MODE line.
+type ModeChange struct {
+	Set  bool
+	Mode byte
+	Arg  string
+}
+
+// String returns the change as it would appear in a MODE line,
+// e.g. "+o nick" or "-m".
+func (mc ModeChange) String() string {
+	s := "-"
+	if mc.Set {
+		s = "+"
+	}
+	s += string(mc.Mode)
+	if mc.Arg != "" {
+		s += " " + mc.Arg
+	}
+	return s
+}
+
+// ModeEvent is a change of modes on a channel or nick. The changes are
+// parsed according to the server's ISUPPORT CHANMODES and PREFIX tokens.
+//     :nick!user@host MODE target modestring [args...]
+type ModeEvent struct {
+	Event
+	Target string
+	// Channel is true when Target is a channel rather than a nick.
+	Channel bool
+	Changes []ModeChange
+}
+
+// NumericEvent is any numeric reply from the server.
+//     :server 001 nick params...
+type NumericEvent struct {
+	Event
+	// Code is the three-digit numeric, e.g. "001".
+	Code string
+	// Target is the nick the reply was sent to, i.e. usually us.
+	Target string
+	// Params contains the remaining arguments, including any text.
+	Params []string
+}
+
+// Text returns the last parameter of the numeric, or "" if there are none.
+func (ne *NumericEvent) Text() string {
+	if len(ne.Params) == 0 {
+		return ""
+	}
+	return ne.Params[len(ne.Params)-1]
+}
+
+// eventArgs checks that line has at least n arguments and logs a warning
+// if not. It is the equivalent of Line.argslen for typed events.
+func eventArgs(line *Line, n int) bool {
+	if len(line.Args) < n {
+		logging.Warn("irc.%s: too few arguments for event: %s",
+			line.Cmd, strings.Join(line.Args, " "))
+		return false
+	}
+	return true
+}
+
+func newPrivmsgEvent(line *Line) (*PrivmsgEvent, bool) {
+	if !eventArgs(line, 2) {
+		return nil, false
+	}
+	return &PrivmsgEvent{
+		Event:  newEvent(line),
+		Target: line.Args[0],
+		Text:   line.Args[1],
+		Public: line.Public(),
+	}, true
+}
+
+func newJoinEvent(line *Line) (*JoinEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	ev := &JoinEvent{Event: newEvent(line), Channel: line.Args[0]}
+	if len(line.Args) > 2 {
+		ev.Account, ev.Realname = account(line.Args[1]), line.Args[2]
+	}
+	return ev, true
+}
+
+func newPartEvent(line *Line) (*PartEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	ev := &PartEvent{Event: newEvent(line), Channel: line.Args[0]}
+	if len(line.Args) > 1 {
+		ev.Reason = line.Args[1]
+	}
+	return ev, true
+}
+
+func newKickEvent(line *Line) (*KickEvent, bool) {
+	if !eventArgs(line, 2) {
+		return nil, false
+	}
+	ev := &KickEvent{
+		Event:   newEvent(line),
+		Channel: line.Args[0],
+		Nick:    line.Args[1],
+	}
+	if len(line.Args) > 2 {
+		ev.Reason = line.Args[2]
+	}
+	return ev, true
+}
+
+func newQuitEvent(line *Line) (*QuitEvent, bool) {
+	return &QuitEvent{Event: newEvent(line), Reason: line.Text()}, true
+}
+
+func newNickEvent(line *Line) (*NickEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	return &NickEvent{Event: newEvent(line), Old: line.Nick, New: line.Args[0]}, true
+}
+
+func newAccountEvent(line *Line) (*AccountEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	return &AccountEvent{Event: newEvent(line), Account: account(line.Args[0])}, true
+}
+
+func newAwayEvent(line *Line) (*AwayEvent, bool) {
+	ev := &AwayEvent{Event: newEvent(line), Message: line.Text()}
+	ev.Away = ev.Message != ""
+	return ev, true
+}
+
+func newChghostEvent(line *Line) (*ChghostEvent, bool) {
+	if !eventArgs(line, 2) {
+		return nil, false
+	}
+	return &ChghostEvent{
+		Event: newEvent(line),
+		Ident: line.Args[0],
+		Host:  line.Args[1],
+	}, true
+}
+
+func newSetnameEvent(line *Line) (*SetnameEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	return &SetnameEvent{Event: newEvent(line), Realname: line.Args[0]}, true
+}
+
+func (conn *Conn) newModeEvent(line *Line) (*ModeEvent, bool) {
+	if !eventArgs(line, 2) {
+		return nil, false
+	}
+	ev := &ModeEvent{
+		Event:   newEvent(line),
+		Target:  line.Args[0],
+		Channel: conn.isChannel(line.Args[0]),
+	}
+	if ev.Channel {
+		ev.Changes = parseModeChanges(conn.isupport.chanModes(),
+			line.Args[1], line.Args[2:])
+	} else {
+		ev.Changes = parseModeChanges(nil, line.Args[1], nil)
+	}
+	return ev, true
+}
+
+func newNumericEvent(line *Line) (*NumericEvent, bool) {
+	if !isNumeric(line.Cmd) || !eventArgs(line, 1) {
+		return nil, false
+	}
+	return &NumericEvent{
+		Event:  newEvent(line),
+		Code:   line.Cmd,
+		Target: line.Args[0],
+		Params: line.Args[1:],
+	}, true
+}
+
+// parseModeChanges splits a mode string into individual changes, consuming
+// arguments for modes that take them according to cm. If cm is nil, the
+// modes are user modes, which never take arguments.
+func parseModeChanges(cm *chanModes, modes string, args []string) []ModeChange {
+	var changes []ModeChange
+	set := true
+	for i := 0; i < len(modes); i++ {
+		switch m := modes[i]; m {
+		case '+':
+			set = true
+		case '-':
+			set = false
+		default:
+			mc := ModeChange{Set: set, Mode: m}
+			if cm != nil && cm.takesArg(m, set) {
+				if len(args) == 0 {
+					logging.Warn("irc.MODE: not enough arguments for mode %c", m)
+					continue
+				}
+				mc.Arg, args = args[0], args[1:]
+			}
+			changes = append(changes, mc)
+		}
+	}
+	return changes
+}
+
+// OnPrivmsg registers a foreground handler for PRIVMSG events.
+// CTCPs and ACTIONs are not included; handle those separately.
+func (conn *Conn) OnPrivmsg(f func(*Conn, *PrivmsgEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(PRIVMSG, func(conn *Conn, line *Line) {
+		if ev, ok := newPrivmsgEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnNotice registers a foreground handler for NOTICE events.
+func (conn *Conn) OnNotice(f func(*Conn, *PrivmsgEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(NOTICE, func(conn *Conn, line *Line) {
+		if ev, ok := newPrivmsgEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnJoin registers a foreground handler for JOIN events.
+func (conn *Conn) OnJoin(f func(*Conn, *JoinEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(JOIN, func(conn *Conn, line *Line) {
+		if ev, ok := newJoinEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnPart registers a foreground handler for PART events.
+func (conn *Conn) OnPart(f func(*Conn, *PartEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(PART, func(conn *Conn, line *Line) {
+		if ev, ok := newPartEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnKick registers a foreground handler for KICK events.
+func (conn *Conn) OnKick(f func(*Conn, *KickEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(KICK, func(conn *Conn, line *Line) {
+		if ev, ok := newKickEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnQuit registers a foreground handler for QUIT events.
+func (conn *Conn) OnQuit(f func(*Conn, *QuitEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(QUIT, func(conn *Conn, line *Line) {
+		if ev, ok := newQuitEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnNick registers a foreground handler for NICK events.
+func (conn *Conn) OnNick(f func(*Conn, *NickEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(NICK, func(conn *Conn, line *Line) {
+		if ev, ok := newNickEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnAccount registers a foreground handler for ACCOUNT events.
+func (conn *Conn) OnAccount(f func(*Conn, *AccountEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(ACCOUNT, func(conn *Conn, line *Line) {
+		if ev, ok := newAccountEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnAway registers a foreground handler for AWAY events.
+func (conn *Conn) OnAway(f func(*Conn, *AwayEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(AWAY, func(conn *Conn, line *Line) {
+		if ev, ok := newAwayEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnChghost registers a foreground handler for CHGHOST events.
+func (conn *Conn) OnChghost(f func(*Conn, *ChghostEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(CHGHOST, func(conn *Conn, line *Line) {
+		if ev, ok := newChghostEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnSetname registers a foreground handler for SETNAME events.
+func (conn *Conn) OnSetname(f func(*Conn, *SetnameEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(SETNAME, func(conn *Conn, line *Line) {
+		if ev, ok := newSetnameEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnMode registers a foreground handler for MODE events.
+func (conn *Conn) OnMode(f func(*Conn, *ModeEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(MODE, func(conn *Conn, line *Line) {
+		if ev, ok := conn.newModeEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
+
+// OnNumeric registers a foreground handler for numeric replies matching
+// code, which may be a pattern like "4xx"; see Handle.
+func (conn *Conn) OnNumeric(code string, f func(*Conn, *NumericEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(code, func(conn *Conn, line *Line) {
+		if ev, ok := newNumericEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
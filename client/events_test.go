@@ -0,0 +1,150 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTypedEvents(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	var (
+		pm   *PrivmsgEvent
+		join *JoinEvent
+		kick *KickEvent
+		mode *ModeEvent
+		nick *NickEvent
+		num  *NumericEvent
+	)
+	c.OnPrivmsg(func(_ *Conn, e *PrivmsgEvent) { pm = e })
+	c.OnJoin(func(_ *Conn, e *JoinEvent) { join = e })
+	c.OnKick(func(_ *Conn, e *KickEvent) { kick = e })
+	c.OnMode(func(_ *Conn, e *ModeEvent) { mode = e })
+	c.OnNick(func(_ *Conn, e *NickEvent) { nick = e })
+	c.OnNumeric("4xx", func(_ *Conn, e *NumericEvent) { num = e })
+
+	dispatch := func(s string) { c.fgHandlers.dispatch(c, ParseLine(s)) }
+
+	dispatch("@time=2016-01-01T00:00:00Z :nick!user@host PRIVMSG #chan :hello there")
+	if pm == nil || pm.Target != "#chan" || pm.Text != "hello there" || !pm.Public ||
+		pm.Sender.String() != "nick!user@host" || pm.Tags["time"] == "" {
+		t.Errorf("Bad PrivmsgEvent: %#v", pm)
+	}
+
+	dispatch(":nick!user@host JOIN #chan")
+	if join == nil || join.Channel != "#chan" || join.Account != "" {
+		t.Errorf("Bad JoinEvent: %#v", join)
+	}
+	dispatch(":nick!user@host JOIN #chan acct :Real Name")
+	if join.Account != "acct" || join.Realname != "Real Name" {
+		t.Errorf("Bad extended JoinEvent: %#v", join)
+	}
+	dispatch(":nick!user@host JOIN #chan * :Real Name")
+	if join.Account != "" || join.Realname != "Real Name" {
+		t.Errorf("Bad extended JoinEvent for logged out nick: %#v", join)
+	}
+
+	dispatch(":op!user@host KICK #chan victim :go away")
+	if kick == nil || kick.Channel != "#chan" || kick.Nick != "victim" ||
+		kick.Reason != "go away" || kick.Sender.Nick != "op" {
+		t.Errorf("Bad KickEvent: %#v", kick)
+	}
+	// Too few arguments means the handler isn't called.
+	kick = nil
+	dispatch(":op!user@host KICK #chan")
+	if kick != nil {
+		t.Errorf("KickEvent handler called for short KICK.")
+	}
+
+	dispatch(":op!user@host MODE #chan +ov-k+lm nick1 nick2 key 10")
+	want := []ModeChange{
+		{true, 'o', "nick1"}, {true, 'v', "nick2"}, {false, 'k', "key"},
+		{true, 'l', "10"}, {true, 'm', ""},
+	}
+	if mode == nil || !mode.Channel || !reflect.DeepEqual(mode.Changes, want) {
+		t.Errorf("Bad channel ModeEvent: %#v", mode)
+	}
+	dispatch(":test!test@host MODE test +iw-x")
+	want = []ModeChange{{true, 'i', ""}, {true, 'w', ""}, {false, 'x', ""}}
+	if mode.Channel || !reflect.DeepEqual(mode.Changes, want) {
+		t.Errorf("Bad user ModeEvent: %#v", mode)
+	}
+
+	dispatch(":old!user@host NICK new")
+	if nick == nil || nick.Old != "old" || nick.New != "new" {
+		t.Errorf("Bad NickEvent: %#v", nick)
+	}
+
+	dispatch(":irc.server.org 401 test nobody :No such nick/channel")
+	if num == nil || num.Code != "401" || num.Target != "test" ||
+		num.Params[0] != "nobody" || num.Text() != "No such nick/channel" ||
+		num.Sender.String() != "irc.server.org" {
+		t.Errorf("Bad NumericEvent: %#v", num)
+	}
+}
+
+func TestModeEventISupport(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	// With a CHANMODES that makes 'j' take an argument when set,
+	// and a CHANTYPES that doesn't include '&'.
+	c.h_005(ParseLine(":irc.server.org 005 test CHANTYPES=# " +
+		"CHANMODES=b,k,lj,imnpst PREFIX=(ov)@+ :are supported by this server"))
+	ev, ok := c.newModeEvent(ParseLine(":op!u@h MODE #chan +jb 3:5 *!*@*"))
+	want := []ModeChange{{true, 'j', "3:5"}, {true, 'b', "*!*@*"}}
+	if !ok || !reflect.DeepEqual(ev.Changes, want) {
+		t.Errorf("Bad ModeEvent with ISUPPORT: %#v", ev)
+	}
+	if c.isChannel("&chan") || !c.isChannel("#chan") {
+		t.Errorf("CHANTYPES not used to identify channels.")
+	}
+}
+
+func TestIRCv3Events(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	var (
+		acct *AccountEvent
+		away *AwayEvent
+		chg  *ChghostEvent
+		name *SetnameEvent
+	)
+	c.OnAccount(func(_ *Conn, e *AccountEvent) { acct = e })
+	c.OnAway(func(_ *Conn, e *AwayEvent) { away = e })
+	c.OnChghost(func(_ *Conn, e *ChghostEvent) { chg = e })
+	c.OnSetname(func(_ *Conn, e *SetnameEvent) { name = e })
+
+	dispatch := func(s string) { c.fgHandlers.dispatch(c, ParseLine(s)) }
+
+	dispatch(":nick!user@host ACCOUNT acct")
+	if acct == nil || acct.Account != "acct" || acct.Sender.Nick != "nick" {
+		t.Errorf("Bad AccountEvent: %#v", acct)
+	}
+	dispatch(":nick!user@host ACCOUNT *")
+	if acct.Account != "" {
+		t.Errorf("Bad AccountEvent for logout: %#v", acct)
+	}
+
+	dispatch(":nick!user@host AWAY :gone fishing")
+	if away == nil || !away.Away || away.Message != "gone fishing" {
+		t.Errorf("Bad AwayEvent: %#v", away)
+	}
+	dispatch(":nick!user@host AWAY")
+	if away.Away || away.Message != "" {
+		t.Errorf("Bad AwayEvent for return: %#v", away)
+	}
+
+	dispatch(":nick!user@host CHGHOST newuser new.host")
+	if chg == nil || chg.Ident != "newuser" || chg.Host != "new.host" ||
+		chg.Sender.Host != "host" {
+		t.Errorf("Bad ChghostEvent: %#v", chg)
+	}
+
+	dispatch(":nick!user@host SETNAME :New Name")
+	if name == nil || name.Realname != "New Name" {
+		t.Errorf("Bad SetnameEvent: %#v", name)
+	}
+}
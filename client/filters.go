@@ -0,0 +1,89 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/fluffle/goirc/mask"
+)
+
+// A Filter restricts the lines a handler will be called for. Filters are
+// passed when registering a handler, e.g.
+//
+//     conn.HandleFunc(PRIVMSG, h, InChannel("#ops"), TextMatches(re))
+//
+// and are evaluated in order in the dispatching goroutine before the handler
+// is scheduled, so they should be cheap. A handler is only called if every
+// one of its filters returns true.
+type Filter func(*Line) bool
+
+// Commands accepts lines whose command is any of the given commands,
+// ignoring case.
+func Commands(cmds ...string) Filter {
+	return func(line *Line) bool {
+		cmd := strings.ToUpper(line.Cmd)
+		for _, c := range cmds {
+			if strings.ToUpper(c) == cmd {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// InChannel accepts lines whose target is one of the given channels.
+func InChannel(channels ...string) Filter {
+	return func(line *Line) bool {
+		t := line.Target()
+		for _, c := range channels {
+			if strings.EqualFold(t, c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FromNick accepts lines sent by any of the given nicks.
+func FromNick(nicks ...string) Filter {
+	return func(line *Line) bool {
+		for _, n := range nicks {
+			if strings.EqualFold(line.Nick, n) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FromMask accepts lines whose source nick!ident@host matches any of the
+// given glob masks, in which "*" matches any run of characters and "?"
+// matches any single character, e.g. "*!*@staff/*". Incomplete masks are
+// normalised like bans, so "nick" is "nick!*@*", and the host may be a
+// CIDR range. Matching uses the default rfc1459 casemapping; use
+// Conn.Matcher to match using the server's.
+func FromMask(masks ...string) Filter {
+	ms := make([]mask.Mask, len(masks))
+	for i, m := range masks {
+		ms[i] = mask.Parse(m)
+	}
+	return func(line *Line) bool {
+		if line.Nick == "" {
+			return false
+		}
+		u := line.User()
+		for _, m := range ms {
+			if m.Matches(mask.RFC1459, u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TextMatches accepts lines whose text matches the regular expression.
+func TextMatches(re *regexp.Regexp) Filter {
+	return func(line *Line) bool {
+		return re.MatchString(line.Text())
+	}
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	pub := ParseLine(":nick!user@staff/nick PRIVMSG #Ops :!deploy now")
+	priv := ParseLine(":other!user@host.com PRIVMSG me :hello")
+
+	tests := []struct {
+		f         Filter
+		pub, priv bool
+	}{
+		{Commands("privmsg"), true, true},
+		{Commands(NOTICE, KICK), false, false},
+		{InChannel("#ops"), true, false},
+		{InChannel("#dev", "#OPS"), true, false},
+		{InChannel("#dev"), false, false},
+		{FromNick("NICK"), true, false},
+		{FromNick("nick", "other"), true, true},
+		{FromMask("*!*@staff/*"), true, false},
+		{FromMask("*!*@*.com"), false, true},
+		{FromMask("OTHER", "*.com"), false, true},
+		{TextMatches(regexp.MustCompile(`^!deploy\b`)), true, false},
+		{TextMatches(regexp.MustCompile(`hel+o`)), false, true},
+	}
+	for i, test := range tests {
+		if m := test.f(pub); m != test.pub {
+			t.Errorf("test %d: expected %t for public line, got %t", i, test.pub, m)
+		}
+		if m := test.f(priv); m != test.priv {
+			t.Errorf("test %d: expected %t for private line, got %t", i, test.priv, m)
+		}
+	}
+	// Lines from servers have no nick and so never match masks.
+	if FromMask("*")(ParseLine(":irc.server.org 001 me :Welcome")) {
+		t.Errorf("FromMask matched a server line.")
+	}
+}
@@ -12,10 +12,20 @@ import (
 var intHandlers = map[string]HandlerFunc{
 	REGISTER: (*Conn).h_REGISTER,
 	"001":    (*Conn).h_001,
+	"005":    (*Conn).h_005,
+	"353":    (*Conn).h_names353,
+	"366":    (*Conn).h_names366,
+	"396":    (*Conn).h_396,
 	"433":    (*Conn).h_433,
+	BATCH:    (*Conn).h_BATCH,
+	CAP:      (*Conn).h_CAP,
+	CHGHOST:  (*Conn).h_chghostHostmask,
 	CTCP:     (*Conn).h_CTCP,
+	FAIL:     (*Conn).h_FAIL,
 	NICK:     (*Conn).h_NICK,
 	PING:     (*Conn).h_PING,
+
+	DISCONNECTED: (*Conn).h_chathistoryDisconnected,
 }
 
 func (conn *Conn) addIntHandlers() {
@@ -33,6 +43,7 @@ func (conn *Conn) h_PING(line *Line) {
 
 // Handler for initial registration with server once tcp connection is made.
 func (conn *Conn) h_REGISTER(line *Line) {
+	conn.startCaps()
 	if conn.cfg.Pass != "" {
 		conn.Pass(conn.cfg.Pass)
 	}
@@ -46,6 +57,8 @@ func (conn *Conn) h_001(line *Line) {
 	conn.dispatch(&Line{Cmd: CONNECTED, Time: time.Now()})
 	// and we're being given our hostname (from the server's perspective)
 	t := line.Args[len(line.Args)-1]
+	_, ident, host := splitHostmask(t)
+	conn.hostmask.set(line.Args[0], ident, host)
 	if idx := strings.LastIndex(t, " "); idx != -1 {
 		t = t[idx+1:]
 		if idx = strings.Index(t, "@"); idx != -1 {
@@ -59,13 +72,19 @@ func (conn *Conn) h_001(line *Line) {
 	}
 }
 
-// XXX: do we need 005 protocol support message parsing here?
-// probably in the future, but I can't quite be arsed yet.
+// Handler to store the server's RPL_ISUPPORT tokens, e.g.
 /*
 	:irc.pl0rt.org 005 GoTest CMDS=KNOCK,MAP,DCCALLOW,USERIP UHNAMES NAMESX SAFELIST HCN MAXCHANNELS=20 CHANLIMIT=#:20 MAXLIST=b:60,e:60,I:60 NICKLEN=30 CHANNELLEN=32 TOPICLEN=307 KICKLEN=307 AWAYLEN=307 :are supported by this server
 	:irc.pl0rt.org 005 GoTest MAXTARGETS=20 WALLCHOPS WATCH=128 WATCHOPTS=A SILENCE=15 MODES=12 CHANTYPES=# PREFIX=(qaohv)~&@%+ CHANMODES=beI,kfL,lj,psmntirRcOAQKVCuzNSMT NETWORK=bb101.net CASEMAPPING=ascii EXTBAN=~,cqnr ELIST=MNUCT :are supported by this server
 	:irc.pl0rt.org 005 GoTest STATUSMSG=~&@%+ EXCEPTS INVEX :are supported by this server
 */
+func (conn *Conn) h_005(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	// Args[0] is our nick and the last arg is the trailing text.
+	conn.isupport.parse(line.Args[1 : len(line.Args)-1])
+}
 
 // Handler to deal with "433 :Nickname already in use"
 func (conn *Conn) h_433(line *Line) {
@@ -88,18 +107,12 @@ func (conn *Conn) h_433(line *Line) {
 	}
 }
 
-// Handle VERSION requests and CTCP PING
-func (conn *Conn) h_CTCP(line *Line) {
-	if line.Args[0] == VERSION {
-		conn.CtcpReply(line.Nick, VERSION, conn.cfg.Version)
-	} else if line.Args[0] == PING && line.argslen(2) {
-		conn.CtcpReply(line.Nick, PING, line.Args[2])
-	}
-}
-
 // Handle updating our own NICK if we're not using the state tracker
 func (conn *Conn) h_NICK(line *Line) {
 	if conn.st == nil && line.Nick == conn.cfg.Me.Nick {
 		conn.cfg.Me.Nick = line.Args[0]
 	}
+	if nick, _, _ := conn.hostmask.get(); nick != "" && conn.Matcher().Equal(line.Nick, nick) {
+		conn.hostmask.set(line.Args[0], "", "")
+	}
 }
@@ -3,6 +3,7 @@ package client
 import (
 	"github.com/fluffle/goirc/state"
 	"github.com/golang/mock/gomock"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,15 +24,21 @@ func TestREGISTER(t *testing.T) {
 	c, s := setUp(t)
 	defer s.tearDown()
 
+	// Even without state tracking or configured capabilities, we use CAP
+	// to ask for multiline messages.
+	c.st = nil
 	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.ExpectNothing()
+	c.st = s.st
 
 	c.cfg.Pass = "12345"
 	c.cfg.Me.Ident = "idiot"
 	c.cfg.Me.Name = "I've got the same combination on my luggage!"
 	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("PASS 12345")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER idiot 12 * :I've got the same combination on my luggage!")
@@ -173,6 +180,7 @@ func TestJOIN(t *testing.T) {
 		s.st.EXPECT().Me().Return(c.cfg.Me),
 		s.st.EXPECT().NewChannel("#test1").Return(chan1),
 		s.st.EXPECT().Associate("#test1", "test"),
+		s.st.EXPECT().NickActive("test", gomock.Any()),
 	)
 
 	// Use #test1 to test expected behaviour
@@ -192,6 +200,7 @@ func TestJOIN(t *testing.T) {
 		s.st.EXPECT().NewNick("user1").Return(nick1),
 		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "").Return(nick1),
 		s.st.EXPECT().Associate("#test1", "user1"),
+		s.st.EXPECT().NickActive("user1", gomock.Any()),
 	)
 
 	// OK, now #test1 exists, JOIN another user we don't know about
@@ -206,9 +215,37 @@ func TestJOIN(t *testing.T) {
 		s.st.EXPECT().GetChannel("#test1").Return(chan1),
 		s.st.EXPECT().GetNick("user2").Return(nick2),
 		s.st.EXPECT().Associate("#test1", "user2"),
+		s.st.EXPECT().NickActive("user2", gomock.Any()),
 	)
 	c.h_JOIN(ParseLine(":user2!ident2@host2.com JOIN :#test1"))
 
+	// With extended-join, we also learn the nick's account and real name.
+	gomock.InOrder(
+		s.st.EXPECT().GetChannel("#test1").Return(chan1),
+		s.st.EXPECT().GetNick("user3").Return(&state.Nick{Nick: "user3"}),
+		s.st.EXPECT().Associate("#test1", "user3"),
+		s.st.EXPECT().NickAccount("user3", ""),
+		s.st.EXPECT().NickInfo("user3", "ident3", "host3.com", "Real Name"),
+		s.st.EXPECT().NickActive("user3", gomock.Any()),
+	)
+	c.h_JOIN(ParseLine(":user3!ident3@host3.com JOIN #test1 * :Real Name"))
+
+	// And don't need to ask the server about new nicks.
+	c.caps.enabled["extended-join"] = true
+	gomock.InOrder(
+		s.st.EXPECT().GetChannel("#test1").Return(chan1),
+		s.st.EXPECT().GetNick("user4").Return(nil),
+		s.st.EXPECT().NewNick("user4").Return(&state.Nick{Nick: "user4"}),
+		s.st.EXPECT().NickInfo("user4", "ident4", "host4.com", ""),
+		s.st.EXPECT().Associate("#test1", "user4"),
+		s.st.EXPECT().NickAccount("user4", "acct"),
+		s.st.EXPECT().NickInfo("user4", "ident4", "host4.com", "Real Name"),
+		s.st.EXPECT().NickActive("user4", gomock.Any()),
+	)
+	c.h_JOIN(ParseLine(":user4!ident4@host4.com JOIN #test1 acct :Real Name"))
+	s.nc.ExpectNothing()
+	delete(c.caps.enabled, "extended-join")
+
 	// Test error paths
 	gomock.InOrder(
 		// unknown channel, unknown nick
@@ -369,6 +406,7 @@ func Test352(t *testing.T) {
 		s.st.EXPECT().GetNick("user1").Return(&state.Nick{Nick: "user1"}),
 		s.st.EXPECT().Me().Return(c.cfg.Me),
 		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "name"),
+		s.st.EXPECT().NickAway("user1", true, ""),
 	)
 	c.h_352(ParseLine(":irc.server.org 352 test #test1 ident1 host1.com irc.server.org user1 G :0 name"))
 
@@ -379,6 +417,7 @@ func Test352(t *testing.T) {
 		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "name"),
 		s.st.EXPECT().NickModes("user1", "+o"),
 		s.st.EXPECT().NickModes("user1", "+i"),
+		s.st.EXPECT().NickAway("user1", false, ""),
 	)
 	c.h_352(ParseLine(":irc.server.org 352 test #test1 ident1 host1.com irc.server.org user1 H* :0 name"))
 
@@ -428,6 +467,19 @@ func Test353(t *testing.T) {
 	c.h_353(ParseLine(":irc.server.org 353 test = #test1 :test @user1 user2 +voice "))
 	c.h_353(ParseLine(":irc.server.org 353 test = #test1 :%halfop @op &admin ~owner "))
 
+	// With multi-prefix and userhost-in-names, all the prefixes should be
+	// applied and the ident and host recorded.
+	s.st.EXPECT().GetChannel("#test1").Return(&state.Channel{Name: "#test1"})
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("multi").Return(nil),
+		s.st.EXPECT().NewNick("multi").Return(&state.Nick{Nick: "multi"}),
+		s.st.EXPECT().NickHost("multi", "ident", "host.com"),
+		s.st.EXPECT().IsOn("#test1", "multi").Return(nil, false),
+		s.st.EXPECT().Associate("#test1", "multi").Return(&state.ChanPrivs{}),
+		s.st.EXPECT().ChannelModes("#test1", "+ov", "multi", "multi"),
+	)
+	c.h_353(ParseLine(":irc.server.org 353 test = #test1 :@+multi!ident@host.com"))
+
 	// Check error paths -- send 353 for an unknown channel
 	s.st.EXPECT().GetChannel("#test2").Return(nil)
 	c.h_353(ParseLine(":irc.server.org 353 test = #test2 :test ~user3"))
@@ -449,3 +501,132 @@ func Test671(t *testing.T) {
 	s.st.EXPECT().GetNick("user2").Return(nil)
 	c.h_671(ParseLine(":irc.server.org 671 test user2 :some ignored text"))
 }
+
+// Test the handler for 005 / RPL_ISUPPORT
+func Test005(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	c.h_005(ParseLine(":irc.server.org 005 test CHANTYPES=# EXCEPTS " +
+		"NETWORK=bb101.net :are supported by this server"))
+	if v, ok := c.ISupport("NETWORK"); !ok || v != "bb101.net" {
+		t.Errorf("ISUPPORT NETWORK not stored, got %q.", v)
+	}
+	if _, ok := c.ISupport("EXCEPTS"); !ok {
+		t.Errorf("ISUPPORT EXCEPTS not stored.")
+	}
+	// The trailing text should not be stored as a token.
+	if _, ok := c.ISupport("are supported by this server"); ok {
+		t.Errorf("Trailing text stored as ISUPPORT token.")
+	}
+}
+
+func TestStateChangeEvents(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	// Use a real state tracker for this test.
+	c.st = nil
+	c.EnableStateTracking()
+
+	var got []string
+	c.HandleFunc("*", func(conn *Conn, line *Line) {
+		switch line.Cmd {
+		case NICKJOINED, NICKLEFT, NICKRENAMED, PRIVCHANGED,
+			TOPICCHANGED, CHANMODECHANGED:
+			got = append(got, line.Nick+" "+line.Cmd+" "+
+				strings.Join(line.Args, ","))
+		}
+	})
+
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	c.dispatch(ParseLine(":user1!ident1@host1.com JOIN :#test1"))
+	s.nc.Expect("WHO user1")
+	c.dispatch(ParseLine(":user1!ident1@host1.com MODE #test1 +mo user1"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com TOPIC #test1 :a topic"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com NICK :user2"))
+	c.dispatch(ParseLine(":user2!ident1@host1.com PART #test1 :bye"))
+
+	want := []string{
+		"test NICKJOINED #test1",
+		"user1 NICKJOINED #test1",
+		" CHANMODECHANGED #test1,,+m",
+		"user1 PRIVCHANGED #test1,,+o",
+		" TOPICCHANGED #test1,,a topic",
+		"user1 NICKRENAMED user2",
+		"user2 NICKLEFT #test1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Event %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+// Test the handlers that track away status, accounts and activity
+func TestAwayAccountActivity(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	now := time.Now()
+	l := ParseLine(":user1!ident1@host1.com PRIVMSG #test1 :hello")
+	l.Time = now
+	gomock.InOrder(
+		s.st.EXPECT().NickActive("user1", now),
+		s.st.EXPECT().AddMessage(&state.Message{Time: now, Kind: "PRIVMSG",
+			Nick: "user1", Ident: "ident1", Host: "host1.com",
+			Target: "#test1", Text: "hello"}),
+	)
+	c.h_STACTIVE(l)
+	// Notices from servers aren't kept in the message history.
+	c.h_STACTIVE(ParseLine(":irc.server.org NOTICE test :*** Looking up your hostname"))
+
+	gomock.InOrder(
+		s.st.EXPECT().NickAway("user1", true, "gone fishing"),
+		s.st.EXPECT().NickAway("user1", false, ""),
+		s.st.EXPECT().NickAccount("user1", "acct"),
+		s.st.EXPECT().NickAccount("user1", ""),
+	)
+	c.h_AWAY(ParseLine(":user1!ident1@host1.com AWAY :gone fishing"))
+	c.h_AWAY(ParseLine(":user1!ident1@host1.com AWAY"))
+	c.h_ACCOUNT(ParseLine(":user1!ident1@host1.com ACCOUNT acct"))
+	c.h_ACCOUNT(ParseLine(":user1!ident1@host1.com ACCOUNT *"))
+
+	gomock.InOrder(
+		s.st.EXPECT().NickHost("user1", "ident2", "host2.com"),
+		s.st.EXPECT().NickRealname("user1", "New Name"),
+	)
+	c.h_CHGHOST(ParseLine(":user1!ident1@host1.com CHGHOST ident2 host2.com"))
+	c.h_SETNAME(ParseLine(":user1!ident1@host1.com SETNAME :New Name"))
+	c.h_CHGHOST(ParseLine(":user1!ident1@host1.com CHGHOST ident2"))
+
+	// WHOIS and away numerics
+	me := &state.Nick{Nick: "test"}
+	l = ParseLine(":irc.server.org 317 test user1 60 1500000000 :seconds idle")
+	l.Time = now
+	gomock.InOrder(
+		s.st.EXPECT().NickAway("user1", true, "gone fishing"),
+		s.st.EXPECT().NickAccount("user1", "acct"),
+		s.st.EXPECT().NickActive("user1", now.Add(-time.Minute)),
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().NickAway("test", true, "brb"),
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().NickAway("test", false, ""),
+	)
+	c.h_301(ParseLine(":irc.server.org 301 test user1 :gone fishing"))
+	c.h_330(ParseLine(":irc.server.org 330 test user1 acct :is logged in as"))
+	c.h_317(l)
+	c.Away("brb")
+	s.nc.Expect("AWAY :brb")
+	c.h_306(ParseLine(":irc.server.org 306 test :You have been marked as being away"))
+	c.h_305(ParseLine(":irc.server.org 305 test :You are no longer marked as being away"))
+
+	// Check error paths -- bad idle time and missing arguments
+	c.h_317(ParseLine(":irc.server.org 317 test user1 lots :seconds idle"))
+	c.h_330(ParseLine(":irc.server.org 330 test user1"))
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/mask"
+)
+
+// These defaults are used in place of ISUPPORT tokens the server doesn't
+// send. They cover the modes the state tracker understands.
+const (
+	defaultChanModes = "beI,k,l,imnpstrzOZ"
+	defaultPrefix    = "(qaohv)~&@%+"
+	defaultChanTypes = "#&"
+)
+
+// isupport stores the tokens the server has sent in RPL_ISUPPORT (005).
+// http://modern.ircdocs.horse/#rplisupport-parameters
+type isupport struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func newISupport() *isupport {
+	return &isupport{tokens: make(map[string]string)}
+}
+
+// parse handles the parameters of a single 005 line, excluding the
+// client's nick and the trailing "are supported by this server" text.
+func (is *isupport) parse(params []string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for _, p := range params {
+		if p == "" {
+			continue
+		}
+		if p[0] == '-' {
+			delete(is.tokens, strings.ToUpper(p[1:]))
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 1 {
+			is.tokens[strings.ToUpper(kv[0])] = ""
+		} else {
+			is.tokens[strings.ToUpper(kv[0])] = unescapeISupport(kv[1])
+		}
+	}
+}
+
+func (is *isupport) get(token string) (string, bool) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	v, ok := is.tokens[strings.ToUpper(token)]
+	return v, ok
+}
+
+func (is *isupport) getDefault(token, def string) string {
+	if v, ok := is.get(token); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (is *isupport) wipe() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.tokens = make(map[string]string)
+}
+
+// unescapeISupport decodes the \xHH escapes permitted in ISUPPORT values.
+func unescapeISupport(v string) string {
+	if !strings.Contains(v, `\x`) {
+		return v
+	}
+	out := make([]byte, 0, len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+3 < len(v) && v[i+1] == 'x' {
+			if b, err := strconv.ParseUint(v[i+2:i+4], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, v[i])
+	}
+	return string(out)
+}
+
+// chanModes describes how the server's channel modes take parameters.
+type chanModes struct {
+	// Type A modes are lists, B always take a parameter, C only
+	// take one when set, and D never do.
+	a, b, c, d string
+	// Prefix modes grant channel privileges and always take a nick,
+	// and are represented in NAMES replies by the matching symbols.
+	prefixModes, prefixSymbols string
+}
+
+// chanModes returns the server's channel mode types, using the defaults
+// for anything it didn't advertise.
+func (is *isupport) chanModes() *chanModes {
+	cm := &chanModes{}
+	types := strings.SplitN(is.getDefault("CHANMODES", defaultChanModes), ",", 4)
+	for len(types) < 4 {
+		types = append(types, "")
+	}
+	cm.a, cm.b, cm.c, cm.d = types[0], types[1], types[2], types[3]
+	prefix := is.getDefault("PREFIX", defaultPrefix)
+	if idx := strings.Index(prefix, ")"); prefix[0] == '(' && idx != -1 {
+		cm.prefixModes, cm.prefixSymbols = prefix[1:idx], prefix[idx+1:]
+	}
+	return cm
+}
+
+// takesArg returns true if mode m takes a parameter when set (or unset,
+// if set is false).
+func (cm *chanModes) takesArg(m byte, set bool) bool {
+	switch {
+	case strings.IndexByte(cm.prefixModes, m) != -1,
+		strings.IndexByte(cm.a, m) != -1,
+		strings.IndexByte(cm.b, m) != -1:
+		return true
+	case strings.IndexByte(cm.c, m) != -1:
+		return set
+	}
+	return false
+}
+
+// ISupport returns the value of an ISUPPORT token sent by the server in
+// 005 replies, and whether that token has been sent at all. Tokens without
+// a value, like "EXCEPTS", return an empty string. The tokens are reset
+// each time the client connects.
+func (conn *Conn) ISupport(token string) (string, bool) {
+	return conn.isupport.get(token)
+}
+
+// Matcher returns a mask.Matcher that matches masks using the server's
+// CASEMAPPING and understands its EXTBANs.
+func (conn *Conn) Matcher() *mask.Matcher {
+	cm, _ := conn.isupport.get("CASEMAPPING")
+	eb, _ := conn.isupport.get("EXTBAN")
+	return mask.NewMatcher(cm, eb)
+}
+
+// isChannel returns true if name starts with one of the server's CHANTYPES.
+func (conn *Conn) isChannel(name string) bool {
+	return name != "" && strings.IndexByte(
+		conn.isupport.getDefault("CHANTYPES", defaultChanTypes), name[0]) != -1
+}
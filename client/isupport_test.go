@@ -0,0 +1,86 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestISupportParse(t *testing.T) {
+	is := newISupport()
+	is.parse([]string{"CHANTYPES=#", "EXCEPTS", "PREFIX=(qaohv)~&@%+",
+		"NETWORK=Some\\x20Network", "chanmodes=beI,kfL,lj,psmntirRcOAQKVCuzNSMT"})
+
+	tests := []struct {
+		token, value string
+		ok           bool
+	}{
+		{"CHANTYPES", "#", true},
+		{"excepts", "", true},
+		{"NETWORK", "Some Network", true},
+		{"CHANMODES", "beI,kfL,lj,psmntirRcOAQKVCuzNSMT", true},
+		{"WHOX", "", false},
+	}
+	for i, test := range tests {
+		if v, ok := is.get(test.token); v != test.value || ok != test.ok {
+			t.Errorf("test %d: get(%q) = %q, %t", i, test.token, v, ok)
+		}
+	}
+
+	// Negation removes a token.
+	is.parse([]string{"-EXCEPTS"})
+	if _, ok := is.get("EXCEPTS"); ok {
+		t.Errorf("Negated token still present.")
+	}
+
+	cm := is.chanModes()
+	if cm.a != "beI" || cm.b != "kfL" || cm.c != "lj" ||
+		cm.prefixModes != "qaohv" || cm.prefixSymbols != "~&@%+" {
+		t.Errorf("CHANMODES/PREFIX parsed incorrectly: %#v", cm)
+	}
+	for _, test := range []struct {
+		m        byte
+		set, arg bool
+	}{
+		{'b', false, true}, {'k', false, true}, {'l', true, true},
+		{'l', false, false}, {'m', true, false}, {'o', false, true},
+	} {
+		if cm.takesArg(test.m, test.set) != test.arg {
+			t.Errorf("takesArg(%c, %t) != %t", test.m, test.set, test.arg)
+		}
+	}
+
+	is.wipe()
+	if cm := is.chanModes(); cm.a != "beI" || cm.d != "imnpstrzOZ" ||
+		cm.prefixModes != "qaohv" {
+		t.Errorf("Default CHANMODES/PREFIX incorrect: %#v", cm)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	u := ParseLine("@account=acct :nick[a]!ident@host.com PRIVMSG #chan :hi").User()
+	if mt := c.Matcher(); !mt.Match("NICK{A}", u) || mt.Match("$a:acct", u) {
+		t.Errorf("Default matcher incorrect: %#v", mt)
+	}
+	c.isupport.parse([]string{"CASEMAPPING=ascii", "EXTBAN=$,ajrx"})
+	if mt := c.Matcher(); mt.Match("NICK{A}", u) || !mt.Match("$a:acct", u) {
+		t.Errorf("Matcher ignores ISUPPORT: %#v", mt)
+	}
+}
+
+func TestUnescapeISupport(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"plain", "plain"},
+		{`a\x20b`, "a b"},
+		{`\x3D\x5C`, `=\`},
+		{`bad\x2`, `bad\x2`},
+		{`bad\xZZ`, `bad\xZZ`},
+	}
+	for i, test := range tests {
+		if out := unescapeISupport(test.in); out != test.out {
+			t.Errorf("test %d: expected %q, got %q", i, test.out, out)
+		}
+	}
+}
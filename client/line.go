@@ -2,13 +2,17 @@ package client
 
 import (
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/fluffle/goirc/format"
 	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/mask"
 )
 
 var tagsReplacer = strings.NewReplacer("\\:", ";", "\\s", " ", "\\r", "\r", "\\n", "\n")
+var tagsEscaper = strings.NewReplacer(";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n")
 
 // We parse an incoming line into this struct. Line.Cmd is used as the trigger
 // name for incoming event handlers and is the IRC verb, the first sequence
@@ -38,6 +42,17 @@ func (l *Line) Copy() *Line {
 	return &nl
 }
 
+// User returns the sender of the line, for matching against masks. The
+// account is only known if the server supports the account-tag capability.
+func (line *Line) User() mask.User {
+	return mask.User{
+		Nick:    line.Nick,
+		Ident:   line.Ident,
+		Host:    line.Host,
+		Account: line.Tags["account"],
+	}
+}
+
 // Text returns the contents of the text portion of a line. This only really
 // makes sense for lines with a :text part, but there are a lot of them.
 func (line *Line) Text() string {
@@ -47,6 +62,22 @@ func (line *Line) Text() string {
 	return ""
 }
 
+// ctcpText returns the text following the command of a CTCP message, or ""
+// if there is none. ParseLine leaves the text of a CTCP message without any
+// as it was, still wrapped in "\001".
+func (line *Line) ctcpText() string {
+	if t := line.Text(); !strings.HasPrefix(t, "\001") {
+		return t
+	}
+	return ""
+}
+
+// PlainText returns the text portion of a line without any bold, colour or
+// other formatting codes, for matching commands and the like.
+func (line *Line) PlainText() string {
+	return format.Strip(line.Text())
+}
+
 // Target returns the contextual target of the line, usually the first Arg
 // for the IRC verb. If the line was broadcast from a channel, the target
 // will be that channel. If the line was sent directly by a user, the target
@@ -79,11 +110,17 @@ func (line *Line) Target() string {
 func (line *Line) Public() bool {
 	switch line.Cmd {
 	case PRIVMSG, NOTICE, ACTION:
+		if len(line.Args) == 0 || line.Args[0] == "" {
+			return false
+		}
 		switch line.Args[0][0] {
 		case '#', '&', '+', '!':
 			return true
 		}
 	case CTCP, CTCPREPLY:
+		if len(line.Args) < 2 || line.Args[1] == "" {
+			return false
+		}
 		// CTCP prepends the CTCP verb to line.Args, thus for the message
 		//   :nick!user@host PRIVMSG #foo :\001BAR baz\001
 		// line.Args contains: []string{"BAR", "#foo", "baz"}
@@ -98,6 +135,58 @@ func (line *Line) Public() bool {
 	return false
 }
 
+// String formats the line as IRC protocol, without the trailing "\r\n".
+// It reverses the changes ParseLine makes to CTCP messages, so an ACTION
+// is formatted as a PRIVMSG again. Line.Raw is ignored, so this can be
+// used to serialise a Line that has been modified or built from scratch.
+func (line *Line) String() string {
+	s := ""
+	if len(line.Tags) > 0 {
+		tags := make([]string, 0, len(line.Tags))
+		for k, v := range line.Tags {
+			if v != "" {
+				k += "=" + tagsEscaper.Replace(v)
+			}
+			tags = append(tags, k)
+		}
+		sort.Strings(tags)
+		s += "@" + strings.Join(tags, ";") + " "
+	}
+	if line.Src != "" {
+		s += ":" + line.Src + " "
+	}
+	cmd, args := line.Cmd, line.Args
+	switch {
+	case cmd == ACTION && len(args) > 1:
+		ctcp := "\001ACTION"
+		if t := line.ctcpText(); t != "" {
+			ctcp += " " + t
+		}
+		cmd, args = PRIVMSG, []string{args[0], ctcp + "\001"}
+	case (cmd == CTCP || cmd == CTCPREPLY) && len(args) > 1:
+		ctcp := "\001" + args[0]
+		if t := line.ctcpText(); len(args) > 2 && t != "" {
+			ctcp += " " + t
+		}
+		cmd, args = NOTICE, []string{args[1], ctcp + "\001"}
+		if line.Cmd == CTCP {
+			cmd = PRIVMSG
+		}
+	}
+	s += cmd
+	// Message text is always sent as a trailing argument, other
+	// arguments only when they need to be.
+	text := cmd == PRIVMSG || cmd == NOTICE
+	for i, arg := range args {
+		if i == len(args)-1 && (text || arg == "" || arg[0] == ':' ||
+			strings.Contains(arg, " ")) {
+			arg = ":" + arg
+		}
+		s += " " + arg
+	}
+	return s
+}
+
 // ParseLine creates a Line from an incoming message from the IRC server.
 //
 // It contains special casing for CTCP messages, most notably CTCP ACTION.
@@ -153,13 +242,7 @@ func ParseLine(s string) *Line {
 		}
 
 		// src can be the hostname of the irc server or a nick!user@host
-		line.Host = line.Src
-		nidx, uidx := strings.Index(line.Src, "!"), strings.Index(line.Src, "@")
-		if uidx != -1 && nidx != -1 {
-			line.Nick = line.Src[:nidx]
-			line.Ident = line.Src[nidx+1 : uidx]
-			line.Host = line.Src[uidx+1:]
-		}
+		line.Nick, line.Ident, line.Host = mask.Split(line.Src)
 	}
 
 	// now we're here, we've parsed a :nick!user@host or :server off
@@ -179,7 +262,7 @@ func ParseLine(s string) *Line {
 	// separate events as opposed to forcing people to have gargantuan
 	// handlers to cope with the possibilities.
 	if (line.Cmd == PRIVMSG || line.Cmd == NOTICE) &&
-		len(line.Args[1]) > 2 &&
+		len(line.Args) > 1 && len(line.Args[1]) > 2 &&
 		strings.HasPrefix(line.Args[1], "\001") &&
 		strings.HasSuffix(line.Args[1], "\001") {
 		// WOO, it's a CTCP message
@@ -66,6 +66,36 @@ func TestLineText(t *testing.T) {
 	}
 }
 
+func TestLinePlainText(t *testing.T) {
+	l := ParseLine(":nick!ident@host PRIVMSG #chan :\x02!\x0304,01cmd\x0f arg")
+	if pt := l.PlainText(); pt != "!cmd arg" {
+		t.Errorf("PlainText() = %q", pt)
+	}
+	if pt := (&Line{}).PlainText(); pt != "" {
+		t.Errorf("PlainText() of empty line = %q", pt)
+	}
+}
+
+func TestLineUser(t *testing.T) {
+	tests := []struct {
+		in   string
+		user string
+	}{
+		{"@account=acct :nick!ident@host.com PRIVMSG #chan :hi", "nick!ident@host.com"},
+		{":nick@host.com PRIVMSG #chan :hi", "nick!@host.com"},
+		{":irc.server.org 001 nick :Welcome", "!@irc.server.org"},
+	}
+	for i, test := range tests {
+		u := ParseLine(test.in).User()
+		if u.String() != test.user {
+			t.Errorf("test %d: expected user %q, got %q", i, test.user, u)
+		}
+	}
+	if u := ParseLine(tests[0].in).User(); u.Account != "acct" {
+		t.Errorf("Account not taken from account tag: %q", u.Account)
+	}
+}
+
 func TestLineTarget(t *testing.T) {
 	tests := []struct {
 		in  *Line
@@ -184,3 +214,37 @@ func TestLineTags(t *testing.T) {
 		}
 	}
 }
+
+func TestLineString(t *testing.T) {
+	tests := []string{
+		"PRIVMSG #chan :hello there",
+		"MODE #chan +o nick",
+		"NICK foo",
+		"TOPIC #chan :",
+		"PRIVMSG #chan ::)",
+		":nick!user@host PRIVMSG #chan :\001ACTION waves\001",
+		":nick!user@host PRIVMSG me :\001VERSION\001",
+		":nick!user@host PRIVMSG #chan :\001ACTION\001",
+		":nick!user@host NOTICE me :\001PING 1234\001",
+		"@a=b;c=some\\sthing\\:else;d :nick!user@host PRIVMSG #chan :hi",
+		":irc.server.org 001 test :Welcome to IRC",
+	}
+	for i, test := range tests {
+		if s := ParseLine(test).String(); s != test {
+			t.Errorf("test %d: expected %q, got %q", i, test, s)
+		}
+	}
+
+	// CTCPs with no text keep the wrapped CTCP as their text.
+	l := ParseLine(":nick!user@host PRIVMSG me :\001VERSION\001")
+	if !reflect.DeepEqual(l.Args, []string{VERSION, "me", "\001VERSION\001"}) {
+		t.Errorf("CTCP args incorrect: %q", l.Args)
+	}
+
+	// Lines built from scratch should format correctly too.
+	l = &Line{Cmd: PRIVMSG, Args: []string{"#chan", "hi"},
+		Tags: map[string]string{"+draft/reply": "abc 123"}}
+	if s := l.String(); s != "@+draft/reply=abc\\s123 PRIVMSG #chan :hi" {
+		t.Errorf("Built line formatted incorrectly: %q", s)
+	}
+}
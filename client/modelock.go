@@ -0,0 +1,83 @@
+package client
+
+// This file contains the code that works out the MODE commands needed to
+// bring a channel's modes, privileges and lists to a desired state.
+
+import (
+	"sort"
+
+	"github.com/fluffle/goirc/state"
+)
+
+// trackerModes describes the modes in the diffs the state package returns,
+// which only carry a parameter when the tracker expects one.
+var trackerModes = &chanModes{c: "kl", prefixModes: "qaohv"}
+
+// A ModeLock is the state EnforceModes brings a channel to. Anything it
+// leaves nil is left alone.
+type ModeLock struct {
+	// The channel's modes, including its key and limit.
+	Modes *state.ChanMode
+	// The privileges nicks should have, keyed by nick. Nicks that aren't
+	// on the channel are ignored, as are nicks on it that aren't here.
+	Privs map[string]*state.ChanPrivs
+	// The entries list modes should have, keyed by mode, e.g. 'b' for
+	// bans. The state tracker doesn't keep track of list modes, so the
+	// entries they currently have must be in CurrentLists, e.g. from the
+	// replies to MODE #channel +b; entries aren't removed otherwise.
+	Lists, CurrentLists map[byte][]string
+}
+
+// ModeChanges returns the mode changes needed to bring a channel from the
+// state the state tracker has for it to the state in lock. It returns nil
+// if state tracking is disabled or we aren't on the channel.
+func (conn *Conn) ModeChanges(channel string, lock *ModeLock) []ModeChange {
+	if conn.st == nil {
+		return nil
+	}
+	ch := conn.st.GetChannel(channel)
+	if ch == nil {
+		return nil
+	}
+	var changes []ModeChange
+	if lock.Modes != nil {
+		modes, args := ch.Modes.Diff(lock.Modes)
+		changes = append(changes, parseModeChanges(trackerModes, modes, args)...)
+	}
+	nicks := make([]string, 0, len(lock.Privs))
+	for nick := range lock.Privs {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	for _, nick := range nicks {
+		if cp, ok := ch.Nicks[nick]; ok && lock.Privs[nick] != nil {
+			modes, args := cp.Diff(lock.Privs[nick], nick)
+			changes = append(changes, parseModeChanges(trackerModes, modes, args)...)
+		}
+	}
+	lists := make([]string, 0, len(lock.Lists))
+	for m := range lock.Lists {
+		lists = append(lists, string(m))
+	}
+	sort.Strings(lists)
+	for _, l := range lists {
+		m := l[0]
+		modes, args := state.ListDiff(m, lock.CurrentLists[m], lock.Lists[m])
+		changes = append(changes, parseModeChanges(
+			&chanModes{a: l}, modes, args)...)
+	}
+	return changes
+}
+
+// EnforceModes sends the MODE commands needed to bring a channel to the
+// state in lock, for example to reapply a channel's modes after a netsplit:
+//
+//     conn.EnforceModes("#channel", &client.ModeLock{
+//         Modes: &state.ChanMode{NoExternalMsg: true, Key: "sekrit"},
+//         Privs: map[string]*state.ChanPrivs{"nick": {Op: true}},
+//     })
+//
+// It needs state tracking to be enabled, and does nothing otherwise.
+func (conn *Conn) EnforceModes(channel string, lock *ModeLock) {
+	conn.ModeBuilder(channel).Change(conn.ModeChanges(channel, lock)...).Send()
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluffle/goirc/state"
+)
+
+func TestModeChanges(t *testing.T) {
+	c, s := setUpTracking(t)
+	defer s.tearDown()
+
+	c.st.ChannelModes("#test1", "+mkv", "old", "user1")
+	lock := &ModeLock{
+		Modes: &state.ChanMode{NoExternalMsg: true, Key: "new", Limit: 10},
+		Privs: map[string]*state.ChanPrivs{
+			"user1":   {Op: true},
+			"user2":   {},
+			"missing": {Op: true},
+		},
+		Lists:        map[byte][]string{'b': {"*!*@keep", "*!*@add"}},
+		CurrentLists: map[byte][]string{'b': {"*!*@keep", "*!*@remove"}},
+	}
+	changes := c.ModeChanges("#test1", lock)
+	expected := []ModeChange{
+		{false, 'm', ""}, {false, 'k', ""},
+		{true, 'n', ""}, {true, 'k', "new"}, {true, 'l', "10"},
+		{false, 'v', "user1"}, {true, 'o', "user1"},
+		{false, 'o', "user2"},
+		{false, 'b', "*!*@remove"}, {true, 'b', "*!*@add"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("ModeChanges incorrect:\n%v\nexpected:\n%v", changes, expected)
+	}
+
+	c.isupport.parse([]string{"MODES=12"})
+	c.EnforceModes("#test1", lock)
+	s.nc.Expect("MODE #test1 -mk+nkl-v+o-ob+b old new 10 user1 user1 " +
+		"user2 *!*@remove *!*@add")
+
+	// Nothing is sent for unknown channels, or once the lock is in effect.
+	if changes := c.ModeChanges("#test2", lock); changes != nil {
+		t.Errorf("ModeChanges for unknown channel: %v", changes)
+	}
+	c.st.ChannelModes("#test1", "-mk+nklo-vo", "new", "10", "user1", "user1", "user2")
+	lock.Lists = nil
+	c.EnforceModes("#test1", lock)
+	s.nc.ExpectNothing()
+}
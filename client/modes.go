@@ -0,0 +1,232 @@
+package client
+
+// This file contains a builder for channel MODE commands, which sends
+// many mode changes in as few lines as the server allows.
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/fluffle/goirc/state"
+)
+
+const (
+	// The number of modes with parameters allowed in one MODE command
+	// if the server doesn't send the MODES ISUPPORT token.
+	defaultModes = 3
+)
+
+// A ModeBuilder collects changes to a channel's modes, then sends them in
+// as few MODE commands as the server's MODES limit and the maximum line
+// length allow. For example:
+//
+//     conn.ModeBuilder("#channel").Op("nick1", "nick2").Unset('m').Send()
+//
+// If state tracking is enabled, changes the tracker shows are already in
+// effect are left out.
+type ModeBuilder struct {
+	conn    *Conn
+	channel string
+	changes []ModeChange
+}
+
+// ModeBuilder returns a new ModeBuilder for a channel.
+func (conn *Conn) ModeBuilder(channel string) *ModeBuilder {
+	return &ModeBuilder{conn: conn, channel: channel}
+}
+
+// Change adds mode changes, e.g. those from a ModeEvent.
+func (mb *ModeBuilder) Change(changes ...ModeChange) *ModeBuilder {
+	mb.changes = append(mb.changes, changes...)
+	return mb
+}
+
+func (mb *ModeBuilder) each(set bool, mode byte, args []string) *ModeBuilder {
+	for _, a := range args {
+		mb.changes = append(mb.changes, ModeChange{set, mode, a})
+	}
+	return mb
+}
+
+// Op gives the nicks channel operator status, +o.
+func (mb *ModeBuilder) Op(nicks ...string) *ModeBuilder { return mb.each(true, 'o', nicks) }
+
+// Deop takes channel operator status from the nicks, -o.
+func (mb *ModeBuilder) Deop(nicks ...string) *ModeBuilder { return mb.each(false, 'o', nicks) }
+
+// HalfOp gives the nicks half-operator status, +h.
+func (mb *ModeBuilder) HalfOp(nicks ...string) *ModeBuilder { return mb.each(true, 'h', nicks) }
+
+// DeHalfOp takes half-operator status from the nicks, -h.
+func (mb *ModeBuilder) DeHalfOp(nicks ...string) *ModeBuilder { return mb.each(false, 'h', nicks) }
+
+// Voice gives the nicks voice, +v.
+func (mb *ModeBuilder) Voice(nicks ...string) *ModeBuilder { return mb.each(true, 'v', nicks) }
+
+// Devoice takes voice from the nicks, -v.
+func (mb *ModeBuilder) Devoice(nicks ...string) *ModeBuilder { return mb.each(false, 'v', nicks) }
+
+// Ban bans the masks, +b.
+func (mb *ModeBuilder) Ban(masks ...string) *ModeBuilder { return mb.each(true, 'b', masks) }
+
+// Unban removes bans on the masks, -b.
+func (mb *ModeBuilder) Unban(masks ...string) *ModeBuilder { return mb.each(false, 'b', masks) }
+
+// SetKey sets the channel key, +k.
+func (mb *ModeBuilder) SetKey(key string) *ModeBuilder {
+	return mb.Change(ModeChange{true, 'k', key})
+}
+
+// UnsetKey removes the channel key, -k.
+func (mb *ModeBuilder) UnsetKey() *ModeBuilder {
+	return mb.Change(ModeChange{false, 'k', ""})
+}
+
+// SetLimit sets the channel's user limit, +l.
+func (mb *ModeBuilder) SetLimit(limit int) *ModeBuilder {
+	return mb.Change(ModeChange{true, 'l', strconv.Itoa(limit)})
+}
+
+// UnsetLimit removes the channel's user limit, -l.
+func (mb *ModeBuilder) UnsetLimit() *ModeBuilder {
+	return mb.Change(ModeChange{false, 'l', ""})
+}
+
+// Set sets modes that don't take a parameter, e.g. Set('m', 'n').
+func (mb *ModeBuilder) Set(modes ...byte) *ModeBuilder {
+	for _, m := range modes {
+		mb.changes = append(mb.changes, ModeChange{true, m, ""})
+	}
+	return mb
+}
+
+// Unset unsets modes that don't take a parameter when unset.
+func (mb *ModeBuilder) Unset(modes ...byte) *ModeBuilder {
+	for _, m := range modes {
+		mb.changes = append(mb.changes, ModeChange{false, m, ""})
+	}
+	return mb
+}
+
+// pending returns the changes that need making, without duplicates or
+// those the state tracker shows are already in effect. Parameters are
+// dropped from, or added to, changes as the server's CHANMODES require.
+func (mb *ModeBuilder) pending() []ModeChange {
+	cm := mb.conn.isupport.chanModes()
+	var ch *state.Channel
+	if mb.conn.st != nil {
+		ch = mb.conn.st.GetChannel(mb.channel)
+	}
+	seen := make(map[ModeChange]bool)
+	out := make([]ModeChange, 0, len(mb.changes))
+	for _, c := range mb.changes {
+		if !cm.takesArg(c.Mode, c.Set) {
+			c.Arg = ""
+		} else if c.Arg == "" {
+			// Some servers need the key to remove it, others accept any
+			// parameter.
+			c.Arg = "*"
+			if ch != nil && c.Mode == 'k' {
+				if ch.Modes.Key != "" {
+					c.Arg = ch.Modes.Key
+				}
+			}
+		}
+		if seen[c] || (ch != nil && inEffect(cm, ch, c)) {
+			continue
+		}
+		seen[c] = true
+		out = append(out, c)
+	}
+	return out
+}
+
+// inEffect returns true if the channel's state shows the change has
+// already been made. List modes like bans aren't tracked, so are never
+// in effect.
+func inEffect(cm *chanModes, ch *state.Channel, c ModeChange) bool {
+	if strings.IndexByte(cm.prefixModes, c.Mode) != -1 {
+		cp, ok := ch.Nicks[c.Arg]
+		if !ok {
+			return false
+		}
+		set, known := cp.Has(c.Mode)
+		return known && set == c.Set
+	}
+	if strings.IndexByte(cm.a, c.Mode) != -1 {
+		return false
+	}
+	set, arg, known := ch.Modes.Get(c.Mode)
+	if !known {
+		return false
+	}
+	if c.Set {
+		return set && (c.Arg == "" || c.Arg == arg)
+	}
+	return !set
+}
+
+// Lines returns the MODE commands that would be sent by Send.
+func (mb *ModeBuilder) Lines() []string {
+	max := defaultModes
+	if v, ok := mb.conn.isupport.get("MODES"); ok {
+		// MODES with no value means there is no limit.
+		if max, _ = strconv.Atoi(v); max <= 0 {
+			max = len(mb.changes)
+		}
+	}
+	prefix := MODE + " " + mb.channel + " "
+	// The server relays the line with our hostmask in front of it.
+	maxLen := mb.conn.lineLen() - mb.conn.prefixLen() - 2
+	var lines []string
+	var modes []byte
+	var args []string
+	var sign byte
+	length, nargs := len(prefix), 0
+	flush := func() {
+		if len(modes) > 0 {
+			lines = append(lines, prefix+strings.Join(
+				append([]string{string(modes)}, args...), " "))
+		}
+		modes, args, sign = nil, nil, 0
+		length, nargs = len(prefix), 0
+	}
+	for _, c := range mb.pending() {
+		s := byte('-')
+		if c.Set {
+			s = '+'
+		}
+		cost := 1
+		if s != sign {
+			cost++
+		}
+		if c.Arg != "" {
+			cost += len(c.Arg) + 1
+		}
+		if (c.Arg != "" && nargs == max) || length+cost > maxLen {
+			flush()
+			cost = 2
+			if c.Arg != "" {
+				cost += len(c.Arg) + 1
+			}
+		}
+		if s != sign {
+			modes, sign = append(modes, s), s
+		}
+		modes = append(modes, c.Mode)
+		if c.Arg != "" {
+			args = append(args, c.Arg)
+			nargs++
+		}
+		length += cost
+	}
+	flush()
+	return lines
+}
+
+// Send sends the mode changes to the server.
+func (mb *ModeBuilder) Send() {
+	for _, l := range mb.Lines() {
+		mb.conn.Raw(l)
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModeBuilder(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+
+	// With the default MODES=3, modes with parameters are sent three at
+	// a time, but modes without them don't count.
+	lines := c.ModeBuilder("#test1").Op("a", "b", "c", "d").Devoice("e").
+		Set('m').Unset('n', 'l').Lines()
+	expected := []string{
+		"MODE #test1 +ooo a b c",
+		"MODE #test1 +o-v+m-nl d e",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Lines incorrect:\n%q\nexpected:\n%q", lines, expected)
+	}
+
+	// Parameters are added and removed as CHANMODES requires, and
+	// duplicates are dropped.
+	c.isupport.parse([]string{"MODES=6"})
+	lines = c.ModeBuilder("#test1").SetKey("key").SetLimit(10).UnsetKey().
+		UnsetLimit().Ban("*!*@host").Unban("*!*@host").Ban("*!*@host").
+		Change(ModeChange{Set: true, Mode: 'm', Arg: "junk"}).Lines()
+	expected = []string{"MODE #test1 +kl-kl+b-b+m key 10 * *!*@host *!*@host"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Lines incorrect:\n%q\nexpected:\n%q", lines, expected)
+	}
+
+	// Lines are kept short enough to be relayed with our hostmask.
+	c.hostmask.set("test", "test", "somehost.com")
+	c.isupport.parse([]string{"MODES", "LINELEN=400"})
+	var masks []string
+	for i := 0; i < 40; i++ {
+		masks = append(masks, fmt.Sprintf("*!*@host%02d.example.com", i))
+	}
+	lines = c.ModeBuilder("#test1").Ban(masks...).Lines()
+	n := 0
+	for _, l := range lines {
+		if len(":test!test@somehost.com "+l+"\r\n") > 400 {
+			t.Errorf("Line too long (%d): %s", len(l), l)
+		}
+		n += strings.Count(strings.Fields(l)[2], "b")
+	}
+	if len(lines) != 3 || n != 40 {
+		t.Errorf("Bans split incorrectly: %q", lines)
+	}
+
+	// Nothing to do sends nothing.
+	c.ModeBuilder("#test1").Send()
+	c.ModeBuilder("#test1").Op("a").Unset('m').Send()
+	s.nc.Expect("MODE #test1 +o-m a")
+}
+
+func TestModeBuilderState(t *testing.T) {
+	c, s := setUpTracking(t)
+	defer s.tearDown()
+
+	// user2 is already an op.
+	c.st.ChannelModes("#test1", "+nlko", "10", "key", "user1")
+
+	// Changes already in effect are skipped.
+	lines := c.ModeBuilder("#test1").Op("user1", "user2").Deop("user2").
+		Set('n', 'm').Unset('t').SetLimit(10).SetKey("other").UnsetKey().
+		Ban("*!*@host").Lines()
+	expected := []string{
+		"MODE #test1 -o+mk-k user2 other key",
+		"MODE #test1 +b *!*@host",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Lines incorrect:\n%q\nexpected:\n%q", lines, expected)
+	}
+}
@@ -0,0 +1,234 @@
+package client
+
+// This file contains the code that sends and receives messages with more
+// than one line in them, using the IRCv3 multiline extension if possible.
+// http://ircv3.net/specs/extensions/multiline
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+const (
+	// The batch type and capability of the multiline extension.
+	MULTILINE = "draft/multiline"
+	// The tag marking a line in a multiline batch that continues the
+	// previous one, rather than starting a new line.
+	multilineConcat = "draft/multiline-concat"
+)
+
+// multilineCaps are the capabilities needed to send and receive multiline
+// messages. They are requested whether or not state tracking is enabled.
+var multilineCaps = []string{
+	"batch",
+	MULTILINE,
+}
+
+// batchRef is used to create unique references for the batches we send.
+var batchRef uint64
+
+// splitLines splits msg into lines at "\r\n", "\n" or "\r", ignoring a
+// single trailing line break.
+func splitLines(msg string) []string {
+	msg = strings.Replace(msg, "\r\n", "\n", -1)
+	msg = strings.Replace(msg, "\r", "\n", -1)
+	msg = strings.TrimSuffix(msg, "\n")
+	return strings.Split(msg, "\n")
+}
+
+// multilineLimits returns the maximum number of bytes and lines the server
+// allows in a multiline batch, from the value of the draft/multiline
+// capability, and false if we can't send multiline batches. The limits are
+// zero if the server doesn't set them.
+func (conn *Conn) multilineLimits() (maxBytes, maxLines int, ok bool) {
+	if !conn.HasCap("batch") || !conn.HasCap(MULTILINE) {
+		return 0, 0, false
+	}
+	conn.caps.mu.Lock()
+	v := conn.caps.available[MULTILINE]
+	conn.caps.mu.Unlock()
+	for _, kv := range strings.Split(v, ",") {
+		switch kv := strings.SplitN(kv, "=", 2); kv[0] {
+		case "max-bytes":
+			if len(kv) == 2 {
+				maxBytes, _ = strconv.Atoi(kv[1])
+			}
+		case "max-lines":
+			if len(kv) == 2 {
+				maxLines, _ = strconv.Atoi(kv[1])
+			}
+		}
+	}
+	return maxBytes, maxLines, true
+}
+
+// message sends msg to t with cmd, which is PRIVMSG or NOTICE. Messages with
+// more than one line are sent in multiline batches if the server supports
+// them, and as one message per line otherwise, skipping empty lines.
+func (conn *Conn) message(cmd, t, msg string) {
+	lines := splitLines(msg)
+	if len(lines) > 1 {
+		if maxBytes, maxLines, ok := conn.multilineLimits(); ok {
+			conn.multiline(cmd, t, lines, maxBytes, maxLines)
+			return
+		}
+	}
+	for _, l := range lines {
+		if l == "" && len(lines) > 1 {
+			continue
+		}
+		for _, s := range conn.split(l, conn.payloadLen(cmd, t, 0)) {
+			conn.Raw(cmd + " " + t + " :" + s)
+		}
+	}
+}
+
+// multiline sends lines to t in as few multiline batches as the server's
+// limits allow. Lines too long to send in one message are split into parts
+// that the server joins back together. A line is only split between two
+// batches if it is too long to fit in one, in which case it is received
+// as more than one line, since a batch can't start by continuing a line.
+func (conn *Conn) multiline(cmd, t string, lines []string, maxBytes, maxLines int) {
+	max := conn.payloadLen(cmd, t, 0)
+	ref, n, size := "", 0, 0
+	// full returns true if the batch can't fit more parts of lines.
+	full := func(parts, bytes int) bool {
+		return (maxLines > 0 && n+parts > maxLines) ||
+			(maxBytes > 0 && size+bytes > maxBytes)
+	}
+	end := func() {
+		if ref != "" {
+			conn.Raw(BATCH + " -" + ref)
+		}
+		ref, n, size = "", 0, 0
+	}
+	for _, l := range lines {
+		var parts []string
+		for len(l) > max {
+			idx := indexSplit(l, max)
+			parts = append(parts, l[:idx])
+			l = l[idx:]
+		}
+		parts = append(parts, l)
+		// Line breaks count towards max-bytes, but the first line of a
+		// batch doesn't have one.
+		total := len(strings.Join(parts, ""))
+		if n > 0 {
+			total++
+		}
+		if ref != "" && full(len(parts), total) {
+			end()
+		}
+		for i, p := range parts {
+			concat := i > 0
+			cost := len(p)
+			if n > 0 && !concat {
+				cost++
+			}
+			if ref != "" && full(1, cost) {
+				end()
+				cost = len(p)
+			}
+			if ref == "" {
+				concat = false
+				ref = "ml" + strconv.FormatUint(atomic.AddUint64(&batchRef, 1), 10)
+				conn.Raw(BATCH + " +" + ref + " " + MULTILINE + " " + t)
+			}
+			tags := "@batch=" + ref
+			if concat {
+				tags += ";" + multilineConcat
+			}
+			conn.Raw(tags + " " + cmd + " " + t + " :" + p)
+			n, size = n+1, size+cost
+		}
+	}
+	end()
+}
+
+// Handle the end of a multiline batch, dispatching the lines in it as one
+// PRIVMSG or NOTICE whose text has the lines separated by "\n".
+func (conn *Conn) h_multilineBatch(b *Batch) {
+	if line := multilineMessage(b); line != nil {
+		conn.dispatch(line)
+	}
+}
+
+// multilineMessage joins the lines in a multiline batch into one line,
+// returning nil if the batch is empty.
+func multilineMessage(b *Batch) *Line {
+	if len(b.Lines) == 0 {
+		return nil
+	}
+	if len(b.Lines[0].Args) == 0 {
+		logging.Warn("irc.BATCH(): multiline batch %s has no target", b.Ref)
+		return nil
+	}
+	line := b.Lines[0].Copy()
+	text := ""
+	for i, l := range b.Lines {
+		if _, concat := l.Tags[multilineConcat]; i > 0 && !concat {
+			text += "\n"
+		}
+		text += l.Text()
+	}
+	line.Args[len(line.Args)-1] = text
+	// The batch's tags, e.g. its msgid and time, apply to the message.
+	for k, v := range b.Tags {
+		line.Tags[k] = v
+	}
+	delete(line.Tags, multilineConcat)
+	delete(line.Tags, "batch")
+	return line
+}
+
+// sentBatches holds the multiline batches we have started sending but not
+// yet ended, so that they are added to history as one message.
+type sentBatches struct {
+	mu   sync.Mutex
+	open map[string]*Batch
+}
+
+func newSentBatches() *sentBatches {
+	return &sentBatches{open: make(map[string]*Batch)}
+}
+
+func (sb *sentBatches) wipe() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.open = make(map[string]*Batch)
+}
+
+// collect adds a line we sent to the multiline batch it belongs to,
+// returning nil, or the whole message if the line ends the batch. Other
+// lines are returned as they are.
+func (sb *sentBatches) collect(line *Line) *Line {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if line.Cmd == BATCH {
+		if len(line.Args) == 0 || len(line.Args[0]) < 2 {
+			return nil
+		}
+		ref := line.Args[0][1:]
+		if line.Args[0][0] == '+' {
+			if len(line.Args) > 1 && strings.ToLower(line.Args[1]) == MULTILINE {
+				sb.open[ref] = &Batch{Ref: ref, Type: MULTILINE, Tags: line.Tags}
+			}
+			return nil
+		}
+		b, ok := sb.open[ref]
+		delete(sb.open, ref)
+		if !ok {
+			return nil
+		}
+		return multilineMessage(b)
+	}
+	if b, ok := sb.open[line.Tags["batch"]]; ok {
+		b.Lines = append(b.Lines, line)
+		return nil
+	}
+	return line
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{"", []string{""}},
+		{"foo", []string{"foo"}},
+		{"foo\n", []string{"foo"}},
+		{"foo\nbar", []string{"foo", "bar"}},
+		{"foo\r\n\r\nbar\rbaz\n\n", []string{"foo", "", "bar", "baz", ""}},
+	}
+	for i, test := range tests {
+		if out := splitLines(test.in); !reflect.DeepEqual(out, test.out) {
+			t.Errorf("test %d: expected %q, got %q", i, test.out, out)
+		}
+	}
+}
+
+func TestMultilineFallback(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	// Without draft/multiline, each line is sent separately, and empty
+	// lines are skipped.
+	c.Privmsg("#foo", "line1\n\nline2\r\n")
+	s.nc.Expect("PRIVMSG #foo :line1")
+	s.nc.Expect("PRIVMSG #foo :line2")
+	c.Notice("#foo", "line1\nline2")
+	s.nc.Expect("NOTICE #foo :line1")
+	s.nc.Expect("NOTICE #foo :line2")
+
+	// Messages with one line are sent as before.
+	c.Privmsg("#foo", "")
+	s.nc.Expect("PRIVMSG #foo :")
+}
+
+func TestMultilineSend(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.caps.enabled["batch"] = true
+	c.caps.enabled[MULTILINE] = true
+	c.caps.available[MULTILINE] = "max-bytes=20,max-lines=3"
+	batchRef = 0
+
+	c.Privmsg("#foo", "line1\n\nline2\nline3\nline4")
+	s.nc.Expect("BATCH +ml1 draft/multiline #foo")
+	s.nc.Expect("@batch=ml1 PRIVMSG #foo :line1")
+	s.nc.Expect("@batch=ml1 PRIVMSG #foo :")
+	s.nc.Expect("@batch=ml1 PRIVMSG #foo :line2")
+	s.nc.Expect("BATCH -ml1")
+	s.nc.Expect("BATCH +ml2 draft/multiline #foo")
+	s.nc.Expect("@batch=ml2 PRIVMSG #foo :line3")
+	s.nc.Expect("@batch=ml2 PRIVMSG #foo :line4")
+	s.nc.Expect("BATCH -ml2")
+
+	// Lines are split into parts that are joined back together, and
+	// max-bytes counts the line breaks between them.
+	c.caps.available[MULTILINE] = "max-bytes=25"
+	c.cfg.SplitLen = 13
+	c.Notice("#foo", "0123456789 abcdefghij\n0123456")
+	s.nc.Expect("BATCH +ml3 draft/multiline #foo")
+	s.nc.Expect("@batch=ml3 NOTICE #foo :0123456789 ")
+	s.nc.Expect("@batch=ml3;draft/multiline-concat NOTICE #foo :abcdefghij")
+	s.nc.Expect("BATCH -ml3")
+	s.nc.Expect("BATCH +ml4 draft/multiline #foo")
+	s.nc.Expect("@batch=ml4 NOTICE #foo :0123456")
+	s.nc.Expect("BATCH -ml4")
+
+	// Split lines start a new batch rather than be split between two,
+	// unless they are too long to fit in one.
+	c.caps.available[MULTILINE] = "max-lines=2"
+	c.Privmsg("#foo", "a\n0123456789 abcdefghij\n0123456789 abcdefghij 0123456789 abcdefghij")
+	s.nc.Expect("BATCH +ml5 draft/multiline #foo")
+	s.nc.Expect("@batch=ml5 PRIVMSG #foo :a")
+	s.nc.Expect("BATCH -ml5")
+	s.nc.Expect("BATCH +ml6 draft/multiline #foo")
+	s.nc.Expect("@batch=ml6 PRIVMSG #foo :0123456789 ")
+	s.nc.Expect("@batch=ml6;draft/multiline-concat PRIVMSG #foo :abcdefghij")
+	s.nc.Expect("BATCH -ml6")
+	s.nc.Expect("BATCH +ml7 draft/multiline #foo")
+	s.nc.Expect("@batch=ml7 PRIVMSG #foo :0123456789 ")
+	s.nc.Expect("@batch=ml7;draft/multiline-concat PRIVMSG #foo :abcdefghij ")
+	s.nc.Expect("BATCH -ml7")
+	s.nc.Expect("BATCH +ml8 draft/multiline #foo")
+	s.nc.Expect("@batch=ml8 PRIVMSG #foo :0123456789 ")
+	s.nc.Expect("@batch=ml8;draft/multiline-concat PRIVMSG #foo :abcdefghij")
+	s.nc.Expect("BATCH -ml8")
+}
+
+func TestMultilineCaps(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+	batchRef = 0
+
+	// Multiline messages are used without state tracking too.
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	c.h_CAP(ParseLine(":irc.server.org CAP * LS :batch draft/multiline=max-lines=10"))
+	s.nc.Expect("CAP REQ :batch draft/multiline")
+	c.h_CAP(ParseLine(":irc.server.org CAP test ACK :batch draft/multiline"))
+	s.nc.Expect("CAP END")
+	c.Privmsg("#foo", "line1\nline2")
+	s.nc.Expect("BATCH +ml1 draft/multiline #foo")
+	s.nc.Expect("@batch=ml1 PRIVMSG #foo :line1")
+	s.nc.Expect("@batch=ml1 PRIVMSG #foo :line2")
+	s.nc.Expect("BATCH -ml1")
+}
+
+func TestMultilineReceive(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+
+	var got []*Line
+	c.HandleFunc(PRIVMSG, func(_ *Conn, line *Line) { got = append(got, line) })
+
+	c.dispatch(ParseLine("@msgid=abc :user1!ident1@host1.com BATCH +x draft/multiline #test1"))
+	c.dispatch(ParseLine("@batch=x :user1!ident1@host1.com PRIVMSG #test1 :hello"))
+	c.dispatch(ParseLine("@batch=x :user1!ident1@host1.com PRIVMSG #test1 :wor"))
+	c.dispatch(ParseLine("@batch=x;draft/multiline-concat :user1!ident1@host1.com PRIVMSG #test1 :ld"))
+	c.dispatch(ParseLine("@batch=x :user1!ident1@host1.com PRIVMSG #test1 :"))
+	c.dispatch(ParseLine("@batch=x :user1!ident1@host1.com PRIVMSG #test1 :bye"))
+	if len(got) != 0 {
+		t.Errorf("Lines in multiline batch dispatched before it ended.")
+	}
+	c.dispatch(ParseLine(":user1!ident1@host1.com BATCH -x"))
+	if len(got) != 1 {
+		t.Fatalf("Multiline batch dispatched %d times.", len(got))
+	}
+	l := got[0]
+	if l.Text() != "hello\nworld\n\nbye" || l.Target() != "#test1" ||
+		l.Nick != "user1" || l.Tags["msgid"] != "abc" || l.Tags["batch"] != "" {
+		t.Errorf("Multiline batch reassembled incorrectly: %#v", l)
+	}
+
+	// Batches whose lines have no target are skipped.
+	c.dispatch(ParseLine(":user1!ident1@host1.com BATCH +y draft/multiline #test1"))
+	c.dispatch(ParseLine("@batch=y :user1!ident1@host1.com PRIVMSG"))
+	c.dispatch(ParseLine(":user1!ident1@host1.com BATCH -y"))
+	if len(got) != 1 {
+		t.Errorf("Multiline batch without a target dispatched.")
+	}
+}
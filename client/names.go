@@ -0,0 +1,139 @@
+package client
+
+// This file contains the code that parses NAMES replies, and collects them
+// into a single event once the list of a channel's members is complete.
+
+import (
+	"strings"
+	"sync"
+)
+
+// NAMESCOMPLETE is dispatched on receipt of 366 RPL_ENDOFNAMES, after all
+// the 353 RPL_NAMREPLY lines for a channel. Args[0] is the channel, and the
+// remaining Args are the members exactly as the server sent them, e.g.
+// "@+nick" or, with the userhost-in-names capability, "@nick!ident@host".
+// Use OnNames to receive these as parsed NamesEvents.
+const NAMESCOMPLETE = "NAMESCOMPLETE"
+
+// NamesMember is a single member of a channel from a NAMES reply.
+type NamesMember struct {
+	// Ident and Host are only set if the server supports the
+	// userhost-in-names capability.
+	Nick, Ident, Host string
+	// Prefixes contains the symbols shown before the nick, e.g. "@+", and
+	// Modes the corresponding channel modes, e.g. "ov". Unless the server
+	// supports the multi-prefix capability, only the highest is shown.
+	Prefixes, Modes string
+}
+
+// NamesEvent is the complete list of a channel's members.
+type NamesEvent struct {
+	Event
+	Channel string
+	Members []NamesMember
+}
+
+// names collects 353 replies until the 366 for their channel arrives.
+type names struct {
+	mu      sync.Mutex
+	pending map[string][]string
+}
+
+func newNames() *names {
+	return &names{pending: make(map[string][]string)}
+}
+
+func (n *names) wipe() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = make(map[string][]string)
+}
+
+// parseNamesEntry splits a member of a 353 reply into its prefix symbols,
+// nick, and if present, ident and host.
+func parseNamesEntry(cm *chanModes, entry string) (prefix, nick, ident, host string) {
+	i := 0
+	for i < len(entry) && strings.IndexByte(cm.prefixSymbols, entry[i]) != -1 {
+		i++
+	}
+	prefix, nick = entry[:i], entry[i:]
+	if idx := strings.Index(nick, "!"); idx != -1 {
+		nick, ident = nick[:idx], nick[idx+1:]
+		if idx = strings.Index(ident, "@"); idx != -1 {
+			ident, host = ident[:idx], ident[idx+1:]
+		}
+	}
+	return
+}
+
+// prefixModesFor returns the channel modes that correspond to prefix symbols.
+func (cm *chanModes) prefixModesFor(prefix string) string {
+	modes := make([]byte, 0, len(prefix))
+	for i := 0; i < len(prefix); i++ {
+		if idx := strings.IndexByte(cm.prefixSymbols, prefix[i]); idx != -1 &&
+			idx < len(cm.prefixModes) {
+			modes = append(modes, cm.prefixModes[idx])
+		}
+	}
+	return string(modes)
+}
+
+// Handle 353 names reply, collecting members until 366
+func (conn *Conn) h_names353(line *Line) {
+	if !line.argslen(3) {
+		return
+	}
+	conn.names.mu.Lock()
+	defer conn.names.mu.Unlock()
+	c := line.Args[2]
+	conn.names.pending[c] = append(conn.names.pending[c],
+		strings.Fields(line.Args[3])...)
+}
+
+// Handle 366 end of names, dispatching the collected members
+func (conn *Conn) h_names366(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	c := line.Args[1]
+	conn.names.mu.Lock()
+	members := conn.names.pending[c]
+	delete(conn.names.pending, c)
+	conn.names.mu.Unlock()
+	conn.dispatch(&Line{
+		Cmd:  NAMESCOMPLETE,
+		Args: append([]string{c}, members...),
+		Tags: line.Tags,
+		Time: line.Time,
+	})
+}
+
+func (conn *Conn) newNamesEvent(line *Line) (*NamesEvent, bool) {
+	if !eventArgs(line, 1) {
+		return nil, false
+	}
+	cm := conn.isupport.chanModes()
+	ev := &NamesEvent{
+		Event:   newEvent(line),
+		Channel: line.Args[0],
+		Members: make([]NamesMember, 0, len(line.Args)-1),
+	}
+	for _, entry := range line.Args[1:] {
+		m := NamesMember{}
+		m.Prefixes, m.Nick, m.Ident, m.Host = parseNamesEntry(cm, entry)
+		m.Modes = cm.prefixModesFor(m.Prefixes)
+		ev.Members = append(ev.Members, m)
+	}
+	return ev, true
+}
+
+// OnNames registers a foreground handler for the complete list of a
+// channel's members, sent by the server when we join a channel or send
+// a NAMES command.
+func (conn *Conn) OnNames(f func(*Conn, *NamesEvent), filters ...Filter) Remover {
+	return conn.HandleFunc(NAMESCOMPLETE, func(conn *Conn, line *Line) {
+		if ev, ok := conn.newNamesEvent(line); ok {
+			f(conn, ev)
+		}
+	}, filters...)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNamesEntry(t *testing.T) {
+	cm := newISupport().chanModes()
+	tests := []struct {
+		entry, prefix, nick, ident, host, modes string
+	}{
+		{"nick", "", "nick", "", "", ""},
+		{"@nick", "@", "nick", "", "", "o"},
+		{"~@+nick", "~@+", "nick", "", "", "qov"},
+		{"nick!ident@host", "", "nick", "ident", "host", ""},
+		{"%+nick!ident@some.host", "%+", "nick", "ident", "some.host", "hv"},
+		{"@", "@", "", "", "", "o"},
+	}
+	for _, test := range tests {
+		prefix, nick, ident, host := parseNamesEntry(cm, test.entry)
+		if prefix != test.prefix || nick != test.nick ||
+			ident != test.ident || host != test.host {
+			t.Errorf("parseNamesEntry(%q) = %q, %q, %q, %q", test.entry,
+				prefix, nick, ident, host)
+		}
+		if modes := cm.prefixModesFor(prefix); modes != test.modes {
+			t.Errorf("prefixModesFor(%q) = %q, expected %q", prefix,
+				modes, test.modes)
+		}
+	}
+}
+
+func TestNamesEvent(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+
+	var got *NamesEvent
+	c.OnNames(func(_ *Conn, ev *NamesEvent) { got = ev })
+
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1 :@+op!o@host voice "))
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test2 :other"))
+	// A reply without any nicks is ignored.
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1"))
+	if got != nil {
+		t.Errorf("NAMES event dispatched before 366.")
+	}
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1 :+user"))
+	c.dispatch(ParseLine(":irc.server.org 366 test #test1 :End of /NAMES list."))
+	if got == nil {
+		t.Fatalf("NAMES event not dispatched on 366.")
+	}
+	expected := []NamesMember{
+		{Nick: "op", Ident: "o", Host: "host", Prefixes: "@+", Modes: "ov"},
+		{Nick: "voice"},
+		{Nick: "user", Prefixes: "+", Modes: "v"},
+	}
+	if got.Channel != "#test1" || !reflect.DeepEqual(got.Members, expected) {
+		t.Errorf("NAMES event incorrect: %#v", got)
+	}
+
+	// The other channel's members are still pending, and a channel's
+	// members are forgotten once its NAMES event has been dispatched.
+	if m := c.names.pending["#test2"]; len(m) != 1 || m[0] != "other" {
+		t.Errorf("Pending members for #test2 incorrect: %v", m)
+	}
+	if _, ok := c.names.pending["#test1"]; ok {
+		t.Errorf("Pending members for #test1 not removed on 366.")
+	}
+}
@@ -0,0 +1,330 @@
+package client
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueuePolicy determines what happens when a line is queued for delivery
+// but the queue it is destined for is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the producer wait until there is room in the queue.
+	// For background handlers, this means the event loop stalls until they
+	// catch up, which provides backpressure at the cost of latency.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest discards the item at the head of the queue to make
+	// room for the new one.
+	QueueDropOldest
+	// QueueDropNewest discards the item that is being queued.
+	QueueDropNewest
+)
+
+func (qp QueuePolicy) String() string {
+	switch qp {
+	case QueueBlock:
+		return "block"
+	case QueueDropOldest:
+		return "drop-oldest"
+	case QueueDropNewest:
+		return "drop-newest"
+	}
+	return "unknown"
+}
+
+const (
+	defaultWorkers  = 32
+	defaultBGQueue  = 256
+	workerIdleAfter = 30 * time.Second
+)
+
+// A KeyFunc extracts an ordering key from a Line. Background handlers for
+// lines that share a non-empty key are run one line at a time, in the order
+// the lines were received. See Config.BGOrderKey.
+type KeyFunc func(*Line) string
+
+// KeyByTarget orders lines by their Target, so messages to a channel are
+// handled in order per channel and private messages in order per nick.
+func KeyByTarget(line *Line) string {
+	return strings.ToLower(line.Target())
+}
+
+// KeyByNick orders lines by the nick that sent them.
+func KeyByNick(line *Line) string {
+	return strings.ToLower(line.Nick)
+}
+
+// DispatchStats is a snapshot of the state of the handler dispatch pool.
+type DispatchStats struct {
+	// Workers is the number of worker goroutines currently running;
+	// MaxWorkers is the limit on that number.
+	Workers, MaxWorkers int
+	// QueueDepth is the number of lines waiting for background handlers,
+	// QueueCap the number that may wait, and QueuePeak the greatest depth
+	// any single queue has reached. Lines ordered by key wait in per-key
+	// lanes, which share another QueueCap between them once they are in
+	// use, and are counted in all three.
+	QueueDepth, QueueCap, QueuePeak int
+	// Queued counts lines offered to the background queue, Dropped those
+	// discarded by the queue's overflow policy.
+	Queued, Dropped uint64
+	// Inline counts handlers run by the dispatching goroutine because
+	// every worker was busy.
+	Inline uint64
+}
+
+// A pool bounds the number of goroutines used to run handlers. Workers are
+// started on demand and exit again after being idle for a while, so a quiet
+// connection does not keep them around.
+//
+// Foreground work is only ever handed to an idle worker. If there are none
+// and no more can be started, the caller runs the work itself; this means
+// handlers that dispatch further events (like h_001) can never deadlock the
+// pool. Background work goes through a bounded queue governed by a policy.
+//
+// Background work that must be ordered is hashed by key into one of a set
+// of lanes. A lane with work waiting is handed to a worker, which drains it
+// serially, so work for one key is never reordered while different keys
+// proceed in parallel.
+type pool struct {
+	max    int32
+	policy QueuePolicy
+	work   chan func() // unbuffered hand-off to idle workers
+	queue  chan func() // bounded queue of background work
+	ready  chan *lane  // lanes with work waiting for a worker
+
+	// Lanes for ordered background work, allocated on first use.
+	lanesOnce sync.Once
+	lanes     atomic.Value // []*lane
+
+	// Accessed atomically.
+	workers, peak           int32
+	queued, dropped, inline uint64
+}
+
+func newPool(workers, qlen int, policy QueuePolicy) *pool {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if qlen <= 0 {
+		qlen = defaultBGQueue
+	}
+	return &pool{
+		max:    int32(workers),
+		policy: policy,
+		work:   make(chan func()),
+		queue:  make(chan func(), qlen),
+		// Each lane is only ever waiting here once.
+		ready: make(chan *lane, workers),
+	}
+}
+
+// run executes f on an idle worker if possible, in a new worker if the pool
+// has not reached its limit, and in the calling goroutine otherwise.
+func (p *pool) run(f func()) {
+	select {
+	case p.work <- f:
+		return
+	default:
+	}
+	if p.spawn(f) {
+		return
+	}
+	atomic.AddUint64(&p.inline, 1)
+	f()
+}
+
+// enqueue adds f to the background queue, applying the pool's policy
+// if the queue is full.
+func (p *pool) enqueue(f func()) {
+	// Make sure there is someone to consume the queue before we might block.
+	p.spawn(nil)
+	p.push(p.queue, f)
+	// The last worker may have retired between the spawn above and our
+	// send; it checks the queue after decrementing the count, so if we
+	// see no workers here then nobody has seen our work.
+	if atomic.LoadInt32(&p.workers) == 0 {
+		p.spawn(nil)
+	}
+}
+
+// enqueueKeyed adds f to the lane for key. Work in a lane is run in order.
+func (p *pool) enqueueKeyed(key string, f func()) {
+	lanes := p.allLanes()
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	l := lanes[h.Sum32()%uint32(len(lanes))]
+	// As with enqueue, schedule the lane before we might block on it
+	// and check again afterwards in case it was drained in between.
+	p.schedule(l)
+	p.push(l.ch, f)
+	p.schedule(l)
+}
+
+// schedule hands l to a worker, unless one is already draining it.
+func (p *pool) schedule(l *lane) {
+	if atomic.LoadInt32(&l.running) != 0 ||
+		!atomic.CompareAndSwapInt32(&l.running, 0, 1) {
+		return
+	}
+	p.ready <- l
+	p.spawn(nil)
+	if atomic.LoadInt32(&p.workers) == 0 {
+		p.spawn(nil)
+	}
+}
+
+// push sends f to q according to the pool's policy, updating metrics.
+func (p *pool) push(q chan func(), f func()) {
+	atomic.AddUint64(&p.queued, 1)
+	switch p.policy {
+	case QueueDropNewest:
+		select {
+		case q <- f:
+		default:
+			atomic.AddUint64(&p.dropped, 1)
+			return
+		}
+	case QueueDropOldest:
+		for queued := false; !queued; {
+			select {
+			case q <- f:
+				queued = true
+			default:
+				select {
+				case <-q:
+					atomic.AddUint64(&p.dropped, 1)
+				default:
+				}
+			}
+		}
+	default:
+		q <- f
+	}
+	for d := int32(len(q)); ; {
+		peak := atomic.LoadInt32(&p.peak)
+		if d <= peak || atomic.CompareAndSwapInt32(&p.peak, peak, d) {
+			break
+		}
+	}
+}
+
+// reserve increments the worker count if that would not exceed the limit.
+func (p *pool) reserve() bool {
+	for {
+		n := atomic.LoadInt32(&p.workers)
+		if n >= p.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.workers, n, n+1) {
+			return true
+		}
+	}
+}
+
+// spawn starts a new worker, optionally with some initial work.
+func (p *pool) spawn(f func()) bool {
+	if !p.reserve() {
+		return false
+	}
+	go p.worker(f)
+	return true
+}
+
+func (p *pool) worker(f func()) {
+	idle := time.NewTimer(workerIdleAfter)
+	defer idle.Stop()
+	for {
+		if f != nil {
+			f()
+			f = nil
+		}
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(workerIdleAfter)
+		select {
+		case f = <-p.work:
+		case f = <-p.queue:
+		case l := <-p.ready:
+			l.drain()
+		case <-idle.C:
+			atomic.AddInt32(&p.workers, -1)
+			// Work may have been queued while we were retiring.
+			if (len(p.queue) == 0 && len(p.ready) == 0) || !p.reserve() {
+				return
+			}
+		}
+	}
+}
+
+// allLanes returns the pool's lanes, creating them if necessary. There is
+// one per worker, and they share the background queue's length between them.
+func (p *pool) allLanes() []*lane {
+	p.lanesOnce.Do(func() {
+		lanes := make([]*lane, p.max)
+		qlen := cap(p.queue) / len(lanes)
+		if qlen < 1 {
+			qlen = 1
+		}
+		for i := range lanes {
+			lanes[i] = &lane{ch: make(chan func(), qlen)}
+		}
+		p.lanes.Store(lanes)
+	})
+	return p.lanes.Load().([]*lane)
+}
+
+// A lane is a queue of background work that is drained serially.
+type lane struct {
+	ch      chan func()
+	running int32 // accessed atomically
+}
+
+// drain runs the work in the lane until there is none left.
+func (l *lane) drain() {
+	for {
+		select {
+		case f := <-l.ch:
+			f()
+		default:
+			atomic.StoreInt32(&l.running, 0)
+			// Work may have been added after we looked.
+			if len(l.ch) == 0 || !atomic.CompareAndSwapInt32(&l.running, 0, 1) {
+				return
+			}
+		}
+	}
+}
+
+func (p *pool) stats() DispatchStats {
+	depth, qcap := len(p.queue), cap(p.queue)
+	lanes, _ := p.lanes.Load().([]*lane)
+	for _, l := range lanes {
+		depth += len(l.ch)
+		qcap += cap(l.ch)
+	}
+	return DispatchStats{
+		Workers:    int(atomic.LoadInt32(&p.workers)),
+		MaxWorkers: int(p.max),
+		QueueDepth: depth,
+		QueueCap:   qcap,
+		QueuePeak:  int(atomic.LoadInt32(&p.peak)),
+		Queued:     atomic.LoadUint64(&p.queued),
+		Dropped:    atomic.LoadUint64(&p.dropped),
+		Inline:     atomic.LoadUint64(&p.inline),
+	}
+}
+
+// DispatchStats returns a snapshot of the metrics for the pool of
+// goroutines that run this connection's handlers.
+func (conn *Conn) DispatchStats() DispatchStats {
+	return conn.pool.stats()
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolRunInline(t *testing.T) {
+	p := newPool(1, 1, QueueBlock)
+
+	// Occupy the only worker.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	p.run(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	if s := p.stats(); s.Workers != 1 {
+		t.Errorf("Expected 1 worker, got %d.", s.Workers)
+	}
+
+	// With the worker busy, run should execute in this goroutine.
+	ran := false
+	p.run(func() { ran = true })
+	if !ran {
+		t.Errorf("Work not run inline when pool saturated.")
+	}
+	if s := p.stats(); s.Inline != 1 || s.Workers != 1 {
+		t.Errorf("Bad stats after inline run: %#v", s)
+	}
+	close(release)
+}
+
+func TestPoolEnqueue(t *testing.T) {
+	p := newPool(4, 8, QueueBlock)
+	wg := &sync.WaitGroup{}
+	count := new(int32)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		p.enqueue(func() {
+			atomic.AddInt32(count, 1)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	if atomic.LoadInt32(count) != 20 {
+		t.Errorf("Not all queued work was run.")
+	}
+	if s := p.stats(); s.Queued != 20 || s.Dropped != 0 || s.Workers > 4 {
+		t.Errorf("Bad stats after enqueue: %#v", s)
+	}
+}
+
+func TestPoolPolicies(t *testing.T) {
+	tests := []struct {
+		policy QueuePolicy
+		want   []int
+	}{
+		{QueueDropNewest, []int{0, 1}},
+		{QueueDropOldest, []int{3, 4}},
+	}
+	for _, test := range tests {
+		p := newPool(1, 2, test.policy)
+		// Block the only worker so nothing drains the queue.
+		release := make(chan struct{})
+		started := make(chan struct{})
+		p.enqueue(func() {
+			close(started)
+			<-release
+		})
+		<-started
+
+		mu := sync.Mutex{}
+		got := []int{}
+		for i := 0; i < 5; i++ {
+			i := i
+			p.enqueue(func() {
+				mu.Lock()
+				got = append(got, i)
+				mu.Unlock()
+			})
+		}
+		if s := p.stats(); s.QueueDepth != 2 || s.QueuePeak != 2 || s.Dropped != 3 {
+			t.Errorf("%s: bad stats with full queue: %#v", test.policy, s)
+		}
+		close(release)
+		for i := 0; i < 100 && len(p.queue) > 0; i++ {
+			<-time.After(time.Millisecond)
+		}
+		<-time.After(time.Millisecond)
+		mu.Lock()
+		if len(got) != 2 || got[0] != test.want[0] || got[1] != test.want[1] {
+			t.Errorf("%s: expected %v to run, got %v", test.policy, test.want, got)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDispatchStats(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	bg := callCheck(t)
+	c.HandleBG(PRIVMSG, HandlerFunc(func(conn *Conn, line *Line) {
+		bg.call()
+	}))
+	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :hello")
+	bg.assertWasCalled("Background handler not called via pool.")
+	if st := c.DispatchStats(); st.Queued == 0 || st.MaxWorkers != defaultWorkers ||
+		st.QueueCap != defaultBGQueue {
+		t.Errorf("Bad dispatch stats: %#v", st)
+	}
+}
+
+func TestPoolEnqueueKeyed(t *testing.T) {
+	p := newPool(4, 16, QueueBlock)
+
+	// Work for each key should complete in the order it was queued,
+	// even though each item sleeps for a varying amount of time.
+	mu := sync.Mutex{}
+	got := map[string][]int{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"#a", "#b", "nick"} {
+			i, key := i, key
+			wg.Add(1)
+			p.enqueueKeyed(key, func() {
+				<-time.After(time.Duration(10-i) * 100 * time.Microsecond)
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+				wg.Done()
+			})
+		}
+	}
+	wg.Wait()
+	for key, order := range got {
+		for i, n := range order {
+			if i != n {
+				t.Errorf("Work for %s run out of order: %v", key, order)
+				break
+			}
+		}
+	}
+	if s := p.stats(); s.Queued != 30 || s.QueueDepth != 0 {
+		t.Errorf("Bad stats after keyed enqueue: %#v", s)
+	}
+}
+
+func TestPoolLanesLimited(t *testing.T) {
+	p := newPool(2, 8, QueueBlock)
+	if s := p.stats(); s.QueueCap != 8 || p.lanes.Load() != nil {
+		t.Errorf("Bad stats before keyed enqueue: %#v", s)
+	}
+
+	// Lanes are drained by the pool's workers, so ordered and unordered
+	// work together never run in more than the pool's goroutines.
+	var running, peak int32
+	wg := &sync.WaitGroup{}
+	work := func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-time.After(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		wg.Done()
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		p.enqueueKeyed(string(rune('a'+i)), work)
+		p.enqueue(work)
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("%d handlers ran at once in a pool of 2.", peak)
+	}
+	// The lanes share the queue's length between them.
+	if s := p.stats(); s.QueueCap != 16 || s.Workers > 2 {
+		t.Errorf("Bad stats after keyed enqueue: %#v", s)
+	}
+}
+
+func TestBGOrderKey(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.BGOrderKey = KeyByTarget
+
+	mu := sync.Mutex{}
+	got := []string{}
+	done := make(chan struct{})
+	c.HandleBG(PRIVMSG, HandlerFunc(func(conn *Conn, line *Line) {
+		if line.Text() == "first" {
+			// Without ordering, "second" would overtake us.
+			<-time.After(2 * time.Millisecond)
+		}
+		mu.Lock()
+		got = append(got, line.Text())
+		if len(got) == 2 {
+			close(done)
+		}
+		mu.Unlock()
+	}))
+	c.in <- ParseLine(":nick!user@host.com PRIVMSG #Channel :first")
+	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :second")
+	select {
+	case <-done:
+	case <-time.After(50 * time.Millisecond):
+		t.Fatalf("Background handlers not called.")
+	}
+	if got[0] != "first" || got[1] != "second" {
+		t.Errorf("Background handlers ran out of order: %v", got)
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	tests := []struct {
+		in             string
+		target, bynick string
+	}{
+		{":Nick!user@host PRIVMSG #Chan :hi", "#chan", "nick"},
+		{":Nick!user@host PRIVMSG me :hi", "nick", "nick"},
+		{":Nick!user@host PRIVMSG :", "nick", "nick"},
+		{":server 001 me :Welcome", "me", ""},
+	}
+	for i, test := range tests {
+		l := ParseLine(test.in)
+		if k := KeyByTarget(l); k != test.target {
+			t.Errorf("test %d: KeyByTarget expected %q, got %q", i, test.target, k)
+		}
+		if k := KeyByNick(l); k != test.bynick {
+			t.Errorf("test %d: KeyByNick expected %q, got %q", i, test.bynick, k)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package client
+
+// This file contains the code that detects when the state tracker has
+// drifted from the server's view of the world, and brings it back in line.
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
+)
+
+// DriftStats counts evidence that the state tracker has drifted out of
+// sync with the server, and the work done to correct it.
+type DriftStats struct {
+	// Lines referring to channels or nicks the tracker didn't know about.
+	UnknownChannels, UnknownNicks uint64
+	// Inconsistencies found in the tracker's internal state by VerifyState.
+	Inconsistencies uint64
+	// Channel resyncs started, and the nicks added to or removed from
+	// channels, or privileges removed from nicks, when they completed.
+	Resyncs, NicksAdded, NicksRemoved, PrivsRemoved uint64
+}
+
+// resyncs tracks the channels waiting for NAMES replies to complete a
+// resync, and the nicks and prefixes seen in those replies so far.
+type resyncs struct {
+	mu      sync.Mutex
+	pending map[string]map[string]string
+	stats   DriftStats // accessed atomically
+}
+
+func newResyncs() *resyncs {
+	return &resyncs{pending: make(map[string]map[string]string)}
+}
+
+// start returns false if a resync of the channel is already in progress.
+func (r *resyncs) start(channel string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[channel]; ok {
+		return false
+	}
+	r.pending[channel] = make(map[string]string)
+	return true
+}
+
+// seen records a nick and its prefixes in a NAMES reply for a channel
+// being resynced, returning false if the channel isn't being resynced.
+func (r *resyncs) seen(channel, nick, prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names, ok := r.pending[channel]
+	if ok {
+		names[nick] = prefix
+	}
+	return ok
+}
+
+// finish returns the nicks seen for a channel, if it was being resynced.
+func (r *resyncs) finish(channel string) (map[string]string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names, ok := r.pending[channel]
+	delete(r.pending, channel)
+	return names, ok
+}
+
+func (r *resyncs) wipe() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = make(map[string]map[string]string)
+}
+
+// DriftStats returns a snapshot of the state tracker's drift counters.
+func (conn *Conn) DriftStats() DriftStats {
+	s := &conn.resyncs.stats
+	return DriftStats{
+		UnknownChannels: atomic.LoadUint64(&s.UnknownChannels),
+		UnknownNicks:    atomic.LoadUint64(&s.UnknownNicks),
+		Inconsistencies: atomic.LoadUint64(&s.Inconsistencies),
+		Resyncs:         atomic.LoadUint64(&s.Resyncs),
+		NicksAdded:      atomic.LoadUint64(&s.NicksAdded),
+		NicksRemoved:    atomic.LoadUint64(&s.NicksRemoved),
+		PrivsRemoved:    atomic.LoadUint64(&s.PrivsRemoved),
+	}
+}
+
+// drifted is called by the state handlers when a line refers to state
+// the tracker doesn't know about. counter is incremented, and if
+// Config.ResyncOnDrift is set and channel is not empty, it is resynced.
+func (conn *Conn) drifted(counter *uint64, channel string) {
+	atomic.AddUint64(counter, 1)
+	if conn.cfg.ResyncOnDrift && channel != "" {
+		conn.Resync(channel)
+	}
+}
+
+// Resync asks the server for the current nicks, modes and nick info for
+// the given channels, or all tracked channels if none are given, and
+// reconciles the tracker's state with the replies. In particular, nicks
+// the tracker thinks are on a channel but are not in the NAMES reply are
+// removed from it. Channels that aren't tracked are assumed to be ones
+// we're on, which is useful after enabling state tracking mid-session.
+func (conn *Conn) Resync(channels ...string) {
+	if conn.st == nil {
+		return
+	}
+	me := conn.Me()
+	if len(channels) == 0 {
+		for c := range me.Channels {
+			channels = append(channels, c)
+		}
+	}
+	for _, c := range channels {
+		if conn.st.GetChannel(c) == nil {
+			conn.st.NewChannel(c)
+			conn.st.Associate(c, me.Nick)
+		}
+		if !conn.resyncs.start(c) {
+			continue
+		}
+		atomic.AddUint64(&conn.resyncs.stats.Resyncs, 1)
+		conn.Raw("NAMES " + c)
+		conn.whoChannel(c)
+		conn.Mode(c)
+	}
+}
+
+// VerifyState checks the state tracker's internal state for consistency,
+// returning any problems found. Channels with problems are forgotten and
+// resynced from scratch.
+func (conn *Conn) VerifyState() []*state.Inconsistency {
+	if conn.st == nil {
+		return nil
+	}
+	bad := conn.st.Verify()
+	if len(bad) == 0 {
+		return nil
+	}
+	atomic.AddUint64(&conn.resyncs.stats.Inconsistencies, uint64(len(bad)))
+	channels := make(map[string]bool)
+	for _, i := range bad {
+		logging.Warn("irc.VerifyState(): %s", i)
+		if i.Channel != "" {
+			channels[i.Channel] = true
+		}
+	}
+	for c := range channels {
+		conn.st.DelChannel(c)
+		conn.Resync(c)
+	}
+	return bad
+}
+
+// Handle 366 end of names, completing any resync of the channel
+func (conn *Conn) h_366(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	names, ok := conn.resyncs.finish(line.Args[1])
+	if !ok || conn.st == nil {
+		return
+	}
+	ch := conn.st.GetChannel(line.Args[1])
+	if ch == nil {
+		return
+	}
+	me := conn.Me()
+	cm := conn.isupport.chanModes()
+	for nick, cp := range ch.Nicks {
+		prefix, ok := names[nick]
+		if !ok && nick != me.Nick {
+			logging.Info("irc.366(): resync removed %s from %s", nick, ch.Name)
+			atomic.AddUint64(&conn.resyncs.stats.NicksRemoved, 1)
+			conn.st.Dissociate(ch.Name, nick)
+			continue
+		}
+		if extra := extraPrivs(cm, cp, prefix); extra != "" {
+			logging.Info("irc.366(): resync removed -%s from %s on %s",
+				extra, nick, ch.Name)
+			atomic.AddUint64(&conn.resyncs.stats.PrivsRemoved, 1)
+			args := make([]string, len(extra))
+			for i := range args {
+				args[i] = nick
+			}
+			conn.st.ChannelModes(ch.Name, "-"+extra, args...)
+		}
+	}
+}
+
+// extraPrivs returns the privilege modes a nick has in the tracker that
+// rank higher than the highest of the prefixes it was shown with in a NAMES
+// reply. NAMES usually only shows the highest privilege, so lower ones
+// can't be checked.
+func extraPrivs(cm *chanModes, cp *state.ChanPrivs, prefix string) string {
+	modes := cp.String()
+	if !strings.HasPrefix(modes, "+") {
+		// No modes set.
+		return ""
+	}
+	rank := len(cm.prefixModes)
+	for i := 0; i < len(prefix); i++ {
+		if r := strings.IndexByte(cm.prefixSymbols, prefix[i]); r != -1 && r < rank {
+			rank = r
+		}
+	}
+	extra := ""
+	for _, m := range []byte(modes[1:]) {
+		if r := strings.IndexByte(cm.prefixModes, m); r == -1 || r < rank {
+			extra += string(m)
+		}
+	}
+	return extra
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/fluffle/goirc/state"
+	"github.com/golang/mock/gomock"
+)
+
+// setUpTracking replaces the mock state tracker with a real one and
+// joins #test1 with some other nicks.
+func setUpTracking(t *testing.T) (*Conn, *testState) {
+	c, s := setUp(t)
+	c.st = nil
+	c.EnableStateTracking()
+	c.dispatch(ParseLine(":test!test@somehost.com JOIN :#test1"))
+	s.nc.Expect("MODE #test1")
+	s.nc.Expect("WHO #test1")
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1 :test user1 @user2"))
+	c.dispatch(ParseLine(":irc.server.org 366 test #test1 :End of /NAMES list."))
+	return c, s
+}
+
+func TestResync(t *testing.T) {
+	c, s := setUpTracking(t)
+	defer s.tearDown()
+
+	c.dispatch(ParseLine(":user2!ident2@host2.com MODE #test1 +o user1"))
+	c.Resync()
+	s.nc.Expect("NAMES #test1")
+	s.nc.Expect("WHO #test1")
+	s.nc.Expect("MODE #test1")
+	// A second resync while one is in progress should do nothing.
+	c.Resync("#test1")
+
+	// We missed user2 leaving, user1 being deopped and user3 joining.
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1 :test user1 +user3"))
+	c.dispatch(ParseLine(":irc.server.org 366 test #test1 :End of /NAMES list."))
+
+	ch := c.StateTracker().GetChannel("#test1")
+	if len(ch.Nicks) != 3 {
+		t.Errorf("Unexpected nicks after resync: %v", ch.Nicks)
+	}
+	if _, ok := ch.IsOn("user2"); ok {
+		t.Errorf("user2 not removed by resync.")
+	}
+	if cp, ok := ch.IsOn("user1"); !ok || cp.Op {
+		t.Errorf("user1 not deopped by resync: %v", cp)
+	}
+	if cp, ok := ch.IsOn("user3"); !ok || !cp.Voice {
+		t.Errorf("user3 not added by resync: %v", cp)
+	}
+	if st := c.DriftStats(); st.Resyncs != 1 || st.NicksAdded != 1 ||
+		st.NicksRemoved != 1 || st.PrivsRemoved != 1 {
+		t.Errorf("Bad drift stats after resync: %#v", st)
+	}
+
+	// NAMES replies outside a resync shouldn't remove anyone.
+	c.dispatch(ParseLine(":irc.server.org 353 test = #test1 :test"))
+	c.dispatch(ParseLine(":irc.server.org 366 test #test1 :End of /NAMES list."))
+	if ch := c.StateTracker().GetChannel("#test1"); len(ch.Nicks) != 3 {
+		t.Errorf("Nicks removed outside resync: %v", ch.Nicks)
+	}
+}
+
+func TestResyncDisableStateTracking(t *testing.T) {
+	c, s := setUpTracking(t)
+	defer s.tearDown()
+
+	c.Resync("#test1")
+	s.nc.Expect("NAMES #test1")
+	s.nc.Expect("WHO #test1")
+	s.nc.Expect("MODE #test1")
+	c.DisableStateTracking()
+	// The end of the NAMES reply may already be being handled.
+	c.h_366(ParseLine(":irc.server.org 366 test #test1 :End of /NAMES list."))
+}
+
+func TestResyncOnDrift(t *testing.T) {
+	c, s := setUpTracking(t)
+	defer s.tearDown()
+
+	// Without ResyncOnDrift, drift is only counted.
+	c.dispatch(ParseLine(":user1!ident1@host1.com MODE #test1 +o nobody"))
+	if st := c.DriftStats(); st.UnknownNicks != 1 || st.Resyncs != 0 {
+		t.Errorf("Bad drift stats after MODE for unknown nick: %#v", st)
+	}
+
+	c.cfg.ResyncOnDrift = true
+	c.dispatch(ParseLine(":user1!ident1@host1.com TOPIC #test2 :a topic"))
+	s.nc.Expect("NAMES #test2")
+	s.nc.Expect("WHO #test2")
+	s.nc.Expect("MODE #test2")
+	if _, ok := c.StateTracker().IsOn("#test2", "test"); !ok {
+		t.Errorf("Drifted channel not tracked after resync started.")
+	}
+	if st := c.DriftStats(); st.UnknownChannels != 1 || st.Resyncs != 1 {
+		t.Errorf("Bad drift stats after TOPIC for unknown channel: %#v", st)
+	}
+}
+
+func TestVerifyState(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	s.st.EXPECT().Verify().Return(nil)
+	if bad := c.VerifyState(); bad != nil {
+		t.Errorf("Unexpected inconsistencies: %v", bad)
+	}
+
+	bad := []*state.Inconsistency{
+		{Channel: "#test1", Nick: "user1", Problem: "broken"},
+		{Channel: "#test1", Problem: "also broken"},
+	}
+	gomock.InOrder(
+		s.st.EXPECT().Verify().Return(bad),
+		s.st.EXPECT().DelChannel("#test1"),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+		s.st.EXPECT().GetChannel("#test1").Return(nil),
+		s.st.EXPECT().NewChannel("#test1"),
+		s.st.EXPECT().Associate("#test1", "test"),
+	)
+	if got := c.VerifyState(); len(got) != 2 {
+		t.Errorf("Expected 2 inconsistencies, got %v", got)
+	}
+	s.nc.Expect("NAMES #test1")
+	s.nc.Expect("WHO #test1")
+	s.nc.Expect("MODE #test1")
+	if st := c.DriftStats(); st.Inconsistencies != 2 || st.Resyncs != 1 {
+		t.Errorf("Bad drift stats after VerifyState: %#v", st)
+	}
+}
+
+func TestExtraPrivs(t *testing.T) {
+	cm := newISupport().chanModes()
+	tests := []struct {
+		privs  state.ChanPrivs
+		prefix string
+		extra  string
+	}{
+		{state.ChanPrivs{}, "", ""},
+		{state.ChanPrivs{Op: true}, "@", ""},
+		{state.ChanPrivs{Op: true, Voice: true}, "@", ""},
+		{state.ChanPrivs{Op: true, Voice: true}, "+", "o"},
+		{state.ChanPrivs{Owner: true, Op: true}, "", "qo"},
+		{state.ChanPrivs{Owner: true, Op: true}, "@+", "q"},
+	}
+	for i, test := range tests {
+		if extra := extraPrivs(cm, &test.privs, test.prefix); extra != test.extra {
+			t.Errorf("test %d: expected %q, got %q", i, test.extra, extra)
+		}
+	}
+}
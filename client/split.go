@@ -0,0 +1,324 @@
+package client
+
+// This file contains the code that works out how much text fits in a
+// message once the server has relayed it to everyone else, and splits
+// longer messages into parts that fit.
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fluffle/goirc/format"
+	"github.com/fluffle/goirc/mask"
+)
+
+const (
+	// The maximum length of a line including "\r\n", if the server
+	// doesn't send the LINELEN ISUPPORT token.
+	defaultLineLen = 512
+	// The longest ident and host we assume the server may use for us,
+	// before it has told us what they are.
+	defaultUserLen = 10
+	defaultHostLen = 63
+)
+
+// hostmask is our own nick!ident@host as the server sees it, which it puts
+// in front of every message we send when relaying it to others. It is kept
+// up to date by the internal handlers for 001, 396, NICK and CHGHOST,
+// whether or not state tracking is enabled.
+type hostmask struct {
+	mu                sync.RWMutex
+	nick, ident, host string
+}
+
+func (hm *hostmask) wipe() {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.nick, hm.ident, hm.host = "", "", ""
+}
+
+func (hm *hostmask) set(nick, ident, host string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if nick != "" {
+		hm.nick = nick
+	}
+	if ident != "" {
+		hm.ident = ident
+	}
+	if host != "" {
+		hm.host = host
+	}
+}
+
+func (hm *hostmask) get() (nick, ident, host string) {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+	return hm.nick, hm.ident, hm.host
+}
+
+// prefixLen returns the length of the prefix the server relays in front of
+// our messages, ":nick!ident@host ". Until the server tells us our hostmask
+// we assume the longest ident and host it might give us.
+func (conn *Conn) prefixLen() int {
+	nick, ident, host := conn.hostmask.get()
+	if nick == "" {
+		nick = conn.cfg.Me.Nick
+	}
+	identLen := len(ident)
+	if ident == "" {
+		// Servers prefix our ident with "~" if identd didn't confirm it.
+		identLen, _ = strconv.Atoi(conn.isupport.getDefault("USERLEN", ""))
+		if identLen <= 0 {
+			identLen = defaultUserLen
+		}
+		identLen++
+	}
+	hostLen := len(host)
+	if host == "" {
+		hostLen, _ = strconv.Atoi(conn.isupport.getDefault("HOSTLEN", ""))
+		if hostLen <= 0 {
+			hostLen = defaultHostLen
+		}
+	}
+	return 1 + len(nick) + 1 + identLen + 1 + hostLen + 1
+}
+
+// lineLen returns the maximum length of a line including "\r\n", from the
+// LINELEN ISUPPORT token if the server sends it.
+func (conn *Conn) lineLen() int {
+	n, _ := strconv.Atoi(conn.isupport.getDefault("LINELEN", ""))
+	if n <= 0 {
+		n = defaultLineLen
+	}
+	return n
+}
+
+// payloadLen returns how many bytes of text fit in a cmd sent to target,
+// once the server has added our hostmask, such that the relayed line is no
+// longer than the server's LINELEN. It is further limited by
+// Config.SplitLen. extra is the number of bytes of the text that are
+// added around each part, like the \001s and command of a CTCP.
+func (conn *Conn) payloadLen(cmd, target string, extra int) int {
+	// ":nick!ident@host PRIVMSG target :text\r\n"
+	n := conn.lineLen() - conn.prefixLen() - len(cmd) - 1 - len(target) - 2 - 2 - extra
+	if conn.cfg.SplitLen > 0 && conn.cfg.SplitLen < n {
+		n = conn.cfg.SplitLen
+	}
+	if n < 1 {
+		// The server will cut the line short, but we have to send
+		// something.
+		n = 1
+	}
+	return n
+}
+
+// Handle 396 RPL_HOSTHIDDEN, telling us our new displayed host, e.g.
+//     :irc.server.org 396 nick ident@host.example :is now your hidden host
+func (conn *Conn) h_396(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	ident, host := "", line.Args[1]
+	if idx := strings.LastIndex(host, "@"); idx != -1 {
+		ident, host = host[:idx], host[idx+1:]
+	}
+	conn.hostmask.set(line.Args[0], ident, host)
+	if conn.st != nil {
+		me := conn.Me()
+		if ident == "" {
+			ident = me.Ident
+		}
+		conn.st.NickHost(me.Nick, ident, host)
+	} else {
+		conn.cfg.Me.Host = host
+		if ident != "" {
+			conn.cfg.Me.Ident = ident
+		}
+	}
+}
+
+// Handle CHGHOST messages about ourselves
+func (conn *Conn) h_chghostHostmask(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	if nick, _, _ := conn.hostmask.get(); nick != "" && conn.Matcher().Equal(line.Nick, nick) {
+		conn.hostmask.set("", line.Args[0], line.Args[1])
+		if conn.st == nil {
+			conn.cfg.Me.Ident, conn.cfg.Me.Host = line.Args[0], line.Args[1]
+		}
+	}
+}
+
+// splitHostmask splits the nick!ident@host at the end of a 001 welcome.
+func splitHostmask(text string) (nick, ident, host string) {
+	if idx := strings.LastIndex(text, " "); idx != -1 {
+		text = text[idx+1:]
+	}
+	if !strings.Contains(text, "@") {
+		return "", "", ""
+	}
+	return mask.Split(text)
+}
+
+// A Splitter splits msg into parts of at most max bytes, each of which is
+// sent in its own message. Config.Splitter replaces the default, which is
+// created by NewSplitter.
+type Splitter func(msg string, max int) []string
+
+// split splits msg into parts of at most max bytes with Config.Splitter.
+func (conn *Conn) split(msg string, max int) []string {
+	if conn.cfg.Splitter != nil {
+		return conn.cfg.Splitter(msg, max)
+	}
+	return NewSplitter(conn.cfg.SplitMarker)(msg, max)
+}
+
+// NewSplitter returns the default Splitter, which appends marker to every
+// part but the last. It splits messages at:
+//   1. the end of the last sentence fragment that fits
+//   2. the end of the last word that fits
+//   3. the last grapheme cluster that fits
+// without cutting UTF-8 characters or formatting codes in half. Bold,
+// colour and other formatting still in effect at the end of a part is
+// turned on again at the start of the next. If max is not positive, parts
+// are at most 450 bytes; otherwise they have room for at least one byte
+// of the message besides the marker.
+func NewSplitter(marker string) Splitter {
+	return func(msg string, max int) []string {
+		if max <= 0 {
+			max = defaultSplit
+		} else if max <= len(marker) {
+			// This is quite short ;-)
+			max = len(marker) + 1
+		}
+		if len(msg) <= max {
+			return []string{msg}
+		}
+		var msgs []string
+		var st format.Style
+		carry := ""
+		for len(carry)+len(msg) > max {
+			room := max - len(carry) - len(marker)
+			if room < 1 {
+				// Leave out the formatting rather than loop forever.
+				carry, room = "", max-len(marker)
+			}
+			idx := indexSplit(msg, room)
+			msgs = append(msgs, carry+msg[:idx]+marker)
+			st = st.After(msg[:idx])
+			carry, msg = st.CodesFor(msg[idx:]), msg[idx:]
+		}
+		return append(msgs, carry+msg)
+	}
+}
+
+// indexSplit returns the index at which to split msg so that the first
+// part is no longer than max bytes, preferring the end of a sentence
+// fragment or word, and avoiding cutting characters, grapheme clusters or
+// formatting codes in half. It always returns at least 1.
+func indexSplit(msg string, max int) int {
+	if max >= len(msg) {
+		return len(msg)
+	}
+	idx := indexFragment(msg[:max])
+	if idx < 0 {
+		idx = indexBreak(msg, max)
+	}
+	idx = indexCodeStart(msg, idx)
+	if idx <= 0 {
+		// Nothing fits but a code or a single huge cluster.
+		idx = indexBreak(msg, max)
+		if idx <= 0 {
+			_, idx = utf8.DecodeRuneInString(msg)
+		}
+	}
+	return idx
+}
+
+// indexBreak returns the largest index in msg no greater than max that
+// doesn't cut a UTF-8 character or grapheme cluster in half, or 0 if the
+// first grapheme cluster is longer than max.
+func indexBreak(msg string, max int) int {
+	if max >= len(msg) {
+		return len(msg)
+	}
+	idx := max
+	for idx > 0 && !utf8.RuneStart(msg[idx]) {
+		idx--
+	}
+	// Back up while the character at idx joins on to the one before.
+	brk := idx
+	for brk > 0 {
+		r, _ := utf8.DecodeRuneInString(msg[brk:])
+		p, size := utf8.DecodeLastRuneInString(msg[:brk])
+		if isRegional(p) && isRegional(r) {
+			// Flags are pairs of regional indicators, so we can break
+			// between them after an even number.
+			if countRegional(msg[:brk])%2 == 0 {
+				break
+			}
+		} else if !extendsCluster(p, r) {
+			break
+		}
+		brk -= size
+	}
+	if brk == 0 {
+		// A single cluster that doesn't fit has to be cut somewhere.
+		return idx
+	}
+	return brk
+}
+
+// extendsCluster returns true if r continues the grapheme cluster that the
+// character before it, prev, is part of. This is a simplification of the
+// rules in Unicode Standard Annex #29 that covers combining marks, emoji
+// modifiers and sequences; flags are handled by indexBreak.
+func extendsCluster(prev, r rune) bool {
+	switch {
+	case prev == '\u200d' || r == '\u200d':
+		// Zero width joiners glue emoji together.
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc,
+		unicode.Variation_Selector):
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		// Emoji skin tone modifiers.
+		return true
+	case r >= 0xe0020 && r <= 0xe007f:
+		// Tags, used in subdivision flags.
+		return true
+	}
+	return false
+}
+
+func isRegional(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+// countRegional counts the regional indicators at the end of s.
+func countRegional(s string) int {
+	n := 0
+	for {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if !isRegional(r) {
+			return n
+		}
+		s, n = s[:len(s)-size], n+1
+	}
+}
+
+// indexCodeStart moves idx back to the start of the formatting code it is
+// in the middle of, if any.
+func indexCodeStart(msg string, idx int) int {
+	for i := 0; i < idx; i++ {
+		if n := format.CodeLen(msg[i:]); i+n > idx {
+			return i
+		} else if n > 1 {
+			i += n - 1
+		}
+	}
+	return idx
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostmask(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.st = nil
+
+	check := func(nick, ident, host string) {
+		if n, i, h := c.hostmask.get(); n != nick || i != ident || h != host {
+			t.Errorf("hostmask = %s!%s@%s, want %s!%s@%s", n, i, h, nick, ident, host)
+		}
+	}
+
+	c.h_001(ParseLine(":irc.server.org 001 test :Welcome to IRC test!~test@somehost.com"))
+	check("test", "~test", "somehost.com")
+
+	c.h_NICK(ParseLine(":test!~test@somehost.com NICK :Test2"))
+	check("Test2", "~test", "somehost.com")
+	c.h_NICK(ParseLine(":other!ident@host NICK :other2"))
+	check("Test2", "~test", "somehost.com")
+
+	c.h_396(ParseLine(":irc.server.org 396 Test2 user/test :is now your hidden host"))
+	check("Test2", "~test", "user/test")
+	if c.cfg.Me.Host != "user/test" {
+		t.Errorf("396 didn't set host, got %q", c.cfg.Me.Host)
+	}
+
+	c.h_chghostHostmask(ParseLine(":test2!~test@user/test CHGHOST ident new.host"))
+	check("Test2", "ident", "new.host")
+	c.h_chghostHostmask(ParseLine(":other!ident@host CHGHOST foo bar"))
+	check("Test2", "ident", "new.host")
+	if c.cfg.Me.Ident != "ident" || c.cfg.Me.Host != "new.host" {
+		t.Errorf("CHGHOST didn't set ident and host, got %s@%s",
+			c.cfg.Me.Ident, c.cfg.Me.Host)
+	}
+}
+
+func TestPayloadLen(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.SplitLen = 1000
+
+	// Before 001, the longest ident and host are assumed:
+	// ":test!~xxxxxxxxxx@<63> PRIVMSG #test :" + "\r\n"
+	if n := c.payloadLen(PRIVMSG, "#test", 0); n != 512-(1+4+1+11+1+63+1)-8-5-2-2 {
+		t.Errorf("payloadLen before 001 = %d", n)
+	}
+	c.isupport.parse([]string{"USERLEN=12", "HOSTLEN=32"})
+	if n := c.payloadLen(PRIVMSG, "#test", 0); n != 512-(1+4+1+13+1+32+1)-8-5-2-2 {
+		t.Errorf("payloadLen with USERLEN and HOSTLEN = %d", n)
+	}
+
+	c.hostmask.set("test", "~test", "somehost.com")
+	if n := c.payloadLen(PRIVMSG, "#test", 0); n != 512-len(":test!~test@somehost.com PRIVMSG #test :\r\n") {
+		t.Errorf("payloadLen = %d", n)
+	}
+	c.isupport.parse([]string{"LINELEN=1024"})
+	if n := c.payloadLen(NOTICE, "#test", 10); n != 1024-len(":test!~test@somehost.com NOTICE #test :\r\n")-10 {
+		t.Errorf("payloadLen with LINELEN = %d", n)
+	}
+	c.cfg.SplitLen = 100
+	if n := c.payloadLen(NOTICE, "#test", 10); n != 100 {
+		t.Errorf("payloadLen with SplitLen = %d", n)
+	}
+
+	// Messages are split to fit however little room there is.
+	c.isupport.parse([]string{"LINELEN=" +
+		strconv.Itoa(len(":test!~test@somehost.com PRIVMSG #test :\r\n")+10)})
+	if n := c.payloadLen(PRIVMSG, "#test", 0); n != 10 {
+		t.Errorf("payloadLen with small LINELEN = %d", n)
+	}
+	c.Privmsg("#test", "foo bar baz")
+	s.nc.Expect("PRIVMSG #test :foo ...")
+	s.nc.Expect("PRIVMSG #test :bar baz")
+	if n := c.payloadLen(PRIVMSG, "#"+strings.Repeat("c", 20), 0); n != 1 {
+		t.Errorf("payloadLen with no room = %d", n)
+	}
+}
+
+func TestPrivmsgFitsLine(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.SplitLen = 1000
+	c.hostmask.set("test", "~test", strings.Repeat("h", 60))
+
+	target := "#" + strings.Repeat("c", 40)
+	msg := strings.Repeat("word ", 200)
+	prefix := len(":test!~test@" + strings.Repeat("h", 60) + " ")
+	sent := ""
+	for _, f := range []func(){
+		func() { c.Privmsg(target, msg) },
+		func() { c.Ctcp(target, ACTION, msg) },
+	} {
+		f()
+	lines:
+		for {
+			var l string
+			select {
+			case l = <-s.nc.Out:
+				l = strings.TrimRight(l, "\r\n")
+			case <-time.After(5 * time.Millisecond):
+				break lines
+			}
+			if prefix+len(l)+2 > 512 {
+				t.Errorf("Relayed line too long (%d): %s", prefix+len(l)+2, l)
+			}
+			sent += l
+		}
+	}
+	if strings.Count(sent, "word") != 400 {
+		t.Errorf("Words lost when splitting: %d", strings.Count(sent, "word"))
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	tests := []struct {
+		in  string
+		max int
+		out []string
+	}{
+		// Short parts are as short as asked for.
+		{"foo bar baz", 8, []string{"foo ...", "bar baz"}},
+		// Multi-byte characters aren't cut in half.
+		{"ééééééééé", 15, []string{"éééééé...", "ééé"}},
+		// Nor are combining marks separated from what they combine with.
+		{"aaaaaaaaaae\u0301e\u0301", 15,
+			[]string{"aaaaaaaaaa...", "e\u0301e\u0301"}},
+		// Or flags, which are pairs of regional indicators.
+		{"aa🇬🇧🇫🇷🇩🇪", 15, []string{"aa🇬🇧...", "🇫🇷...", "🇩🇪"}},
+		// Or emoji sequences joined by ZWJs.
+		{"aaaaaaa👩‍👩", 15, []string{"aaaaaaa...", "👩‍👩"}},
+		// Formatting codes aren't cut either.
+		{"aaaaaaaaaa\x0304,12b", 15, []string{"aaaaaaaaaa...", "\x0304,12b"}},
+		// Formatting still in effect is turned on again after a split.
+		{"\x02bold \x1ditalic\x1d and \x034red text", 15,
+			[]string{"\x02bold ...", "\x02\x1ditalic\x1d ...",
+				"\x02and \x034red text"}},
+		{"\x0304,12fg and bg \x0305only fg", 15,
+			[]string{"\x0304,12fg ...", "\x0304,12and ...",
+				"\x0304,12bg ...", "\x0304,12\x0305onl...",
+				"\x0305,12y fg"}},
+		{"\x02\x0304bold red\x0f plain text", 15,
+			[]string{"\x02\x0304bold ...", "\x02\x0304red\x0f ...",
+				"plain text"}},
+		// Single digit colours are padded, so the text isn't taken as
+		// part of the colour.
+		{"\x034red 1234567890", 15, []string{"\x034red ...", "\x03041234567890"}},
+		// Nor is a comma and digits taken as a background colour.
+		{"\x0304aaaaaaaaa,5,5,5", 15, []string{"\x0304aaaaaaaaa...", "\x0304,99,5,5,5"}},
+	}
+	split := NewSplitter("...")
+	for i, test := range tests {
+		out := split(test.in, test.max)
+		if !reflect.DeepEqual(test.out, out) {
+			t.Errorf("test %d: expected %q, got %q", i, test.out, out)
+		}
+		for _, o := range out {
+			if len(o) > test.max {
+				t.Errorf("test %d: %q is longer than %d", i, o, test.max)
+			}
+		}
+	}
+}
+
+func TestConfigSplitter(t *testing.T) {
+	// The marker is "..." even without NewConfig.
+	if m := Client(&Config{}).Config().SplitMarker; m != "..." {
+		t.Errorf("Default SplitMarker is %q", m)
+	}
+
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.SplitLen = 23
+
+	c.cfg.SplitMarker = " [more]"
+	c.Privmsg("#foo", "foo bar baz quux frob blah")
+	s.nc.Expect("PRIVMSG #foo :foo bar baz  [more]")
+	s.nc.Expect("PRIVMSG #foo :quux frob blah")
+
+	c.cfg.Splitter = func(msg string, max int) []string {
+		return strings.Fields(msg)
+	}
+	c.Notice("#foo", "a b")
+	s.nc.Expect("NOTICE #foo :a")
+	s.nc.Expect("NOTICE #foo :b")
+}
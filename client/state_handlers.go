@@ -4,25 +4,107 @@ package client
 // to manage tracking state for an IRC connection
 
 import (
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
+)
+
+// These synthetic events are dispatched when the state tracker's view of
+// the world changes, after the line that caused the change has been
+// processed by the tracker. Line.Nick is the nick the change concerns, if
+// any, and Args[0] is the channel for channel changes. Where a value has
+// changed, the old and new values are the last two Args, with modes and
+// privileges formatted like "+ov", or "" if there are none.
+const (
+	// Args: channel
+	NICKJOINED = "NICKJOINED"
+	// Args: channel
+	NICKLEFT = "NICKLEFT"
+	// Line.Nick is the old nick. Args: new nick
+	NICKRENAMED = "NICKRENAMED"
+	// Args: old modes, new modes
+	NICKMODECHANGED = "NICKMODECHANGED"
+	// Args: old away message, new away message
+	AWAYCHANGED = "AWAYCHANGED"
+	// Args: old account, new account
+	ACCOUNTCHANGED = "ACCOUNTCHANGED"
+	// Args: old ident@host, new ident@host
+	HOSTCHANGED = "HOSTCHANGED"
+	// Args: old real name, new real name
+	REALNAMECHANGED = "REALNAMECHANGED"
+	// Args: channel, old privileges, new privileges
+	PRIVCHANGED = "PRIVCHANGED"
+	// Args: channel, old topic, new topic
+	TOPICCHANGED = "TOPICCHANGED"
+	// Args: channel, old modes, new modes
+	CHANMODECHANGED = "CHANMODECHANGED"
 )
 
 var stHandlers = map[string]HandlerFunc{
-	"JOIN":  (*Conn).h_JOIN,
-	"KICK":  (*Conn).h_KICK,
-	"MODE":  (*Conn).h_MODE,
-	"NICK":  (*Conn).h_STNICK,
-	"PART":  (*Conn).h_PART,
-	"QUIT":  (*Conn).h_QUIT,
-	"TOPIC": (*Conn).h_TOPIC,
-	"311":   (*Conn).h_311,
-	"324":   (*Conn).h_324,
-	"332":   (*Conn).h_332,
-	"352":   (*Conn).h_352,
-	"353":   (*Conn).h_353,
-	"671":   (*Conn).h_671,
+	"ACCOUNT": (*Conn).h_ACCOUNT,
+	"ACTION":  (*Conn).h_STACTIVE,
+	"AWAY":    (*Conn).h_AWAY,
+	"CHGHOST": (*Conn).h_CHGHOST,
+	"JOIN":    (*Conn).h_JOIN,
+	"KICK":    (*Conn).h_KICK,
+	"MODE":    (*Conn).h_MODE,
+	"NICK":    (*Conn).h_STNICK,
+	"NOTICE":  (*Conn).h_STACTIVE,
+	"PART":    (*Conn).h_PART,
+	"PRIVMSG": (*Conn).h_STACTIVE,
+	"QUIT":    (*Conn).h_QUIT,
+	"SETNAME": (*Conn).h_SETNAME,
+	"TOPIC":   (*Conn).h_TOPIC,
+	"301":     (*Conn).h_301,
+	"305":     (*Conn).h_305,
+	"306":     (*Conn).h_306,
+	"311":     (*Conn).h_311,
+	"317":     (*Conn).h_317,
+	"324":     (*Conn).h_324,
+	"330":     (*Conn).h_330,
+	"332":     (*Conn).h_332,
+	"352":     (*Conn).h_352,
+	"353":     (*Conn).h_353,
+	"354":     (*Conn).h_354,
+	"366":     (*Conn).h_366,
+	"671":     (*Conn).h_671,
+}
+
+// stateChanged dispatches a synthetic event for a change to tracked state.
+func (conn *Conn) stateChanged(c *state.Change) {
+	line := &Line{Nick: c.Nick, Time: time.Now()}
+	switch c.Kind {
+	case state.NickJoinedChannel:
+		line.Cmd, line.Args = NICKJOINED, []string{c.Channel}
+	case state.NickLeftChannel:
+		line.Cmd, line.Args = NICKLEFT, []string{c.Channel}
+	case state.NickRenamed:
+		line.Cmd, line.Nick, line.Args = NICKRENAMED, c.Old, []string{c.New}
+	case state.NickModeChanged:
+		line.Cmd, line.Args = NICKMODECHANGED, []string{c.Old, c.New}
+	case state.NickAwayChanged:
+		line.Cmd, line.Args = AWAYCHANGED, []string{c.Old, c.New}
+	case state.NickAccountChanged:
+		line.Cmd, line.Args = ACCOUNTCHANGED, []string{c.Old, c.New}
+	case state.NickHostChanged:
+		line.Cmd, line.Args = HOSTCHANGED, []string{c.Old, c.New}
+	case state.NickRealnameChanged:
+		line.Cmd, line.Args = REALNAMECHANGED, []string{c.Old, c.New}
+	case state.PrivilegeChanged:
+		line.Cmd, line.Args = PRIVCHANGED, []string{c.Channel, c.Old, c.New}
+	case state.TopicChanged:
+		line.Cmd, line.Args = TOPICCHANGED, []string{c.Channel, c.Old, c.New}
+	case state.ChannelModeChanged:
+		line.Cmd, line.Args = CHANMODECHANGED, []string{c.Channel, c.Old, c.New}
+	default:
+		logging.Warn("irc.stateChanged(): unknown change %s", c.Kind)
+		return
+	}
+	conn.dispatch(line)
 }
 
 func (conn *Conn) addSTHandlers() {
@@ -48,12 +130,14 @@ func (conn *Conn) h_STNICK(line *Line) {
 func (conn *Conn) h_JOIN(line *Line) {
 	ch := conn.st.GetChannel(line.Args[0])
 	nk := conn.st.GetNick(line.Nick)
+	on := false
 	if ch == nil {
 		// first we've seen of this channel, so should be us joining it
 		// NOTE this will also take care of nk == nil && ch == nil
 		if !conn.Me().Equals(nk) {
 			logging.Warn("irc.JOIN(): JOIN to unknown channel %s received "+
 				"from (non-me) nick %s", line.Args[0], line.Nick)
+			conn.drifted(&conn.resyncs.stats.UnknownChannels, line.Args[0])
 			return
 		}
 		conn.st.NewChannel(line.Args[0])
@@ -63,17 +147,77 @@ func (conn *Conn) h_JOIN(line *Line) {
 		conn.Mode(line.Args[0])
 		// sending a WHO for the channel is MUCH more efficient than
 		// triggering a WHOIS on every nick from the 353 handler
-		conn.Who(line.Args[0])
+		conn.whoChannel(line.Args[0])
+		conn.backfill(line.Args[0])
+	} else if _, ok := ch.IsOn(line.Nick); ok {
+		// We think the nick is already on the channel, which happens when
+		// state has been restored from a snapshot after reconnecting.
+		if conn.Me().Equals(nk) {
+			// Our view of the channel may be out of date, so refresh it.
+			conn.Mode(line.Args[0])
+			conn.whoChannel(line.Args[0])
+			conn.backfill(line.Args[0])
+			return
+		}
+		on = true
 	}
 	if nk == nil {
 		// this is the first we've seen of this nick
 		conn.st.NewNick(line.Nick)
 		conn.st.NickInfo(line.Nick, line.Ident, line.Host, "")
-		// since we don't know much about this nick, ask server for info
-		conn.Who(line.Nick)
+		// since we don't know much about this nick, ask server for info,
+		// unless extended-join has told us all we need to know
+		if !conn.HasCap("extended-join") {
+			conn.Who(line.Nick)
+		}
+	}
+	if !on {
+		// this takes care of both nick and channel linking \o/
+		conn.st.Associate(line.Args[0], line.Nick)
+	}
+	if len(line.Args) > 2 {
+		// extended-join tells us the nick's account and real name
+		conn.st.NickAccount(line.Nick, account(line.Args[1]))
+		conn.st.NickInfo(line.Nick, line.Ident, line.Host, line.Args[2])
+	}
+	conn.st.NickActive(line.Nick, line.Time)
+}
+
+// account converts the "*" used for nicks that aren't logged in to
+// services by account-notify, extended-join and WHOX to "".
+func account(a string) string {
+	if a == "*" {
+		return ""
+	}
+	return a
+}
+
+// Handle PRIVMSGs, NOTICEs and ACTIONs to track when nicks were last active
+func (conn *Conn) h_STACTIVE(line *Line) {
+	if line.Nick != "" {
+		conn.st.NickActive(line.Nick, line.Time)
+	}
+	// Messages from servers have no ident, and aren't kept.
+	if m := historyMessage(line); m != nil && line.Ident != "" {
+		conn.st.AddMessage(m)
 	}
-	// this takes care of both nick and channel linking \o/
-	conn.st.Associate(line.Args[0], line.Nick)
+}
+
+// Handle AWAY messages from the away-notify capability
+func (conn *Conn) h_AWAY(line *Line) {
+	if len(line.Args) > 0 && line.Args[0] != "" {
+		conn.st.NickAway(line.Nick, true, line.Args[0])
+	} else {
+		conn.st.NickAway(line.Nick, false, "")
+	}
+}
+
+// Handle ACCOUNT messages from the account-notify capability
+func (conn *Conn) h_ACCOUNT(line *Line) {
+	if !line.argslen(0) {
+		return
+	}
+	conn.st.NickAccount(line.Nick, account(line.Args[0]))
 }
 
 // Handle PARTs from channels to maintain state
@@ -103,7 +247,10 @@ func (conn *Conn) h_MODE(line *Line) {
 	}
 	if ch := conn.st.GetChannel(line.Args[0]); ch != nil {
 		// channel modes first
-		conn.st.ChannelModes(line.Args[0], line.Args[1], line.Args[2:]...)
+		ch = conn.st.ChannelModes(line.Args[0], line.Args[1], line.Args[2:]...)
+		if ch != nil && !conn.modesForKnownNicks(ch, line) {
+			conn.drifted(&conn.resyncs.stats.UnknownNicks, ch.Name)
+		}
 	} else if nk := conn.st.GetNick(line.Args[0]); nk != nil {
 		// nick mode change, should be us
 		if !conn.Me().Equals(nk) {
@@ -115,9 +262,27 @@ func (conn *Conn) h_MODE(line *Line) {
 	} else {
 		logging.Warn("irc.MODE(): not sure what to do with MODE %s",
 			strings.Join(line.Args, " "))
+		if conn.isChannel(line.Args[0]) {
+			conn.drifted(&conn.resyncs.stats.UnknownChannels, line.Args[0])
+		}
 	}
 }
 
+// modesForKnownNicks returns false if a channel MODE line changes the
+// privileges of any nick the tracker doesn't think is on the channel.
+func (conn *Conn) modesForKnownNicks(ch *state.Channel, line *Line) bool {
+	cm := conn.isupport.chanModes()
+	for _, mc := range parseModeChanges(cm, line.Args[1], line.Args[2:]) {
+		if strings.IndexByte(cm.prefixModes, mc.Mode) == -1 {
+			continue
+		}
+		if _, ok := ch.IsOn(mc.Arg); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // Handle TOPIC changes for channels
 func (conn *Conn) h_TOPIC(line *Line) {
 	if !line.argslen(1) {
@@ -128,6 +293,7 @@ func (conn *Conn) h_TOPIC(line *Line) {
 	} else {
 		logging.Warn("irc.TOPIC(): topic change on unknown channel %s",
 			line.Args[0])
+		conn.drifted(&conn.resyncs.stats.UnknownChannels, line.Args[0])
 	}
 }
 
@@ -144,6 +310,56 @@ func (conn *Conn) h_311(line *Line) {
 	}
 }
 
+// Handle CHGHOST messages from the chghost capability
+func (conn *Conn) h_CHGHOST(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	conn.st.NickHost(line.Nick, line.Args[0], line.Args[1])
+}
+
+// Handle SETNAME messages from the setname capability
+func (conn *Conn) h_SETNAME(line *Line) {
+	if !line.argslen(0) {
+		return
+	}
+	conn.st.NickRealname(line.Nick, line.Args[0])
+}
+
+// Handle 301 away reply, from WHOIS or messaging an away nick
+func (conn *Conn) h_301(line *Line) {
+	if !line.argslen(2) {
+		return
+	}
+	conn.st.NickAway(line.Args[1], true, line.Args[2])
+}
+
+// Handle 305 unaway reply, when we are no longer away
+func (conn *Conn) h_305(line *Line) {
+	conn.st.NickAway(conn.Me().Nick, false, "")
+}
+
+// Handle 306 nowaway reply, when we are marked as away
+func (conn *Conn) h_306(line *Line) {
+	msg, _ := conn.awayMsg.Load().(string)
+	conn.st.NickAway(conn.Me().Nick, true, msg)
+}
+
+// Handle 317 whois idle reply
+func (conn *Conn) h_317(line *Line) {
+	if !line.argslen(2) {
+		return
+	}
+	idle, err := strconv.Atoi(line.Args[2])
+	if err != nil {
+		logging.Warn("irc.317(): bad idle time %q for %s",
+			line.Args[2], line.Args[1])
+		return
+	}
+	conn.st.NickActive(line.Args[1],
+		line.Time.Add(-time.Duration(idle)*time.Second))
+}
+
 // Handle 324 mode reply
 func (conn *Conn) h_324(line *Line) {
 	if !line.argslen(2) {
@@ -154,7 +370,16 @@ func (conn *Conn) h_324(line *Line) {
 	} else {
 		logging.Warn("irc.324(): received MODE settings for unknown channel %s",
 			line.Args[1])
+		conn.drifted(&conn.resyncs.stats.UnknownChannels, "")
+	}
+}
+
+// Handle 330 whois account reply
+func (conn *Conn) h_330(line *Line) {
+	if !line.argslen(2) {
+		return
 	}
+	conn.st.NickAccount(line.Args[1], line.Args[2])
 }
 
 // Handle 332 topic reply on join to channel
@@ -167,6 +392,7 @@ func (conn *Conn) h_332(line *Line) {
 	} else {
 		logging.Warn("irc.332(): received TOPIC value for unknown channel %s",
 			line.Args[1])
+		conn.drifted(&conn.resyncs.stats.UnknownChannels, "")
 	}
 }
 
@@ -188,19 +414,15 @@ func (conn *Conn) h_352(line *Line) {
 	//      or the hop count to this server?
 	// last arg contains "<hop count> <real name>"
 	a := strings.SplitN(line.Args[len(line.Args)-1], " ", 2)
-	conn.st.NickInfo(nk.Nick, line.Args[2], line.Args[3], a[1])
+	name := ""
+	if len(a) > 1 {
+		name = a[1]
+	}
+	conn.st.NickInfo(nk.Nick, line.Args[2], line.Args[3], name)
 	if !line.argslen(6) {
 		return
 	}
-	if idx := strings.Index(line.Args[6], "*"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+o")
-	}
-	if idx := strings.Index(line.Args[6], "B"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+B")
-	}
-	if idx := strings.Index(line.Args[6], "H"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+i")
-	}
+	conn.whoFlags(nk, line.Args[6])
 }
 
 // Handle 353 names reply
@@ -209,42 +431,42 @@ func (conn *Conn) h_353(line *Line) {
 		return
 	}
 	if ch := conn.st.GetChannel(line.Args[2]); ch != nil {
-		nicks := strings.Split(line.Args[len(line.Args)-1], " ")
-		for _, nick := range nicks {
-			// UnrealIRCd's coders are lazy and leave a trailing space
+		cm := conn.isupport.chanModes()
+		// UnrealIRCd's coders are lazy and leave a trailing space
+		for _, entry := range strings.Fields(line.Args[len(line.Args)-1]) {
+			// With multi-prefix there may be several prefixes, and with
+			// userhost-in-names the entry is nick!ident@host.
+			prefix, nick, ident, host := parseNamesEntry(cm, entry)
 			if nick == "" {
 				continue
 			}
-			switch c := nick[0]; c {
-			case '~', '&', '@', '%', '+':
-				nick = nick[1:]
-				fallthrough
-			default:
-				if conn.st.GetNick(nick) == nil {
-					// we don't know this nick yet!
-					conn.st.NewNick(nick)
-				}
-				if _, ok := conn.st.IsOn(ch.Name, nick); !ok {
-					// This nick isn't associated with this channel yet!
-					conn.st.Associate(ch.Name, nick)
+			resyncing := conn.resyncs.seen(ch.Name, nick, prefix)
+			if conn.st.GetNick(nick) == nil {
+				// we don't know this nick yet!
+				conn.st.NewNick(nick)
+			}
+			if host != "" {
+				conn.st.NickHost(nick, ident, host)
+			}
+			if _, ok := conn.st.IsOn(ch.Name, nick); !ok {
+				// This nick isn't associated with this channel yet!
+				conn.st.Associate(ch.Name, nick)
+				if resyncing {
+					atomic.AddUint64(&conn.resyncs.stats.NicksAdded, 1)
 				}
-				switch c {
-				case '~':
-					conn.st.ChannelModes(ch.Name, "+q", nick)
-				case '&':
-					conn.st.ChannelModes(ch.Name, "+a", nick)
-				case '@':
-					conn.st.ChannelModes(ch.Name, "+o", nick)
-				case '%':
-					conn.st.ChannelModes(ch.Name, "+h", nick)
-				case '+':
-					conn.st.ChannelModes(ch.Name, "+v", nick)
+			}
+			if modes := cm.prefixModesFor(prefix); modes != "" {
+				args := make([]string, len(modes))
+				for i := range args {
+					args[i] = nick
 				}
+				conn.st.ChannelModes(ch.Name, "+"+modes, args...)
 			}
 		}
 	} else {
 		logging.Warn("irc.353(): received NAMES list for unknown channel %s",
 			line.Args[2])
+		conn.drifted(&conn.resyncs.stats.UnknownChannels, "")
 	}
 }
 
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultSubscribeBuffer = 64
+
+// A subscription delivers lines to a channel instead of a handler.
+type subscription struct {
+	ctx     context.Context
+	filters []Filter
+	policy  QueuePolicy
+	remover Remover
+
+	// mu protects ch against being closed while a send is in progress.
+	mu     sync.Mutex
+	ch     chan *Line
+	closed bool
+	done   chan struct{}
+
+	dropped uint64 // accessed atomically
+}
+
+// Subscribe returns a channel that receives a copy of every line that
+// passes all of the given filters, which is useful for code organised
+// around select loops rather than callbacks. For example:
+//
+//     lines := conn.Subscribe(ctx, Commands(PRIVMSG), InChannel("#chan"))
+//     for line := range lines {
+//         ...
+//     }
+//
+// The channel is buffered; if the buffer fills because the receiver is not
+// keeping up, the oldest lines are dropped. Use SubscribeBuffered to control
+// this. The channel is closed when ctx is done, or after the DISCONNECTED
+// event for the connection has been delivered (if it passes the filters).
+func (conn *Conn) Subscribe(ctx context.Context, filters ...Filter) <-chan *Line {
+	return conn.SubscribeBuffered(ctx, defaultSubscribeBuffer, QueueDropOldest, filters...)
+}
+
+// SubscribeBuffered is like Subscribe, but allows the size of the channel's
+// buffer and the policy for handling overflow to be set. Lines are delivered
+// from a foreground handler, so QueueBlock will stall the event loop until
+// the receiver catches up.
+func (conn *Conn) SubscribeBuffered(ctx context.Context, size int, policy QueuePolicy, filters ...Filter) <-chan *Line {
+	if size < 0 {
+		size = 0
+	}
+	sub := &subscription{
+		ctx:     ctx,
+		filters: filters,
+		policy:  policy,
+		ch:      make(chan *Line, size),
+		done:    make(chan struct{}),
+	}
+	// A single handler for every event means the DISCONNECTED line can
+	// be delivered and the channel closed without racing each other.
+	// Holding the lock stops close from seeing a nil remover.
+	sub.mu.Lock()
+	sub.remover = conn.Handle("*", sub)
+	sub.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.close()
+		case <-sub.done:
+		}
+	}()
+	return sub.ch
+}
+
+func (sub *subscription) Handle(conn *Conn, line *Line) {
+	if sub.accepts(line) {
+		sub.deliver(line)
+	}
+	if line.Cmd == DISCONNECTED {
+		sub.close()
+	}
+}
+
+func (sub *subscription) accepts(line *Line) bool {
+	for _, f := range sub.filters {
+		if !f(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sub *subscription) deliver(line *Line) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	switch sub.policy {
+	case QueueDropNewest:
+		select {
+		case sub.ch <- line:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case sub.ch <- line:
+				return
+			default:
+			}
+			select {
+			case <-sub.ch:
+				atomic.AddUint64(&sub.dropped, 1)
+			default:
+				if cap(sub.ch) == 0 {
+					// Unbuffered, and nobody is receiving.
+					atomic.AddUint64(&sub.dropped, 1)
+					return
+				}
+			}
+		}
+	default:
+		// Don't block forever if the receiver has gone away.
+		select {
+		case sub.ch <- line:
+		case <-sub.ctx.Done():
+		}
+	}
+}
+
+func (sub *subscription) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	close(sub.done)
+	// Removing the handler needs the handler set's write lock, which we
+	// can't take from inside a handler that is being dispatched.
+	go sub.remover.Remove()
+}
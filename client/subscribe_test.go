@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recvLine is a helper to do a "non-blocking" read of a subscription.
+func recvLine(ch <-chan *Line) (*Line, bool) {
+	select {
+	case l, ok := <-ch:
+		return l, ok
+	case <-time.After(5 * time.Millisecond):
+		return nil, true
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := c.Subscribe(ctx, Commands(PRIVMSG), InChannel("#chan"))
+
+	c.in <- ParseLine(":nick!user@host PRIVMSG #other :not this one")
+	c.in <- ParseLine(":nick!user@host PRIVMSG #chan :this one")
+	if l, _ := recvLine(lines); l == nil || l.Text() != "this one" {
+		t.Errorf("Subscription did not receive matching line: %#v", l)
+	}
+	if l, _ := recvLine(lines); l != nil {
+		t.Errorf("Subscription received unexpected line: %#v", l)
+	}
+
+	// Cancelling the context closes the channel and removes the handler.
+	cancel()
+	if l, ok := recvLine(lines); l != nil || ok {
+		t.Errorf("Subscription not closed after context cancelled.")
+	}
+	<-time.After(time.Millisecond)
+	c.fgHandlers.RLock()
+	if _, ok := c.fgHandlers.set["*"]; ok {
+		t.Errorf("Subscription handler not removed after close.")
+	}
+	c.fgHandlers.RUnlock()
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	ctx := context.Background()
+	oldest := c.SubscribeBuffered(ctx, 2, QueueDropOldest, Commands(PRIVMSG))
+	newest := c.SubscribeBuffered(ctx, 2, QueueDropNewest, Commands(PRIVMSG))
+	for _, text := range []string{"one", "two", "three"} {
+		c.fgHandlers.dispatch(c, ParseLine(":n!u@h PRIVMSG #chan :"+text))
+	}
+	for _, test := range []struct {
+		ch   <-chan *Line
+		want []string
+	}{
+		{oldest, []string{"two", "three"}},
+		{newest, []string{"one", "two"}},
+	} {
+		for _, w := range test.want {
+			if l, _ := recvLine(test.ch); l == nil || l.Text() != w {
+				t.Errorf("Expected %q from subscription, got %#v", w, l)
+			}
+		}
+	}
+}
+
+func TestSubscribeDisconnect(t *testing.T) {
+	c, s := setUp(t)
+	// Since we're not using tearDown() here, manually call Finish()
+	defer s.ctrl.Finish()
+
+	lines := c.Subscribe(context.Background(), Commands(DISCONNECTED))
+	c.Close()
+
+	// The DISCONNECTED line should be delivered before the channel closes.
+	if l, ok := recvLine(lines); l == nil || l.Cmd != DISCONNECTED || !ok {
+		t.Errorf("Subscription did not receive DISCONNECTED: %#v", l)
+	}
+	if l, ok := recvLine(lines); l != nil || ok {
+		t.Errorf("Subscription not closed after disconnect.")
+	}
+}
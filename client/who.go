@@ -0,0 +1,158 @@
+package client
+
+// This file contains the code that asks for information about the nicks
+// on channels with WHO, using WHOX where the server supports it.
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
+)
+
+const (
+	// whoxToken identifies the replies to WHOX queries we send, so we
+	// don't mistake the replies to a user's own WHOX queries for them.
+	whoxToken = "152"
+	// The fields we request with WHOX. The server sends them back in a
+	// fixed order: token, channel, user, host, nick, flags, account and
+	// realname.
+	whoxFields = "%tcuhnfar"
+	// The default for Config.WhoInterval.
+	defaultWhoInterval = time.Second
+)
+
+// whoQueue paces the WHO queries sent for channels, so that rejoining
+// many channels after a reconnect doesn't send a burst of them.
+type whoQueue struct {
+	mu      sync.Mutex
+	pending []string
+	queued  map[string]bool
+	timer   *time.Timer
+	last    time.Time
+}
+
+func newWhoQueue() *whoQueue {
+	return &whoQueue{queued: make(map[string]bool)}
+}
+
+func (wq *whoQueue) wipe() {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+	if wq.timer != nil {
+		wq.timer.Stop()
+		wq.timer = nil
+	}
+	wq.pending = nil
+	wq.queued = make(map[string]bool)
+}
+
+// whoChannel asks the server for information about all the nicks on a
+// channel. Queries are sent at most once per Config.WhoInterval, and a
+// channel that is already waiting to be queried won't be queued twice.
+func (conn *Conn) whoChannel(channel string) {
+	wq, interval := conn.whoq, conn.cfg.WhoInterval
+	wq.mu.Lock()
+	if interval <= 0 || (len(wq.pending) == 0 &&
+		time.Since(wq.last) >= interval) {
+		wq.last = time.Now()
+		wq.mu.Unlock()
+		conn.sendWho(channel)
+		return
+	}
+	defer wq.mu.Unlock()
+	if wq.queued[channel] {
+		return
+	}
+	wq.queued[channel] = true
+	wq.pending = append(wq.pending, channel)
+	if wq.timer == nil {
+		wq.timer = time.AfterFunc(wq.last.Add(interval).Sub(time.Now()), conn.drainWho)
+	}
+}
+
+// drainWho sends the next queued WHO, and schedules the one after it.
+func (conn *Conn) drainWho() {
+	wq := conn.whoq
+	wq.mu.Lock()
+	wq.timer = nil
+	if len(wq.pending) == 0 {
+		wq.mu.Unlock()
+		return
+	}
+	channel := wq.pending[0]
+	wq.pending = wq.pending[1:]
+	delete(wq.queued, channel)
+	wq.last = time.Now()
+	if len(wq.pending) > 0 {
+		wq.timer = time.AfterFunc(conn.cfg.WhoInterval, conn.drainWho)
+	}
+	wq.mu.Unlock()
+	if !conn.Connected() {
+		// Sending would block forever without the send loop running.
+		logging.Warn("irc.drainWho(): not connected, dropping WHO %s", channel)
+		return
+	}
+	conn.sendWho(channel)
+}
+
+func (conn *Conn) sendWho(channel string) {
+	if _, ok := conn.isupport.get("WHOX"); ok {
+		conn.Raw(WHO + " " + channel + " " + whoxFields + "," + whoxToken)
+	} else {
+		conn.Who(channel)
+	}
+}
+
+// Handle 354 WHOX reply
+func (conn *Conn) h_354(line *Line) {
+	if len(line.Args) < 2 || line.Args[1] != whoxToken {
+		// Not a reply to one of our queries.
+		return
+	}
+	if !line.argslen(8) {
+		return
+	}
+	nick, flags := line.Args[5], line.Args[6]
+	nk := conn.st.GetNick(nick)
+	if nk == nil {
+		logging.Warn("irc.354(): received WHOX reply for unknown nick %s", nick)
+		return
+	}
+	conn.st.NickAccount(nick, whoxAccount(line.Args[7]))
+	if conn.Me().Equals(nk) {
+		return
+	}
+	conn.st.NickInfo(nick, line.Args[3], line.Args[4], line.Args[8])
+	conn.whoFlags(nk, flags)
+}
+
+// whoFlags updates a nick's state from the flags in a WHO or WHOX reply.
+func (conn *Conn) whoFlags(nk *state.Nick, flags string) {
+	if idx := strings.Index(flags, "*"); idx != -1 {
+		conn.st.NickModes(nk.Nick, "+o")
+	}
+	if idx := strings.Index(flags, "B"); idx != -1 {
+		conn.st.NickModes(nk.Nick, "+B")
+	}
+	if idx := strings.Index(flags, "H"); idx != -1 {
+		conn.st.NickModes(nk.Nick, "+i")
+	}
+	// The flags start with H for here or G for gone, i.e. away.
+	if strings.HasPrefix(flags, "H") {
+		conn.st.NickAway(nk.Nick, false, "")
+	} else if strings.HasPrefix(flags, "G") {
+		conn.st.NickAway(nk.Nick, true, nk.AwayMsg)
+	}
+}
+
+// whoxAccount converts the "0" WHOX uses for nicks that aren't logged in
+// to services to "".
+func whoxAccount(a string) string {
+	if a == "0" {
+		return ""
+	}
+	return account(a)
+}
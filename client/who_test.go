@@ -0,0 +1,76 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/state"
+	"github.com/golang/mock/gomock"
+)
+
+func TestWhoChannel(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	c.whoChannel("#test1")
+	s.nc.Expect("WHO #test1")
+
+	c.isupport.parse([]string{"WHOX"})
+	c.whoChannel("#test1")
+	s.nc.Expect("WHO #test1 %tcuhnfar,152")
+}
+
+func TestWhoChannelPacing(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.WhoInterval = 5 * time.Millisecond
+
+	c.whoChannel("#test1")
+	c.whoChannel("#test2")
+	c.whoChannel("#test3")
+	c.whoChannel("#test2")
+	s.nc.Expect("WHO #test1")
+	// The others shouldn't be sent until the interval has passed.
+	s.nc.ExpectNothing()
+	<-time.After(10 * time.Millisecond)
+	s.nc.Expect("WHO #test2")
+	<-time.After(10 * time.Millisecond)
+	s.nc.Expect("WHO #test3")
+	// The duplicate #test2 should have been dropped.
+	<-time.After(10 * time.Millisecond)
+	s.nc.ExpectNothing()
+}
+
+// Test the handler for 354 WHOX replies
+func Test354(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	nk := &state.Nick{Nick: "user1", AwayMsg: "gone"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nk),
+		s.st.EXPECT().NickAccount("user1", "acct"),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "Real Name"),
+		s.st.EXPECT().NickModes("user1", "+o"),
+		s.st.EXPECT().NickAway("user1", true, "gone"),
+	)
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 ident1 host1.com user1 G* acct :Real Name"))
+
+	// Not logged in, and ourselves.
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("test").Return(c.cfg.Me),
+		s.st.EXPECT().NickAccount("test", ""),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+	)
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 test somehost.com test H 0 :Testing IRC"))
+
+	// Replies to other WHOX queries are ignored.
+	c.h_354(ParseLine(":irc.server.org 354 test 1 #test1 ident1 host1.com user1 H acct :Real Name"))
+	c.h_354(ParseLine(":irc.server.org 354 test user1"))
+
+	// Check error paths -- unknown nick and missing args
+	s.st.EXPECT().GetNick("user2").Return(nil)
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 ident2 host2.com user2 H 0 :Real Name"))
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 ident2"))
+}
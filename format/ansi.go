@@ -0,0 +1,238 @@
+package format
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// ansiColours maps the 16 standard mIRC colours to the foreground codes of
+// the 16 ANSI colours. Background codes are 10 more.
+var ansiColours = [16]int{97, 30, 34, 32, 91, 31, 35, 33, 93, 92, 36, 96, 94, 95, 90, 37}
+
+// ToANSI converts text with formatting codes to text with ANSI escape
+// sequences, for displaying on a terminal. The 16 standard colours use
+// the terminal's 16 colours, which it may display quite differently; other
+// colours use 24-bit colour, which not all terminals support. Monospace
+// text is shown as is, since terminals only have monospace text.
+func ToANSI(text string) string {
+	var sb bytes.Buffer
+	var cur Style
+	for _, sp := range Parse(text) {
+		sb.WriteString(ansiTransition(cur, sp.Style))
+		sb.WriteString(sp.Text)
+		cur = sp.Style
+	}
+	// Monospace text has no escape sequence, so doesn't need turning off.
+	cur.Monospace = false
+	if cur != (Style{}) {
+		sb.WriteString("\x1b[0m")
+	}
+	return sb.String()
+}
+
+// ansiTransition returns the escape sequence changing the style from cur
+// to st.
+func ansiTransition(cur, st Style) string {
+	cur.Monospace, st.Monospace = false, false
+	if cur == st {
+		return ""
+	}
+	if st == (Style{}) {
+		return "\x1b[0m"
+	}
+	var params []string
+	for _, t := range []struct {
+		cur, new bool
+		on, off  string
+	}{
+		{cur.Bold, st.Bold, "1", "22"},
+		{cur.Italic, st.Italic, "3", "23"},
+		{cur.Underline, st.Underline, "4", "24"},
+		{cur.Reverse, st.Reverse, "7", "27"},
+		{cur.Strikethrough, st.Strikethrough, "9", "29"},
+	} {
+		if t.cur == t.new {
+			continue
+		}
+		if t.new {
+			params = append(params, t.on)
+		} else {
+			params = append(params, t.off)
+		}
+	}
+	if cur.Fg != st.Fg {
+		params = append(params, ansiColour(st.Fg, 0))
+	}
+	if cur.Bg != st.Bg {
+		params = append(params, ansiColour(st.Bg, 10))
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+// ansiColour returns the SGR parameters setting a foreground colour, or
+// a background colour if offset is 10.
+func ansiColour(c Colour, offset int) string {
+	if c == NoColour {
+		return strconv.Itoa(39 + offset)
+	}
+	if n, ok := c.Number(); ok && n < len(ansiColours) {
+		return strconv.Itoa(ansiColours[n] + offset)
+	}
+	r, g, b := c.RGB()
+	return strconv.Itoa(38+offset) + ";2;" + strconv.Itoa(int(r)) + ";" +
+		strconv.Itoa(int(g)) + ";" + strconv.Itoa(int(b))
+}
+
+// FromANSI converts text with ANSI escape sequences, e.g. the output of a
+// command, to text with formatting codes. The 16 ANSI colours map to the
+// 16 standard colours, and 256 and 24-bit colours to hex colours. Escape
+// sequences other than those setting the style are removed.
+func FromANSI(text string) string {
+	var spans []Span
+	var st Style
+	for {
+		idx := strings.Index(text, "\x1b")
+		if idx == -1 {
+			break
+		}
+		if idx > 0 {
+			spans = appendSpan(spans, Span{st, text[:idx]})
+		}
+		text = text[idx+1:]
+		if !strings.HasPrefix(text, "[") {
+			// Not a control sequence; drop the escape, any
+			// intermediate bytes and the final byte after it.
+			i := 0
+			for i < len(text) && text[i] >= 0x20 && text[i] <= 0x2f {
+				i++
+			}
+			if i < len(text) {
+				i++
+			}
+			text = text[i:]
+			continue
+		}
+		// A control sequence is "[", then parameters, and ends with a
+		// byte from @ to ~.
+		end := strings.IndexFunc(text[1:], func(r rune) bool {
+			return r >= '@' && r <= '~'
+		})
+		if end == -1 {
+			text = ""
+			break
+		}
+		if text[1+end] == 'm' {
+			st = st.applySGR(text[1 : 1+end])
+		}
+		text = text[2+end:]
+	}
+	if text != "" {
+		spans = appendSpan(spans, Span{st, text})
+	}
+	return Format(spans)
+}
+
+// applySGR returns the style after the SGR escape sequence with the given
+// parameters.
+func (st Style) applySGR(params string) Style {
+	var p []int
+	for _, s := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(s)
+		p = append(p, n)
+	}
+	for i := 0; i < len(p); i++ {
+		switch n := p[i]; {
+		case n == 0:
+			st = Style{}
+		case n == 1:
+			st.Bold = true
+		case n == 3:
+			st.Italic = true
+		case n == 4:
+			st.Underline = true
+		case n == 7:
+			st.Reverse = true
+		case n == 9:
+			st.Strikethrough = true
+		case n == 22:
+			st.Bold = false
+		case n == 23:
+			st.Italic = false
+		case n == 24:
+			st.Underline = false
+		case n == 27:
+			st.Reverse = false
+		case n == 29:
+			st.Strikethrough = false
+		case n >= 30 && n <= 37, n >= 90 && n <= 97:
+			st.Fg = fromANSI(n)
+		case n >= 40 && n <= 47, n >= 100 && n <= 107:
+			st.Bg = fromANSI(n - 10)
+		case n == 39:
+			st.Fg = NoColour
+		case n == 49:
+			st.Bg = NoColour
+		case n == 38, n == 48:
+			c, used := extendedColour(p[i+1:])
+			if n == 38 {
+				st.Fg = c
+			} else {
+				st.Bg = c
+			}
+			i += used
+		}
+	}
+	return st
+}
+
+// fromANSI returns the mIRC colour for an ANSI foreground colour code.
+func fromANSI(code int) Colour {
+	for n, c := range ansiColours {
+		if c == code {
+			return Number(n)
+		}
+	}
+	return NoColour
+}
+
+// extendedColour parses the parameters following 38 or 48 in an SGR escape
+// sequence, which are either 5;n for one of 256 colours or 2;r;g;b, and
+// returns the colour and how many parameters it used.
+func extendedColour(p []int) (Colour, int) {
+	switch {
+	case len(p) >= 2 && p[0] == 5:
+		return ansi256(p[1]), 2
+	case len(p) >= 4 && p[0] == 2:
+		return RGB(uint8(p[1]), uint8(p[2]), uint8(p[3])), 4
+	}
+	return NoColour, len(p)
+}
+
+// ansi256 returns the colour with the given number in the 256 colour
+// palette most terminals use.
+func ansi256(n int) Colour {
+	switch {
+	case n < 16:
+		// The first 16 are the standard colours, in ANSI order.
+		if n >= 8 {
+			return fromANSI(90 + n - 8)
+		}
+		return fromANSI(30 + n)
+	case n < 232:
+		// A 6x6x6 colour cube.
+		n -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return RGB(level(n/36), level(n/6%6), level(n%6))
+	case n < 256:
+		// A greyscale ramp.
+		v := uint8(8 + (n-232)*10)
+		return RGB(v, v, v)
+	}
+	return NoColour
+}
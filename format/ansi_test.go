@@ -0,0 +1,39 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestToANSI(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"plain", "plain"},
+		{"\x02bold\x02 plain", "\x1b[1mbold\x1b[0m plain"},
+		{"\x02\x1dbold italic\x02 italic", "\x1b[1;3mbold italic\x1b[22m italic\x1b[0m"},
+		{"\x0304,02red on blue\x03 \x0360ext", "\x1b[91;44mred on blue\x1b[0m \x1b[38;2;0;0;255mext\x1b[0m"},
+		{"\x11mono", "mono"},
+	}
+	for i, test := range tests {
+		if out := ToANSI(test.in); out != test.out {
+			t.Errorf("test %d: ToANSI(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestFromANSI(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"plain", "plain"},
+		{"\x1b[1mbold\x1b[0m plain", "\x02bold\x0f plain"},
+		{"\x1b[1;3mboth\x1b[22m italic\x1b[m", "\x02\x1dboth\x02 italic"},
+		{"\x1b[31;47mbrown on grey\x1b[39m default", "\x0305,15brown on grey\x0399,15 default"},
+		{"\x1b[38;5;196mred\x1b[38;2;1;2;3mrgb", "\x04FF0000red\x04010203rgb"},
+		// Other escape sequences are removed.
+		{"\x1b[2Jclear\x1b[1;1Hhome\x1b(Bx\x1b[", "clearhomex"},
+	}
+	for i, test := range tests {
+		if out := FromANSI(test.in); out != test.out {
+			t.Errorf("test %d: FromANSI(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
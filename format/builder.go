@@ -0,0 +1,68 @@
+package format
+
+import (
+	"fmt"
+)
+
+// A Builder builds text with formatting codes, for sending coloured or
+// otherwise styled messages. For example:
+//
+//     msg := format.NewBuilder().Bold("Warning: ").
+//         Colour(format.Red, format.NoColour, "disk full").String()
+//
+// Each piece of text is styled independently of the others, so they can
+// be added in any order without styles leaking from one to the next.
+type Builder struct {
+	spans []Span
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Styled adds text in the given style.
+func (b *Builder) Styled(st Style, text string) *Builder {
+	b.spans = appendSpan(b.spans, Span{st, text})
+	return b
+}
+
+// Text adds unstyled text.
+func (b *Builder) Text(text string) *Builder { return b.Styled(Style{}, text) }
+
+// Textf adds unstyled text formatted with fmt.Sprintf.
+func (b *Builder) Textf(format string, a ...interface{}) *Builder {
+	return b.Text(fmt.Sprintf(format, a...))
+}
+
+// Bold adds bold text.
+func (b *Builder) Bold(text string) *Builder { return b.Styled(Style{Bold: true}, text) }
+
+// Italic adds italic text.
+func (b *Builder) Italic(text string) *Builder { return b.Styled(Style{Italic: true}, text) }
+
+// Underline adds underlined text.
+func (b *Builder) Underline(text string) *Builder {
+	return b.Styled(Style{Underline: true}, text)
+}
+
+// Strikethrough adds struck through text.
+func (b *Builder) Strikethrough(text string) *Builder {
+	return b.Styled(Style{Strikethrough: true}, text)
+}
+
+// Monospace adds monospace text.
+func (b *Builder) Monospace(text string) *Builder {
+	return b.Styled(Style{Monospace: true}, text)
+}
+
+// Colour adds text with foreground and background colours, either of
+// which may be NoColour.
+func (b *Builder) Colour(fg, bg Colour, text string) *Builder {
+	return b.Styled(Style{Fg: fg, Bg: bg}, text)
+}
+
+// String returns the text with formatting codes.
+func (b *Builder) String() string {
+	return Format(b.spans)
+}
@@ -0,0 +1,25 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	s := NewBuilder().Bold("Warning: ").Colour(Red, NoColour, "disk").
+		Colour(Red, NoColour, " full").Textf(" (%d%%)", 99).
+		Italic("i").Underline("u").Strikethrough("s").Monospace("m").
+		Text("").String()
+	want := "\x02Warning: \x02\x0304disk full\x0f (99%)\x1di\x1d\x1fu\x1f\x1es\x1e\x11m"
+	if s != want {
+		t.Errorf("Builder built %q, want %q", s, want)
+	}
+	if s := NewBuilder().String(); s != "" {
+		t.Errorf("Empty builder built %q", s)
+	}
+	if s := NewBuilder().Colour(Number(4), NoColour, "Score: ").Bold("10").String(); Strip(s) != "Score: 10" {
+		t.Errorf("Colour then Bold built %q", s)
+	}
+	if s := NewBuilder().Styled(Style{Bold: true, Fg: Blue, Bg: Yellow}, "x").String(); s != "\x02\x0302,08x" {
+		t.Errorf("Styled built %q", s)
+	}
+}
@@ -0,0 +1,139 @@
+package format
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// A Colour is either one of mIRC's 99 numbered colours, or an RGB colour
+// from a hex colour code. The zero value is no colour at all.
+type Colour uint32
+
+const (
+	// NoColour means the client's default colour is used.
+	NoColour Colour = 0
+
+	// Colours set with RGB have this flag set, numbered colours have
+	// indexed set.
+	rgb     Colour = 1 << 24
+	indexed Colour = 1 << 25
+)
+
+// The 16 standard mIRC colours.
+const (
+	White Colour = indexed | iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+// Number returns mIRC colour n, from 0 to 98. Other numbers, including 99,
+// which some clients use to mean the default colour, return NoColour.
+func Number(n int) Colour {
+	if n < 0 || n >= len(palette) {
+		return NoColour
+	}
+	return indexed | Colour(n)
+}
+
+// RGB returns the colour with the given red, green and blue values.
+func RGB(r, g, b uint8) Colour {
+	return rgb | Colour(r)<<16 | Colour(g)<<8 | Colour(b)
+}
+
+// Number returns the colour's mIRC number, and false if it is an RGB
+// colour or NoColour.
+func (c Colour) Number() (int, bool) {
+	if c&indexed == 0 {
+		return 0, false
+	}
+	return int(c &^ indexed), true
+}
+
+// RGB returns the colour's red, green and blue values. mIRC colours are
+// converted using the palette most clients use.
+func (c Colour) RGB() (r, g, b uint8) {
+	v := uint32(c &^ rgb)
+	if n, ok := c.Number(); ok {
+		v = palette[n]
+	} else if c == NoColour {
+		return 0, 0, 0
+	}
+	return uint8(v >> 16), uint8(v >> 8), uint8(v)
+}
+
+// Hex returns the colour as RRGGBB.
+func (c Colour) Hex() string {
+	r, g, b := c.RGB()
+	return fmt.Sprintf("%02X%02X%02X", r, g, b)
+}
+
+func (c Colour) String() string {
+	if c == NoColour {
+		return "none"
+	}
+	if n, ok := c.Number(); ok {
+		return strconv.Itoa(n)
+	}
+	return "#" + c.Hex()
+}
+
+// parseHex parses an RRGGBB hex colour.
+func parseHex(s string) (Colour, bool) {
+	if len(s) != 6 {
+		return NoColour, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return NoColour, false
+	}
+	return rgb | Colour(v), true
+}
+
+// nearest returns the mIRC colour closest to c, for converting RGB colours
+// to formats that only support numbered colours.
+func nearest(c Colour, max int) int {
+	if n, ok := c.Number(); ok && n < max {
+		return n
+	}
+	r, g, b := c.RGB()
+	best, dist := 0, -1
+	for n := 0; n < max; n++ {
+		pr, pg, pb := Number(n).RGB()
+		dr, dg, db := int(r)-int(pr), int(g)-int(pg), int(b)-int(pb)
+		if d := dr*dr + dg*dg + db*db; dist < 0 || d < dist {
+			best, dist = n, d
+		}
+	}
+	return best
+}
+
+// palette holds the RGB values of the mIRC colours, from
+// https://modern.ircdocs.horse/formatting.html
+var palette = [99]uint32{
+	0xffffff, 0x000000, 0x00007f, 0x009300, 0xff0000, 0x7f0000, 0x9c009c, 0xfc7f00,
+	0xffff00, 0x00fc00, 0x009393, 0x00ffff, 0x0000fc, 0xff00ff, 0x7f7f7f, 0xd2d2d2,
+	0x470000, 0x472100, 0x474700, 0x324700, 0x004700, 0x00472c, 0x004747, 0x002747,
+	0x000047, 0x2e0047, 0x470047, 0x47002a, 0x740000, 0x743a00, 0x747400, 0x517400,
+	0x007400, 0x007449, 0x007474, 0x004074, 0x000074, 0x4b0074, 0x740074, 0x740045,
+	0xb50000, 0xb56300, 0xb5b500, 0x7db500, 0x00b500, 0x00b571, 0x00b5b5, 0x0063b5,
+	0x0000b5, 0x7500b5, 0xb500b5, 0xb5006b, 0xff0000, 0xff8c00, 0xffff00, 0xb2ff00,
+	0x00ff00, 0x00ffa0, 0x00ffff, 0x008cff, 0x0000ff, 0xa500ff, 0xff00ff, 0xff0098,
+	0xff5959, 0xffb459, 0xffff71, 0xcfff60, 0x6fff6f, 0x65ffc9, 0x6dffff, 0x59b4ff,
+	0x5959ff, 0xc459ff, 0xff66ff, 0xff59bc, 0xff9c9c, 0xffd39c, 0xffff9c, 0xe2ff9c,
+	0x9cff9c, 0x9cffdb, 0x9cffff, 0x9cd3ff, 0x9c9cff, 0xdc9cff, 0xff9cff, 0xff94d3,
+	0x000000, 0x131313, 0x282828, 0x363636, 0x4d4d4d, 0x656565, 0x818181, 0x9f9f9f,
+	0xbcbcbc, 0xe2e2e2, 0xffffff,
+}
@@ -0,0 +1,40 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestColour(t *testing.T) {
+	if n, ok := Red.Number(); !ok || n != 4 {
+		t.Errorf("Red.Number() = %d, %t", n, ok)
+	}
+	if Number(4) != Red || Number(99) != NoColour || Number(-1) != NoColour {
+		t.Errorf("Number() returned the wrong colours.")
+	}
+	if _, ok := RGB(1, 2, 3).Number(); ok {
+		t.Errorf("RGB colour has a number.")
+	}
+	if r, g, b := RGB(1, 2, 3).RGB(); r != 1 || g != 2 || b != 3 {
+		t.Errorf("RGB() = %d, %d, %d", r, g, b)
+	}
+	if hex := Orange.Hex(); hex != "FC7F00" {
+		t.Errorf("Orange.Hex() = %s", hex)
+	}
+	if hex := Number(98).Hex(); hex != "FFFFFF" {
+		t.Errorf("Number(98).Hex() = %s", hex)
+	}
+	for c, s := range map[Colour]string{NoColour: "none", Pink: "13", RGB(0, 0x80, 0xff): "#0080FF"} {
+		if c.String() != s {
+			t.Errorf("String() = %s, want %s", c.String(), s)
+		}
+	}
+	if c, ok := parseHex("00ff7f"); !ok || c != RGB(0, 0xff, 0x7f) {
+		t.Errorf("parseHex() = %v, %t", c, ok)
+	}
+	if _, ok := parseHex("00ff7"); ok {
+		t.Errorf("parseHex() accepted a short colour.")
+	}
+	if n := nearest(RGB(0xf0, 0x10, 0x10), 16); n != 4 {
+		t.Errorf("nearest() = %d", n)
+	}
+}
@@ -0,0 +1,306 @@
+// Package format parses, strips and builds the mIRC formatting codes IRC
+// clients use for bold, colours and the like, and converts them to and
+// from ANSI escape sequences for terminals, and to HTML.
+// https://modern.ircdocs.horse/formatting.html
+package format
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// The formatting codes. Most toggle a style on and off; the colour codes
+// are followed by the colours to use, and Reset turns everything off.
+const (
+	CodeBold          = '\x02'
+	CodeColour        = '\x03'
+	CodeHexColour     = '\x04'
+	CodeReset         = '\x0f'
+	CodeMonospace     = '\x11'
+	CodeReverse       = '\x16'
+	CodeItalic        = '\x1d'
+	CodeStrikethrough = '\x1e'
+	CodeUnderline     = '\x1f'
+)
+
+// A Style is the formatting applied to some text.
+type Style struct {
+	Bold, Italic, Underline, Strikethrough, Monospace, Reverse bool
+	// The foreground and background colours.
+	Fg, Bg Colour
+}
+
+// A Span is some text with the same style throughout.
+type Span struct {
+	Style
+	Text string
+}
+
+// Parse splits text into spans of differently styled text, without the
+// formatting codes.
+func Parse(text string) []Span {
+	var spans []Span
+	var st Style
+	start := 0
+	for i := 0; i < len(text); {
+		n := CodeLen(text[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		if i > start {
+			spans = appendSpan(spans, Span{st, text[start:i]})
+		}
+		st = st.apply(text[i : i+n])
+		i += n
+		start = i
+	}
+	if start < len(text) {
+		spans = appendSpan(spans, Span{st, text[start:]})
+	}
+	return spans
+}
+
+// appendSpan appends sp to spans, joining it on to the last span if they
+// have the same style.
+func appendSpan(spans []Span, sp Span) []Span {
+	if l := len(spans) - 1; l >= 0 && spans[l].Style == sp.Style {
+		spans[l].Text += sp.Text
+		return spans
+	}
+	return append(spans, sp)
+}
+
+// CodeLen returns the length of the formatting code at the start of s,
+// including any colours following a colour code, or 0 if s doesn't start
+// with one.
+func CodeLen(s string) int {
+	if s == "" {
+		return 0
+	}
+	switch s[0] {
+	case CodeBold, CodeReset, CodeMonospace, CodeReverse, CodeItalic,
+		CodeStrikethrough, CodeUnderline:
+		return 1
+	case CodeColour, CodeHexColour:
+		_, _, n := parseColours(s)
+		return n
+	}
+	return 0
+}
+
+// parseColours parses the colour code at the start of s, returning the
+// colours, which are empty if not given, and the length of the code.
+func parseColours(s string) (fg, bg string, n int) {
+	width, valid := 2, isDigit
+	if s[0] == CodeHexColour {
+		width, valid = 6, isHex
+	}
+	run := func(i int) int {
+		j := 0
+		for j < width && i+j < len(s) && valid(s[i+j]) {
+			j++
+		}
+		if width == 6 && j != 6 {
+			return 0
+		}
+		return j
+	}
+	n = 1
+	if l := run(n); l > 0 {
+		fg, n = s[n:n+l], n+l
+		if n+1 < len(s) && s[n] == ',' {
+			if l := run(n + 1); l > 0 {
+				bg, n = s[n+1:n+1+l], n+1+l
+			}
+		}
+	}
+	return fg, bg, n
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func isHex(b byte) bool {
+	return isDigit(b) || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F'
+}
+
+// After returns the style in effect at the end of text, if st was in
+// effect at its start.
+func (st Style) After(text string) Style {
+	for i := 0; i < len(text); i++ {
+		if n := CodeLen(text[i:]); n > 0 {
+			st = st.apply(text[i : i+n])
+			i += n - 1
+		}
+	}
+	return st
+}
+
+// apply returns the style after the formatting code.
+func (st Style) apply(code string) Style {
+	switch code[0] {
+	case CodeBold:
+		st.Bold = !st.Bold
+	case CodeItalic:
+		st.Italic = !st.Italic
+	case CodeUnderline:
+		st.Underline = !st.Underline
+	case CodeStrikethrough:
+		st.Strikethrough = !st.Strikethrough
+	case CodeMonospace:
+		st.Monospace = !st.Monospace
+	case CodeReverse:
+		st.Reverse = !st.Reverse
+	case CodeReset:
+		st = Style{}
+	case CodeColour, CodeHexColour:
+		fg, bg, _ := parseColours(code)
+		if fg == "" {
+			// A colour code on its own turns colours off.
+			st.Fg, st.Bg = NoColour, NoColour
+			break
+		}
+		st.Fg = colour(code[0], fg)
+		if bg != "" {
+			st.Bg = colour(code[0], bg)
+		}
+	}
+	return st
+}
+
+// colour returns the colour given in a colour code.
+func colour(code byte, s string) Colour {
+	if code == CodeHexColour {
+		c, _ := parseHex(s)
+		return c
+	}
+	n, _ := strconv.Atoi(s)
+	return Number(n)
+}
+
+// Strip returns text without any formatting codes.
+func Strip(text string) string {
+	if !strings.ContainsAny(text, "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f") {
+		return text
+	}
+	var sb bytes.Buffer
+	for _, sp := range Parse(text) {
+		sb.WriteString(sp.Text)
+	}
+	return sb.String()
+}
+
+// Format returns the spans as text with formatting codes, using as few
+// codes as it can.
+func Format(spans []Span) string {
+	var sb bytes.Buffer
+	var cur Style
+	for _, sp := range spans {
+		if sp.Text == "" {
+			continue
+		}
+		sb.WriteString(transition(cur, sp.Style, sp.Text))
+		sb.WriteString(sp.Text)
+		cur = sp.Style
+	}
+	return sb.String()
+}
+
+// transition returns the formatting codes that change the style from cur
+// to st, for text that follows them.
+func transition(cur, st Style, text string) string {
+	if st == (Style{}) {
+		if cur == (Style{}) {
+			return ""
+		}
+		return string(CodeReset)
+	}
+	s := ""
+	off := st.Fg == NoColour && st.Bg == NoColour
+	if off && (cur.Fg != NoColour || cur.Bg != NoColour) {
+		// Colours are turned off first, so any other codes that follow
+		// keep digits in the text from being taken for a colour.
+		s = string(CodeColour)
+	}
+	for _, t := range []struct {
+		code     byte
+		cur, new bool
+	}{
+		{CodeBold, cur.Bold, st.Bold},
+		{CodeItalic, cur.Italic, st.Italic},
+		{CodeUnderline, cur.Underline, st.Underline},
+		{CodeStrikethrough, cur.Strikethrough, st.Strikethrough},
+		{CodeMonospace, cur.Monospace, st.Monospace},
+		{CodeReverse, cur.Reverse, st.Reverse},
+	} {
+		if t.cur != t.new {
+			s += string(t.code)
+		}
+	}
+	if s == string(CodeColour) && len(text) > 0 && isDigit(text[0]) {
+		// Nothing else follows the code, so add a pair of codes that
+		// does nothing instead.
+		s += string(CodeBold) + string(CodeBold)
+	}
+	if !off && (cur.Fg != st.Fg || cur.Bg != st.Bg) {
+		if cur.Bg != NoColour && st.Bg == NoColour && st.Fg != NoColour {
+			// Setting the foreground alone keeps the background.
+			s += string(CodeColour)
+		}
+		s += colourCode(st.Fg, st.Bg, text)
+	}
+	return s
+}
+
+// colourCode returns the code setting the colours fg and bg, for text that
+// follows it. At least one of them must be a colour.
+func colourCode(fg, bg Colour, text string) string {
+	fn, fok := fg.Number()
+	bn, bok := bg.Number()
+	if fg == NoColour {
+		// There's no way to set only the background, so use the
+		// default colour, 99, for the foreground.
+		fn, fok = 99, true
+		if !bok {
+			bn, bok = nearest(bg, len(palette)), true
+		}
+	}
+	if !fok || (!bok && bg != NoColour) {
+		s := string(CodeHexColour) + fg.Hex()
+		if bg != NoColour {
+			s += "," + bg.Hex()
+		}
+		return s
+	}
+	s := string(CodeColour) + pad(fn)
+	if bg != NoColour {
+		s += "," + pad(bn)
+	} else if len(text) > 1 && text[0] == ',' && isDigit(text[1]) {
+		// Don't let the text be taken for a background colour.
+		s += ",99"
+	}
+	return s
+}
+
+// pad returns n as two digits, so the text that follows it isn't taken as
+// part of the colour.
+func pad(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// Codes returns the formatting codes that turn on the style, e.g. to
+// start a line that continues one that ended in that style.
+func (st Style) Codes() string {
+	return transition(Style{}, st, "")
+}
+
+// CodesFor is like Codes, for when the codes are followed by next. A
+// colour followed by a digit or a comma is written so that next isn't
+// taken as part of it.
+func (st Style) CodesFor(next string) string {
+	return transition(Style{}, st, next)
+}
@@ -0,0 +1,133 @@
+package format
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []Span
+	}{
+		{"", nil},
+		{"plain", []Span{{Style{}, "plain"}}},
+		{"\x02bold\x02 \x1ditalic\x1fboth", []Span{
+			{Style{Bold: true}, "bold"},
+			{Style{}, " "},
+			{Style{Italic: true}, "italic"},
+			{Style{Italic: true, Underline: true}, "both"},
+		}},
+		// Colours with one or two digits, and the background is kept
+		// when only the foreground changes.
+		{"\x034,12red on blue\x0305brown\x03none", []Span{
+			{Style{Fg: Red, Bg: LightBlue}, "red on blue"},
+			{Style{Fg: Brown, Bg: LightBlue}, "brown"},
+			{Style{}, "none"},
+		}},
+		// Only two digits are part of the colour, and a comma without a
+		// colour after it is text.
+		{"\x03040123\x034,text", []Span{
+			{Style{Fg: Red}, "0123,text"},
+		}},
+		{"\x04FF8000orange\x04,zz\x0f\x1e\x11\x16x", []Span{
+			{Style{Fg: RGB(0xff, 0x80, 0)}, "orange"},
+			{Style{}, ",zz"},
+			{Style{Strikethrough: true, Monospace: true, Reverse: true}, "x"},
+		}},
+		// Codes with no text in between are merged.
+		{"\x02\x02\x0304\x03text", []Span{{Style{}, "text"}}},
+	}
+	for i, test := range tests {
+		if out := Parse(test.in); !reflect.DeepEqual(out, test.out) {
+			t.Errorf("test %d: Parse(%q) =\n%v\nwant\n%v", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"\x02bold\x0f \x0304,01red\x03 \x04ff0000,00ff00hex \x1d\x1f\x1e\x11\x16", "bold red hex "},
+		{"\x0312,3", ""},
+	}
+	for i, test := range tests {
+		if out := Strip(test.in); out != test.out {
+			t.Errorf("test %d: Strip(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in  []Span
+		out string
+	}{
+		{nil, ""},
+		{[]Span{{Style{Bold: true}, "bold"}, {Style{}, " plain"}}, "\x02bold\x0f plain"},
+		{[]Span{{Style{Bold: true}, "a"}, {Style{Bold: true, Italic: true}, "b"},
+			{Style{Italic: true}, "c"}}, "\x02a\x1db\x02c"},
+		// Colours are padded so digits in the text aren't taken for
+		// them, and commas get a background.
+		{[]Span{{Style{Fg: Red}, "1"}, {Style{Fg: Blue}, ",2"}}, "\x03041\x0302,99,2"},
+		{[]Span{{Style{Fg: Red, Bg: Blue}, "a"}, {Style{Fg: Red}, "b"}},
+			"\x0304,02a\x03\x0304b"},
+		{[]Span{{Style{Bg: Blue}, "a"}}, "\x0399,02a"},
+		// Nor are digits after colours are turned off.
+		{[]Span{{Style{Fg: Red}, "a"}, {Style{Bold: true}, "1"}}, "\x0304a\x03\x021"},
+		{[]Span{{Style{Fg: Red, Bold: true}, "a"}, {Style{Bold: true}, "1"}},
+			"\x02\x0304a\x03\x02\x021"},
+		{[]Span{{Style{Fg: RGB(1, 2, 3), Bg: RGB(255, 0, 0)}, "a"}}, "\x04010203,FF0000a"},
+	}
+	for i, test := range tests {
+		out := Format(test.in)
+		if out != test.out {
+			t.Errorf("test %d: Format() = %q, want %q", i, out, test.out)
+		}
+		// Parsing the output should get us back where we started,
+		// less any empty spans.
+		if spans := Parse(out); len(test.in) > 0 && !reflect.DeepEqual(spans, test.in) {
+			t.Errorf("test %d: Parse(Format()) = %v", i, spans)
+		}
+	}
+}
+
+func TestStyleAfter(t *testing.T) {
+	st := Style{Bold: true}.After("\x1dfoo\x0304,01bar\x0305")
+	if st != (Style{Bold: true, Italic: true, Fg: Brown, Bg: Black}) {
+		t.Errorf("After() = %v", st)
+	}
+	if codes := st.Codes(); codes != "\x02\x1d\x0305,01" {
+		t.Errorf("Codes() = %q", codes)
+	}
+	if codes := (Style{}).Codes(); codes != "" {
+		t.Errorf("Codes() of no style = %q", codes)
+	}
+	if codes := st.CodesFor(",5"); codes != "\x02\x1d\x0305,01" {
+		t.Errorf("CodesFor() = %q", codes)
+	}
+	if codes := (Style{Fg: Red}).CodesFor(",5"); codes != "\x0304,99" {
+		t.Errorf("CodesFor() of fg only = %q", codes)
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	// Formatting parsed text should keep the text, whatever codes it had.
+	in := []string{"\x03021\x0f\x113", "\x0304,05a\x03,1\x02\x032"}
+	r := rand.New(rand.NewSource(1))
+	chars := "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f,0123456789abcdefABCDEF "
+	for i := 0; i < 10000; i++ {
+		b := make([]byte, r.Intn(16))
+		for j := range b {
+			b[j] = chars[r.Intn(len(chars))]
+		}
+		in = append(in, string(b))
+	}
+	for _, s := range in {
+		if out := Format(Parse(s)); Strip(out) != Strip(s) {
+			t.Errorf("Format(Parse(%q)) = %q, strips to %q not %q", s, out, Strip(out), Strip(s))
+		}
+	}
+}
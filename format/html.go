@@ -0,0 +1,53 @@
+package format
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// ToHTML converts text with formatting codes to HTML, escaping the text.
+// Each span of styled text is wrapped in the elements for its style, with
+// colours set by a span element's style attribute.
+func ToHTML(text string) string {
+	var sb bytes.Buffer
+	for _, sp := range Parse(text) {
+		st := sp.Style
+		fg, bg := st.Fg, st.Bg
+		if st.Reverse {
+			// Without knowing the page's colours, reversing the
+			// default colours can only be approximated.
+			if fg == NoColour {
+				fg = Black
+			}
+			if bg == NoColour {
+				bg = White
+			}
+			fg, bg = bg, fg
+		}
+		var open, close []string
+		wrap := func(on bool, tag, attrs string) {
+			if on {
+				open = append(open, "<"+tag+attrs+">")
+				close = append([]string{"</" + tag + ">"}, close...)
+			}
+		}
+		var css []string
+		if fg != NoColour {
+			css = append(css, "color:#"+strings.ToLower(fg.Hex()))
+		}
+		if bg != NoColour {
+			css = append(css, "background-color:#"+strings.ToLower(bg.Hex()))
+		}
+		wrap(len(css) > 0, "span", ` style="`+strings.Join(css, ";")+`"`)
+		wrap(st.Bold, "b", "")
+		wrap(st.Italic, "i", "")
+		wrap(st.Underline, "u", "")
+		wrap(st.Strikethrough, "s", "")
+		wrap(st.Monospace, "code", "")
+		sb.WriteString(strings.Join(open, ""))
+		sb.WriteString(html.EscapeString(sp.Text))
+		sb.WriteString(strings.Join(close, ""))
+	}
+	return sb.String()
+}
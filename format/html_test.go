@@ -0,0 +1,22 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"<plain> & text", "&lt;plain&gt; &amp; text"},
+		{"\x02\x1dbold italic\x02 italic", "<b><i>bold italic</i></b><i> italic</i>"},
+		{"\x1f\x1e\x11x", "<u><s><code>x</code></s></u>"},
+		{"\x0304,01red\x0f", `<span style="color:#ff0000;background-color:#000000">red</span>`},
+		{"\x16rev", `<span style="color:#ffffff;background-color:#000000">rev</span>`},
+		{"\x02\x04ff8000o", `<span style="color:#ff8000"><b>o</b></span>`},
+	}
+	for i, test := range tests {
+		if out := ToHTML(test.in); out != test.out {
+			t.Errorf("test %d: ToHTML(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package mask
+
+import (
+	"strings"
+)
+
+// A CaseMapping defines which characters IRC considers to be the upper and
+// lower case versions of each other when comparing nicks and channels.
+// Servers advertise theirs with the CASEMAPPING ISUPPORT token.
+type CaseMapping int
+
+const (
+	// RFC1459 is the default casemapping, in which {}|~ are the lower case
+	// versions of []\^ as well as a-z being the lower case of A-Z.
+	RFC1459 CaseMapping = iota
+	// StrictRFC1459 is RFC1459 without ~ and ^.
+	StrictRFC1459
+	// ASCII only considers a-z to be the lower case versions of A-Z.
+	ASCII
+)
+
+// ParseCaseMapping returns the CaseMapping with the name used for it in
+// the CASEMAPPING ISUPPORT token. Unknown names map to RFC1459.
+func ParseCaseMapping(name string) CaseMapping {
+	switch strings.ToLower(name) {
+	case "ascii":
+		return ASCII
+	case "strict-rfc1459":
+		return StrictRFC1459
+	}
+	return RFC1459
+}
+
+func (cm CaseMapping) String() string {
+	switch cm {
+	case ASCII:
+		return "ascii"
+	case StrictRFC1459:
+		return "strict-rfc1459"
+	}
+	return "rfc1459"
+}
+
+// LowerByte returns the lower case version of b.
+func (cm CaseMapping) LowerByte(b byte) byte {
+	switch {
+	case b >= 'A' && b <= 'Z':
+		return b + 'a' - 'A'
+	case cm == ASCII:
+		return b
+	case b == '[' || b == ']' || b == '\\':
+		return b + '{' - '['
+	case b == '^' && cm == RFC1459:
+		return '~'
+	}
+	return b
+}
+
+// Lower returns s with every character converted to lower case.
+func (cm CaseMapping) Lower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if cm.LowerByte(s[i]) != s[i] {
+			b := []byte(s)
+			for ; i < len(b); i++ {
+				b[i] = cm.LowerByte(b[i])
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+// Equal returns true if a and b are the same, ignoring case.
+func (cm CaseMapping) Equal(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if cm.LowerByte(a[i]) != cm.LowerByte(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Glob returns true if s matches pattern, ignoring case, where "*" in
+// pattern matches zero or more characters and "?" matches exactly one.
+func (cm CaseMapping) Glob(pattern, s string) bool {
+	// Iterative matching with single-star backtracking, which is
+	// sufficient because each "*" can only ever need to grow.
+	p, i, star, mark := 0, 0, -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' ||
+			cm.LowerByte(pattern[p]) == cm.LowerByte(s[i])):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star != -1:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,71 @@
+package mask
+
+import (
+	"testing"
+)
+
+func TestCaseMapping(t *testing.T) {
+	for _, name := range []string{"ascii", "rfc1459", "strict-rfc1459"} {
+		if cm := ParseCaseMapping(name); cm.String() != name {
+			t.Errorf("ParseCaseMapping(%q) = %s", name, cm)
+		}
+	}
+	if cm := ParseCaseMapping("rfc7613"); cm != RFC1459 {
+		t.Errorf("Unknown casemapping didn't default to rfc1459: %s", cm)
+	}
+
+	tests := []struct {
+		cm        CaseMapping
+		in, lower string
+	}{
+		{ASCII, "Nick[]\\^", "nick[]\\^"},
+		{RFC1459, "Nick[]\\^", "nick{}|~"},
+		{StrictRFC1459, "Nick[]\\^", "nick{}|^"},
+		{RFC1459, "already{lower}", "already{lower}"},
+	}
+	for i, test := range tests {
+		if l := test.cm.Lower(test.in); l != test.lower {
+			t.Errorf("%d: %s.Lower(%q) = %q, expected %q", i, test.cm,
+				test.in, l, test.lower)
+		}
+		if !test.cm.Equal(test.in, test.lower) {
+			t.Errorf("%d: %s.Equal(%q, %q) = false", i, test.cm,
+				test.in, test.lower)
+		}
+	}
+	if ASCII.Equal("nick[a]", "nick{a}") || RFC1459.Equal("nick", "nicks") {
+		t.Errorf("Equal matched different strings.")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"*", "anything!at@all", true},
+		{"?", "", false},
+		{"?", "a", true},
+		{"a*b", "ab", true},
+		{"a*b", "axxxb", true},
+		{"a*b", "axxxbc", false},
+		{"*!*@staff/*", "nick!user@staff/fluffle", true},
+		{"*!*@staff/*", "nick!user@users/fluffle", false},
+		{"*!*@STAFF/*", "Nick!User@staff/Fluffle", true},
+		{"n?ck!*@*.com", "nick!u@host.com", true},
+		{"n?ck!*@*.com", "nck!u@host.com", false},
+		{"*a*a*a", "aaaa", true},
+		{"*a*a*a", "abab", false},
+		{"[away]*", "{AWAY}nick", true},
+	}
+	for i, test := range tests {
+		if m := RFC1459.Glob(test.pattern, test.s); m != test.match {
+			t.Errorf("test %d: Glob(%q, %q) = %t", i, test.pattern, test.s, m)
+		}
+	}
+	if ASCII.Glob("[away]*", "{away}nick") {
+		t.Errorf("ASCII casemapping folded brackets.")
+	}
+}
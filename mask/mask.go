@@ -0,0 +1,208 @@
+// Package mask parses, matches and generates the nick!ident@host masks IRC
+// uses for bans, ignore lists and the like, using IRC's casemapping rules.
+package mask
+
+import (
+	"net"
+	"strings"
+)
+
+// A Mask is a nick!ident@host pattern, in which "*" matches zero or more
+// characters and "?" matches exactly one. The host may also be a CIDR
+// range like 192.0.2.0/24, which matches hosts that are IPs in the range.
+type Mask struct {
+	Nick, Ident, Host string
+}
+
+// Split splits the source of an IRC message into its nick, ident and host,
+// without normalising it. A source that is neither nick!ident@host nor
+// nick@host is the name of a server, and is returned as the host.
+func Split(src string) (nick, ident, host string) {
+	at := strings.LastIndex(src, "@")
+	if at == -1 {
+		if idx := strings.Index(src, "!"); idx != -1 {
+			return src[:idx], src[idx+1:], ""
+		}
+		return "", "", src
+	}
+	nick, host = src[:at], src[at+1:]
+	if idx := strings.Index(nick, "!"); idx != -1 {
+		nick, ident = nick[:idx], nick[idx+1:]
+	}
+	return
+}
+
+// Parse normalises a possibly incomplete mask the same way servers do when
+// setting bans, filling in the missing parts with "*":
+//
+//     nick          => nick!*@*
+//     nick!ident    => nick!ident@*
+//     ident@host    => *!ident@host
+//     host.name     => *!*@host.name
+func Parse(s string) Mask {
+	var m Mask
+	switch bang, at := strings.Index(s, "!"), strings.LastIndex(s, "@"); {
+	case bang != -1 && at > bang:
+		m = Mask{s[:bang], s[bang+1 : at], s[at+1:]}
+	case bang != -1:
+		m = Mask{s[:bang], s[bang+1:], ""}
+	case at != -1:
+		m = Mask{"", s[:at], s[at+1:]}
+	case strings.ContainsAny(s, ".:/"):
+		m = Mask{Host: s}
+	default:
+		m = Mask{Nick: s}
+	}
+	if m.Nick == "" {
+		m.Nick = "*"
+	}
+	if m.Ident == "" {
+		m.Ident = "*"
+	}
+	if m.Host == "" {
+		m.Host = "*"
+	}
+	return m
+}
+
+func (m Mask) String() string {
+	return m.Nick + "!" + m.Ident + "@" + m.Host
+}
+
+// Matches returns true if u matches the mask, ignoring case according
+// to cm.
+func (m Mask) Matches(cm CaseMapping, u User) bool {
+	if !cm.Glob(m.Nick, u.Nick) || !cm.Glob(m.Ident, u.Ident) {
+		return false
+	}
+	if strings.Contains(m.Host, "/") {
+		if _, ipnet, err := net.ParseCIDR(m.Host); err == nil {
+			ip := net.ParseIP(u.Host)
+			return ip != nil && ipnet.Contains(ip)
+		}
+	}
+	return cm.Glob(m.Host, u.Host)
+}
+
+// A User is someone on IRC that masks can be matched against.
+type User struct {
+	Nick, Ident, Host string
+	// The services account the user is logged in to, or "" if none
+	// or unknown.
+	Account string
+}
+
+func (u User) String() string {
+	return u.Nick + "!" + u.Ident + "@" + u.Host
+}
+
+// A Matcher matches masks against users using a server's casemapping, and
+// understands its extended bans.
+type Matcher struct {
+	CaseMapping CaseMapping
+	// The prefix of extended bans and the types supported, from the
+	// EXTBAN ISUPPORT token, e.g. "$" and "ajrx".
+	ExtBanPrefix, ExtBanTypes string
+}
+
+// NewMatcher returns a Matcher for a server that sent the given values for
+// the CASEMAPPING and EXTBAN ISUPPORT tokens, either of which may be empty.
+func NewMatcher(casemapping, extban string) *Matcher {
+	mt := &Matcher{CaseMapping: ParseCaseMapping(casemapping)}
+	if idx := strings.Index(extban, ","); idx != -1 {
+		mt.ExtBanPrefix, mt.ExtBanTypes = extban[:idx], extban[idx+1:]
+	}
+	return mt
+}
+
+// HasExtBan returns true if the server supports extended bans of type t.
+func (mt *Matcher) HasExtBan(t byte) bool {
+	return mt.ExtBanPrefix != "" && strings.IndexByte(mt.ExtBanTypes, t) != -1
+}
+
+// extBan splits an extended ban into its type, whether it is negated, and
+// its argument, returning false if pattern isn't an extended ban.
+func (mt *Matcher) extBan(pattern string) (t byte, negate bool, arg string, ok bool) {
+	if mt.ExtBanPrefix == "" || !strings.HasPrefix(pattern, mt.ExtBanPrefix) {
+		return 0, false, "", false
+	}
+	s := pattern[len(mt.ExtBanPrefix):]
+	if strings.HasPrefix(s, "~") && mt.ExtBanPrefix != "~" {
+		negate, s = true, s[1:]
+	}
+	if s == "" {
+		return 0, false, "", false
+	}
+	t, s = s[0], s[1:]
+	if strings.HasPrefix(s, ":") {
+		arg = s[1:]
+	} else if s != "" {
+		return 0, false, "", false
+	}
+	return t, negate, arg, true
+}
+
+// Match returns true if u matches pattern, which is normalised with Parse
+// unless it is an extended ban. Only account extended bans are understood,
+// like $a:account, which matches users logged in to that account, or $a,
+// which matches any user that is logged in. Other extended bans never
+// match.
+func (mt *Matcher) Match(pattern string, u User) bool {
+	t, negate, arg, ok := mt.extBan(pattern)
+	if !ok {
+		return Parse(pattern).Matches(mt.CaseMapping, u)
+	}
+	if t != 'a' || !mt.HasExtBan(t) {
+		return false
+	}
+	m := u.Account != "" && (arg == "" || mt.CaseMapping.Glob(arg, u.Account))
+	return m != negate
+}
+
+// Equal returns true if a and b are the same, ignoring case.
+func (mt *Matcher) Equal(a, b string) bool {
+	return mt.CaseMapping.Equal(a, b)
+}
+
+// AccountBan returns an extended ban matching users logged in to the
+// account, and false if the server doesn't support them.
+func (mt *Matcher) AccountBan(account string) (string, bool) {
+	if account == "" || !mt.HasExtBan('a') {
+		return "", false
+	}
+	return mt.ExtBanPrefix + "a:" + account, true
+}
+
+// BanMask returns a mask that matches u, and is likely to keep matching
+// them when they reconnect while matching as few other people as possible.
+// The nick is always wildcarded. IPv4 addresses and cloaked hosts are
+// matched exactly, IPv6 addresses by their /64. Hostnames whose first
+// label contains digits are assumed to be dynamically assigned, so the
+// first label is wildcarded and the ident is kept to narrow the mask.
+func BanMask(u User) string {
+	m := Mask{Nick: "*", Ident: "*", Host: u.Host}
+	if ip := net.ParseIP(u.Host); ip != nil {
+		if ip.To4() == nil {
+			m.Host = (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)),
+				Mask: net.CIDRMask(64, 128)}).String()
+		}
+		return m.String()
+	}
+	if u.Host == "" || strings.Contains(u.Host, "/") {
+		// Cloaks like user/fluffle identify someone exactly.
+		if u.Host == "" {
+			m.Host = "*"
+		}
+		return m.String()
+	}
+	labels := strings.SplitN(u.Host, ".", 2)
+	if len(labels) == 2 && strings.Contains(labels[1], ".") &&
+		strings.ContainsAny(labels[0], "0123456789") {
+		m.Host = "*." + labels[1]
+		if ident := strings.TrimLeft(u.Ident, "~"); ident != "" {
+			// Unidented users may have any ident starting with ~.
+			m.Ident = "*" + ident
+		}
+	}
+	return m.String()
+}
@@ -0,0 +1,122 @@
+package mask
+
+import (
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		src, nick, ident, host string
+	}{
+		{"nick!ident@host.com", "nick", "ident", "host.com"},
+		{"nick@host.com", "nick", "", "host.com"},
+		{"nick!ident", "nick", "ident", ""},
+		{"irc.server.org", "", "", "irc.server.org"},
+		{"", "", "", ""},
+	}
+	for _, test := range tests {
+		n, i, h := Split(test.src)
+		if n != test.nick || i != test.ident || h != test.host {
+			t.Errorf("Split(%q) = %q, %q, %q", test.src, n, i, h)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"nick", "nick!*@*"},
+		{"nick!ident", "nick!ident@*"},
+		{"ident@host.com", "*!ident@host.com"},
+		{"host.com", "*!*@host.com"},
+		{"192.0.2.0/24", "*!*@192.0.2.0/24"},
+		{"nick!ident@host.com", "nick!ident@host.com"},
+		{"!@", "*!*@*"},
+		{"", "*!*@*"},
+	}
+	for _, test := range tests {
+		if m := Parse(test.in).String(); m != test.out {
+			t.Errorf("Parse(%q) = %q, expected %q", test.in, m, test.out)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	u := User{Nick: "Nick[a]", Ident: "~ident", Host: "192.0.2.42",
+		Account: "Acct"}
+	v6 := User{Nick: "six", Ident: "six", Host: "2001:db8::1"}
+	cloaked := User{Nick: "fluffle", Ident: "fluffle", Host: "staff/fluffle"}
+	mt := NewMatcher("rfc1459", "$,arx")
+	tests := []struct {
+		pattern string
+		u       User
+		match   bool
+	}{
+		{"nick{a}", u, true},
+		{"*!~ident@*", u, true},
+		{"*!*@192.0.2.*", u, true},
+		{"*!*@192.0.2.0/24", u, true},
+		{"*!*@192.0.3.0/24", u, false},
+		{"*!*@2001:db8::/32", v6, true},
+		{"*!*@2001:db8::/32", u, false},
+		{"staff/*", cloaked, true},
+		{"*!*@192.0.2.0/24", cloaked, false},
+		{"$a:acct", u, true},
+		{"$a:a*", u, true},
+		{"$a:other", u, false},
+		{"$a", u, true},
+		{"$a", cloaked, false},
+		{"$~a", cloaked, true},
+		{"$~a", u, false},
+		{"$r:*", u, false},
+		{"$j:#chan", u, false},
+	}
+	for i, test := range tests {
+		if m := mt.Match(test.pattern, test.u); m != test.match {
+			t.Errorf("%d: Match(%q, %s) = %t", i, test.pattern, test.u, m)
+		}
+	}
+
+	// Without EXTBAN, $a is just a nick.
+	mt = NewMatcher("ascii", "")
+	if mt.Match("$a:acct", u) || !mt.Match("$a", User{Nick: "$A"}) {
+		t.Errorf("Extended ban matched without EXTBAN support.")
+	}
+	if mt.Match("nick{a}", u) {
+		t.Errorf("ASCII casemapping folded brackets.")
+	}
+}
+
+func TestAccountBan(t *testing.T) {
+	if b, ok := NewMatcher("", "~,qjncrRa").AccountBan("acct"); !ok || b != "~a:acct" {
+		t.Errorf("AccountBan = %q, %t", b, ok)
+	}
+	if _, ok := NewMatcher("", "~,qjncr").AccountBan("acct"); ok {
+		t.Errorf("AccountBan succeeded without account extban support.")
+	}
+	if _, ok := NewMatcher("", "$,a").AccountBan(""); ok {
+		t.Errorf("AccountBan succeeded without an account.")
+	}
+}
+
+func TestBanMask(t *testing.T) {
+	tests := []struct {
+		u    User
+		mask string
+	}{
+		{User{"n", "i", "192.0.2.42", ""}, "*!*@192.0.2.42"},
+		{User{"n", "i", "2001:db8:1:2:3:4:5:6", ""}, "*!*@2001:db8:1:2::/64"},
+		{User{"n", "i", "staff/fluffle", ""}, "*!*@staff/fluffle"},
+		{User{"n", "i", "host.example.com", ""}, "*!*@host.example.com"},
+		{User{"n", "~i", "cpe-192-0-2-42.isp.net", ""}, "*!*i@*.isp.net"},
+		{User{"n", "~", "dyn42.isp.net", ""}, "*!*@*.isp.net"},
+		{User{"n", "i", "host42.net", ""}, "*!*@host42.net"},
+		{User{"n", "i", "", ""}, "*!*@*"},
+	}
+	for _, test := range tests {
+		if m := BanMask(test.u); m != test.mask {
+			t.Errorf("BanMask(%s) = %q, expected %q", test.u, m, test.mask)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package state
+
+// ChangeKind identifies the kind of mutation a Change describes.
+type ChangeKind int
+
+const (
+	// Nick has been associated with Channel.
+	NickJoinedChannel ChangeKind = iota + 1
+	// Nick has been dissociated from Channel. This is emitted for every
+	// channel a nick was on when it is deleted, but only for our own nick
+	// when we leave a channel and stop tracking it.
+	NickLeftChannel
+	// Old has changed nick to New, which is also in Nick.
+	NickRenamed
+	// Nick's user modes changed from Old to New.
+	NickModeChanged
+	// Nick's away message changed from Old to New. Either may be empty
+	// because the nick is not away or because the message is unknown,
+	// so check the nick's Away field to find out whether they are away.
+	NickAwayChanged
+	// Nick logged in to, or out of, the services account in Old and New.
+	NickAccountChanged
+	// Nick's ident@host changed from Old to New, e.g. by CHGHOST.
+	NickHostChanged
+	// Nick's real name changed from Old to New, e.g. by SETNAME.
+	NickRealnameChanged
+	// Nick's privileges on Channel changed from Old to New.
+	PrivilegeChanged
+	// Channel's topic changed from Old to New.
+	TopicChanged
+	// Channel's modes changed from Old to New.
+	ChannelModeChanged
+)
+
+var changeKindNames = map[ChangeKind]string{
+	NickJoinedChannel:   "NickJoinedChannel",
+	NickLeftChannel:     "NickLeftChannel",
+	NickRenamed:         "NickRenamed",
+	NickModeChanged:     "NickModeChanged",
+	NickAwayChanged:     "NickAwayChanged",
+	NickAccountChanged:  "NickAccountChanged",
+	NickHostChanged:     "NickHostChanged",
+	NickRealnameChanged: "NickRealnameChanged",
+	PrivilegeChanged:    "PrivilegeChanged",
+	TopicChanged:        "TopicChanged",
+	ChannelModeChanged:  "ChannelModeChanged",
+}
+
+func (k ChangeKind) String() string {
+	if s, ok := changeKindNames[k]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// A Change describes a single mutation of the tracker's state. Modes and
+// privileges in Old and New are formatted like "+ov", or "" if none are set.
+type Change struct {
+	Kind          ChangeKind
+	Channel, Nick string
+	Old, New      string
+}
+
+// OnChange sets a function to be called with each change to the tracker's
+// state, after the mutation that caused it has completed. It is called in
+// the goroutine that made the mutation, with the tracker unlocked, so it
+// may safely query the tracker. Wipe does not emit changes.
+func (st *stateTracker) OnChange(f func(*Change)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.notify = f
+}
+
+// changed records a change to be emitted once st.mu is released.
+func (st *stateTracker) changed(kind ChangeKind, c, n, old, neu string) {
+	// st.mu lock held by the caller, which must release it with unlock.
+	if st.notify == nil {
+		return
+	}
+	st.pending = append(st.pending, &Change{
+		Kind: kind, Channel: c, Nick: n, Old: old, New: neu})
+}
+
+// unlock releases st.mu, then emits any changes recorded while it was held.
+func (st *stateTracker) unlock() {
+	pending, notify := st.pending, st.notify
+	st.pending = nil
+	st.mu.Unlock()
+	for _, c := range pending {
+		notify(c)
+	}
+}
+
+// modeString returns the result of a String method on ChanMode, ChanPrivs
+// or NickMode, without the placeholder for no modes being set.
+func modeString(s string) string {
+	if s == "No modes set" {
+		return ""
+	}
+	return s
+}
@@ -258,6 +258,36 @@ func (cm *ChanMode) Equals(other *ChanMode) bool {
 	return reflect.DeepEqual(cm, other)
 }
 
+// Returns whether the mode m is set, and its parameter if it takes one.
+// Returns false for known if ChanMode doesn't track m.
+func (cm *ChanMode) Get(m byte) (set bool, arg string, known bool) {
+	name, ok := StringToChanMode[string(m)]
+	if !ok {
+		return false, "", false
+	}
+	switch f := reflect.Indirect(reflect.ValueOf(cm)).FieldByName(name); f.Kind() {
+	case reflect.Bool:
+		return f.Bool(), "", true
+	case reflect.String:
+		return f.String() != "", f.String(), true
+	case reflect.Int:
+		if f.Int() != 0 {
+			return true, strconv.FormatInt(f.Int(), 10), true
+		}
+	}
+	return false, "", true
+}
+
+// Returns whether the privilege mode m, e.g. 'o', is set. Returns false
+// for known if m isn't a privilege mode.
+func (cp *ChanPrivs) Has(m byte) (set, known bool) {
+	name, ok := StringToChanPriv[string(m)]
+	if !ok {
+		return false, false
+	}
+	return reflect.Indirect(reflect.ValueOf(cp)).FieldByName(name).Bool(), true
+}
+
 // Duplicates a ChanPrivs struct.
 func (cp *ChanPrivs) Copy() *ChanPrivs {
 	if cp == nil { return nil }
@@ -348,3 +378,126 @@ func (cp *ChanPrivs) String() string {
 	}
 	return str
 }
+
+// Collects the modes removed and added by a diff, so that all the removals
+// come first. This matters for modes like +k, which most servers won't let
+// us change without unsetting it first.
+type modeDiff struct {
+	minus, plus         string
+	minusArgs, plusArgs []string
+}
+
+func (d *modeDiff) unset(m string, arg ...string) {
+	d.minus += m
+	d.minusArgs = append(d.minusArgs, arg...)
+}
+
+func (d *modeDiff) set(m string, arg ...string) {
+	d.plus += m
+	d.plusArgs = append(d.plusArgs, arg...)
+}
+
+func (d *modeDiff) result() (string, []string) {
+	modes := ""
+	if d.minus != "" {
+		modes += "-" + d.minus
+	}
+	if d.plus != "" {
+		modes += "+" + d.plus
+	}
+	return modes, append(d.minusArgs, d.plusArgs...)
+}
+
+// Returns the mode string and arguments that change the modes in cm to
+// those in want, in the form Tracker.ChannelModes takes them, e.g.
+//	"-k+mk", []string{"newkey"}
+// The mode string is empty if the modes are the same.
+func (cm *ChanMode) Diff(want *ChanMode) (string, []string) {
+	if cm.Equals(want) {
+		return "", nil
+	}
+	d := &modeDiff{}
+	have, neu := reflect.Indirect(reflect.ValueOf(cm)), reflect.Indirect(reflect.ValueOf(want))
+	t := have.Type()
+	for i := 0; i < have.NumField(); i++ {
+		m := ChanModeToString[t.Field(i).Name]
+		switch h, w := have.Field(i), neu.Field(i); h.Kind() {
+		case reflect.Bool:
+			if h.Bool() && !w.Bool() {
+				d.unset(m)
+			} else if !h.Bool() && w.Bool() {
+				d.set(m)
+			}
+		case reflect.String:
+			if h.String() == w.String() {
+				continue
+			}
+			if h.String() != "" {
+				// Like String, the parameter of an unset key is left
+				// out; the tracker doesn't expect one.
+				d.unset(m)
+			}
+			if w.String() != "" {
+				d.set(m, w.String())
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if h.Int() == w.Int() {
+				continue
+			}
+			if w.Int() == 0 {
+				d.unset(m)
+			} else {
+				d.set(m, strconv.FormatInt(w.Int(), 10))
+			}
+		}
+	}
+	return d.result()
+}
+
+// Returns the mode string and arguments that change the privileges in cp
+// for nick to those in want, e.g.
+//	"-v+o", []string{"nick", "nick"}
+// The mode string is empty if the privileges are the same.
+func (cp *ChanPrivs) Diff(want *ChanPrivs, nick string) (string, []string) {
+	if cp.Equals(want) {
+		return "", nil
+	}
+	d := &modeDiff{}
+	have, neu := reflect.Indirect(reflect.ValueOf(cp)), reflect.Indirect(reflect.ValueOf(want))
+	t := have.Type()
+	for i := 0; i < have.NumField(); i++ {
+		m := ChanPrivToString[t.Field(i).Name]
+		if h, w := have.Field(i).Bool(), neu.Field(i).Bool(); h && !w {
+			d.unset(m, nick)
+		} else if !h && w {
+			d.set(m, nick)
+		}
+	}
+	return d.result()
+}
+
+// Returns the mode string and arguments that change the entries of the
+// list mode m, e.g. 'b' for bans, from have to want. The tracker doesn't
+// keep track of list modes, so the current entries must come from the
+// server, e.g. from the replies to MODE #channel +b.
+func ListDiff(m byte, have, want []string) (string, []string) {
+	d := &modeDiff{}
+	wanted := make(map[string]bool)
+	for _, w := range want {
+		wanted[w] = true
+	}
+	had := make(map[string]bool)
+	for _, h := range have {
+		if !wanted[h] && !had[h] {
+			d.unset(string(m), h)
+		}
+		had[h] = true
+	}
+	for _, w := range want {
+		if !had[w] {
+			d.set(string(m), w)
+			had[w] = true
+		}
+	}
+	return d.result()
+}
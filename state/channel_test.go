@@ -174,3 +174,106 @@ func TestChannelParseModes(t *testing.T) {
 		t.Errorf("Channel privileges not flipped correctly by ParseModes (2).")
 	}
 }
+
+func TestChanModeGet(t *testing.T) {
+	cm := &ChanMode{Moderated: true, Key: "key", Limit: 10}
+	tests := []struct {
+		m     byte
+		set   bool
+		arg   string
+		known bool
+	}{
+		{'m', true, "", true},
+		{'n', false, "", true},
+		{'k', true, "key", true},
+		{'l', true, "10", true},
+		{'b', false, "", false},
+	}
+	for _, test := range tests {
+		set, arg, known := cm.Get(test.m)
+		if set != test.set || arg != test.arg || known != test.known {
+			t.Errorf("Get(%c) = %t, %q, %t", test.m, set, arg, known)
+		}
+	}
+	if set, _, _ := new(ChanMode).Get('l'); set {
+		t.Errorf("Get(l) with no limit returned set.")
+	}
+
+	cp := &ChanPrivs{Op: true}
+	if set, known := cp.Has('o'); !set || !known {
+		t.Errorf("Has(o) = %t, %t", set, known)
+	}
+	if set, known := cp.Has('v'); set || !known {
+		t.Errorf("Has(v) = %t, %t", set, known)
+	}
+	if _, known := cp.Has('m'); known {
+		t.Errorf("Has(m) returned known.")
+	}
+}
+
+func TestChanModeDiff(t *testing.T) {
+	tests := []struct {
+		have, want *ChanMode
+		modes      string
+		args       []string
+	}{
+		{&ChanMode{}, &ChanMode{}, "", nil},
+		{&ChanMode{Secret: true, Moderated: true},
+			&ChanMode{Secret: true, NoExternalMsg: true},
+			"-m+n", nil},
+		{&ChanMode{}, &ChanMode{Key: "key", Limit: 10},
+			"+kl", []string{"key", "10"}},
+		{&ChanMode{Key: "old", Limit: 10}, &ChanMode{Key: "new", Limit: 20},
+			"-k+kl", []string{"new", "20"}},
+		{&ChanMode{Key: "key", Limit: 10, InviteOnly: true}, &ChanMode{},
+			"-ikl", nil},
+	}
+	for i, test := range tests {
+		modes, args := test.have.Diff(test.want)
+		if modes != test.modes || !equalArgs(args, test.args) {
+			t.Errorf("%d: Diff() = %q, %q; want %q, %q",
+				i, modes, args, test.modes, test.args)
+		}
+		// Applying the diff should get us what we wanted.
+		ch := newChannel("#test1")
+		ch.modes = test.have.Copy()
+		ch.parseModes(modes, args...)
+		if !ch.modes.Equals(test.want) {
+			t.Errorf("%d: applying Diff() got %s, want %s", i, ch.modes, test.want)
+		}
+	}
+}
+
+func TestChanPrivsDiff(t *testing.T) {
+	have, want := &ChanPrivs{Op: true, Voice: true}, &ChanPrivs{Op: true, Admin: true}
+	modes, args := have.Diff(want, "nick")
+	if modes != "-v+a" || !equalArgs(args, []string{"nick", "nick"}) {
+		t.Errorf("Diff() = %q, %q", modes, args)
+	}
+	if modes, args := want.Diff(want, "nick"); modes != "" || args != nil {
+		t.Errorf("Diff() of equal privs = %q, %q", modes, args)
+	}
+}
+
+func TestListDiff(t *testing.T) {
+	modes, args := ListDiff('b', []string{"a!*@*", "b!*@*", "b!*@*"},
+		[]string{"b!*@*", "c!*@*", "c!*@*"})
+	if modes != "-b+b" || !equalArgs(args, []string{"a!*@*", "c!*@*"}) {
+		t.Errorf("ListDiff() = %q, %q", modes, args)
+	}
+	if modes, args := ListDiff('b', nil, nil); modes != "" || len(args) != 0 {
+		t.Errorf("ListDiff() of empty lists = %q, %q", modes, args)
+	}
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
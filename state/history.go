@@ -0,0 +1,209 @@
+package state
+
+import (
+	"time"
+)
+
+// histPrune is how often AddMessage removes expired messages from all the
+// history, rather than only that of the channel or conversation a message
+// is added to, so conversations that have ended don't linger.
+const histPrune = time.Minute
+
+// A Message is a PRIVMSG, NOTICE or CTCP ACTION kept in the tracker's
+// message history.
+type Message struct {
+	Time time.Time
+	// "PRIVMSG", "NOTICE" or "ACTION".
+	Kind string
+	// Who sent the message, as they were known when it was sent; older
+	// messages are not changed when the sender changes nick.
+	Nick, Ident, Host string
+	// The channel or nick the message was sent to.
+	Target string
+	Text   string
+	// The message's ID from the IRCv3 msgid tag, if the server sent one.
+	MsgID string
+}
+
+// history is a ring buffer of the most recent messages for a channel or
+// private conversation, in the order they were sent.
+type history struct {
+	msgs     []Message
+	start, n int
+}
+
+func newHistory(size int) *history {
+	return &history{msgs: make([]Message, size)}
+}
+
+// at returns the i'th oldest message.
+func (h *history) at(i int) *Message {
+	return &h.msgs[(h.start+i)%len(h.msgs)]
+}
+
+// add inserts a message in order of time. Messages usually arrive in
+// order, but those fetched from the server with CHATHISTORY may be older
+// than some already kept, and may duplicate them.
+func (h *history) add(m *Message) {
+	i := h.n
+	for i > 0 && h.at(i-1).Time.After(m.Time) {
+		i--
+	}
+	if m.MsgID != "" {
+		for j := 0; j < h.n; j++ {
+			if h.at(j).MsgID == m.MsgID {
+				return
+			}
+		}
+	}
+	if h.n == len(h.msgs) {
+		if i == 0 {
+			// Older than everything kept.
+			return
+		}
+		// Full, so drop the oldest message.
+		*h.at(0) = Message{}
+		h.start = (h.start + 1) % len(h.msgs)
+		h.n--
+		i--
+	}
+	for j := h.n; j > i; j-- {
+		*h.at(j) = *h.at(j - 1)
+	}
+	*h.at(i) = *m
+	h.n++
+}
+
+// expire removes messages sent before t.
+func (h *history) expire(t time.Time) {
+	for h.n > 0 && h.msgs[h.start].Time.Before(t) {
+		h.msgs[h.start] = Message{}
+		h.start = (h.start + 1) % len(h.msgs)
+		h.n--
+	}
+}
+
+// last returns copies of the most recent n messages, or all of them if
+// n <= 0, oldest first.
+func (h *history) last(n int) []*Message {
+	if n <= 0 || n > h.n {
+		n = h.n
+	}
+	msgs := make([]*Message, n)
+	for i := range msgs {
+		m := *h.at(h.n - n + i)
+		msgs[i] = &m
+	}
+	return msgs
+}
+
+// resize returns a history with room for size messages, containing the
+// most recent messages from h.
+func (h *history) resize(size int) *history {
+	r := newHistory(size)
+	for _, m := range h.last(size) {
+		r.add(m)
+	}
+	return r
+}
+
+// SetHistory sets the number of messages kept for each channel and private
+// conversation, and the maximum age of messages kept, or zero for no limit.
+// A size of zero, the default, turns off message history and discards any
+// messages kept so far.
+func (st *stateTracker) SetHistory(size int, maxAge time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if size <= 0 {
+		st.history, st.histSize, st.histAge = nil, 0, 0
+		return
+	}
+	if st.history == nil {
+		st.history = make(map[string]*history)
+	}
+	if size != st.histSize {
+		for k, h := range st.history {
+			st.history[k] = h.resize(size)
+		}
+	}
+	st.histSize, st.histAge = size, maxAge
+}
+
+// AddMessage adds a message to the history of the channel it was sent to,
+// if that channel is tracked. Otherwise, if it was sent to us or by us,
+// it is added to the history of our conversation with the other nick.
+// History for a channel is discarded when we leave it, and history for
+// a conversation follows the other nick when they change nick and is
+// discarded when they are deleted, e.g. because they quit. Channels and
+// conversations with no messages left once old ones expire are discarded
+// too. Wiping the tracker keeps the history, so it can be brought up to
+// date after reconnecting.
+func (st *stateTracker) AddMessage(m *Message) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.history == nil {
+		return
+	}
+	var key string
+	if _, ok := st.chans[m.Target]; ok {
+		key = m.Target
+	} else if m.Target == st.me.nick {
+		key = m.Nick
+	} else if m.Nick == st.me.nick {
+		key = m.Target
+	} else {
+		return
+	}
+	c := *m
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	h, ok := st.history[key]
+	if !ok {
+		h = newHistory(st.histSize)
+		st.history[key] = h
+	}
+	h.add(&c)
+	if st.histAge == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(st.pruned) >= histPrune {
+		st.prune(now)
+		return
+	}
+	if h.expire(now.Add(-st.histAge)); h.n == 0 {
+		delete(st.history, key)
+	}
+}
+
+// prune removes expired messages from all the history, and discards the
+// history of channels and conversations with none left.
+func (st *stateTracker) prune(now time.Time) {
+	for k, h := range st.history {
+		if h.expire(now.Add(-st.histAge)); h.n == 0 {
+			delete(st.history, k)
+		}
+	}
+	st.pruned = now
+}
+
+// History returns up to n of the most recent messages sent to a channel or
+// in a private conversation with a nick, oldest first. If n <= 0, all of
+// the messages kept are returned.
+func (st *stateTracker) History(target string, n int) []*Message {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h, ok := st.history[target]
+	if !ok {
+		return nil
+	}
+	if st.histAge > 0 {
+		h.expire(time.Now().Add(-st.histAge))
+	}
+	if h.n == 0 {
+		delete(st.history, target)
+		return nil
+	}
+	return h.last(n)
+}
@@ -0,0 +1,136 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func texts(msgs []*Message) []string {
+	s := make([]string, len(msgs))
+	for i, m := range msgs {
+		s[i] = m.Text
+	}
+	return s
+}
+
+func TestSTHistory(t *testing.T) {
+	st := NewTracker("mynick")
+	st.NewChannel("#test1")
+	st.NewNick("test1")
+	st.Associate("#test1", "mynick")
+	st.Associate("#test1", "test1")
+
+	msg := func(nick, target, text string) *Message {
+		return &Message{Kind: "PRIVMSG", Nick: nick, Target: target, Text: text}
+	}
+
+	// History is off by default.
+	st.AddMessage(msg("test1", "#test1", "one"))
+	if h := st.History("#test1", 0); h != nil {
+		t.Errorf("History kept before being enabled: %v", texts(h))
+	}
+
+	st.SetHistory(3, 0)
+	for _, s := range []string{"one", "two", "three", "four"} {
+		st.AddMessage(msg("test1", "#test1", s))
+	}
+	if h := texts(st.History("#test1", 0)); len(h) != 3 ||
+		h[0] != "two" || h[1] != "three" || h[2] != "four" {
+		t.Errorf("Channel history incorrect: %v", h)
+	}
+	if h := texts(st.History("#test1", 2)); len(h) != 2 ||
+		h[0] != "three" || h[1] != "four" {
+		t.Errorf("Last two messages incorrect: %v", h)
+	}
+	if m := st.History("#test1", 1)[0]; m.Time.IsZero() {
+		t.Errorf("Message time not set when added.")
+	}
+
+	// Messages to untracked channels aren't kept, private ones are kept
+	// under the nick of the other party, whoever sent them.
+	st.AddMessage(msg("test1", "#test2", "elsewhere"))
+	st.AddMessage(msg("test1", "mynick", "hello"))
+	st.AddMessage(msg("mynick", "test1", "hi"))
+	if h := st.History("#test2", 0); h != nil {
+		t.Errorf("History kept for untracked channel: %v", texts(h))
+	}
+	if h := texts(st.History("test1", 0)); len(h) != 2 ||
+		h[0] != "hello" || h[1] != "hi" {
+		t.Errorf("Private history incorrect: %v", h)
+	}
+
+	// Shrinking the history keeps the newest messages.
+	st.SetHistory(1, 0)
+	if h := texts(st.History("#test1", 0)); len(h) != 1 || h[0] != "four" {
+		t.Errorf("History incorrect after resize: %v", h)
+	}
+
+	// Private history follows renames, channel history is dropped on part.
+	st.ReNick("test1", "test2")
+	if h := st.History("test1", 0); h != nil {
+		t.Errorf("Private history not moved on rename: %v", texts(h))
+	}
+	if h := texts(st.History("test2", 0)); len(h) != 1 || h[0] != "hi" {
+		t.Errorf("Private history incorrect after rename: %v", h)
+	}
+	st.Dissociate("#test1", "mynick")
+	if h := st.History("#test1", 0); h != nil {
+		t.Errorf("Channel history kept after parting: %v", texts(h))
+	}
+	if h := st.History("test2", 0); len(h) != 1 {
+		t.Errorf("Private history dropped after parting: %v", texts(h))
+	}
+
+	// Old messages expire.
+	st.SetHistory(5, time.Minute)
+	st.AddMessage(&Message{Kind: "NOTICE", Nick: "test3", Target: "mynick",
+		Time: time.Now().Add(-2 * time.Minute), Text: "old"})
+	if h := st.History("test3", 0); h != nil {
+		t.Errorf("Expired messages kept: %v", texts(h))
+	}
+
+	// Messages are kept in order of time, without duplicate msgids.
+	now := time.Now()
+	for i, s := range []string{"b", "d", "a", "c", "d"} {
+		m := msg("test4", "mynick", s)
+		m.MsgID = s
+		m.Time = now.Add(time.Duration(s[0]-'a') * time.Second)
+		if i == 4 {
+			m.Text = "duplicate"
+		}
+		st.AddMessage(m)
+	}
+	if h := texts(st.History("test4", 0)); len(h) != 4 ||
+		h[0] != "a" || h[1] != "b" || h[2] != "c" || h[3] != "d" {
+		t.Errorf("History not in order: %v", h)
+	}
+
+	// Conversations are discarded when the nick quits, even if we're not
+	// in any channels with them, or once all their messages expire.
+	st.AddMessage(msg("test5", "mynick", "bye"))
+	st.DelNick("test5")
+	if _, ok := st.history["test5"]; ok {
+		t.Errorf("Private history kept after quit.")
+	}
+	for h, i := st.history["test4"], 0; i < h.n; i++ {
+		h.at(i).Time = now.Add(-2 * time.Minute)
+	}
+	st.pruned = time.Time{}
+	st.AddMessage(msg("test6", "mynick", "hello"))
+	if _, ok := st.history["test4"]; ok {
+		t.Errorf("Expired private history kept.")
+	}
+	if h := st.History("test6", 0); len(h) != 1 {
+		t.Errorf("Private history incorrect after pruning: %v", texts(h))
+	}
+
+	// Wiping the tracker keeps history, turning history off discards it.
+	st.Wipe()
+	if h := st.History("test2", 0); len(h) != 1 {
+		t.Errorf("History discarded by wipe: %v", texts(h))
+	}
+	st.SetHistory(0, 0)
+	if h := st.History("test2", 0); h != nil {
+		t.Errorf("History kept after being disabled: %v", texts(h))
+	}
+}
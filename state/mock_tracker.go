@@ -1,201 +1,425 @@
-// Automatically generated by MockGen. DO NOT EDIT!
+// Code generated by MockGen. DO NOT EDIT.
 // Source: tracker.go
 
+// Package state is a generated GoMock package.
 package state
 
 import (
+	reflect "reflect"
+	time "time"
+
 	gomock "github.com/golang/mock/gomock"
 )
 
-// Mock of Tracker interface
+// MockTracker is a mock of Tracker interface.
 type MockTracker struct {
 	ctrl     *gomock.Controller
-	recorder *_MockTrackerRecorder
+	recorder *MockTrackerMockRecorder
 }
 
-// Recorder for MockTracker (not exported)
-type _MockTrackerRecorder struct {
+// MockTrackerMockRecorder is the mock recorder for MockTracker.
+type MockTrackerMockRecorder struct {
 	mock *MockTracker
 }
 
+// NewMockTracker creates a new mock instance.
 func NewMockTracker(ctrl *gomock.Controller) *MockTracker {
 	mock := &MockTracker{ctrl: ctrl}
-	mock.recorder = &_MockTrackerRecorder{mock}
+	mock.recorder = &MockTrackerMockRecorder{mock}
 	return mock
 }
 
-func (_m *MockTracker) EXPECT() *_MockTrackerRecorder {
-	return _m.recorder
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTracker) EXPECT() *MockTrackerMockRecorder {
+	return m.recorder
 }
 
-func (_m *MockTracker) NewNick(nick string) *Nick {
-	ret := _m.ctrl.Call(_m, "NewNick", nick)
-	ret0, _ := ret[0].(*Nick)
+// AddMessage mocks base method.
+func (m_2 *MockTracker) AddMessage(m *Message) {
+	m_2.ctrl.T.Helper()
+	m_2.ctrl.Call(m_2, "AddMessage", m)
+}
+
+// AddMessage indicates an expected call of AddMessage.
+func (mr *MockTrackerMockRecorder) AddMessage(m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMessage", reflect.TypeOf((*MockTracker)(nil).AddMessage), m)
+}
+
+// Associate mocks base method.
+func (m *MockTracker) Associate(channel, nick string) *ChanPrivs {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Associate", channel, nick)
+	ret0, _ := ret[0].(*ChanPrivs)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) NewNick(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "NewNick", arg0)
+// Associate indicates an expected call of Associate.
+func (mr *MockTrackerMockRecorder) Associate(channel, nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Associate", reflect.TypeOf((*MockTracker)(nil).Associate), channel, nick)
 }
 
-func (_m *MockTracker) GetNick(nick string) *Nick {
-	ret := _m.ctrl.Call(_m, "GetNick", nick)
+// ChannelModes mocks base method.
+func (m *MockTracker) ChannelModes(channel, modestr string, modeargs ...string) *Channel {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{channel, modestr}
+	for _, a := range modeargs {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ChannelModes", varargs...)
+	ret0, _ := ret[0].(*Channel)
+	return ret0
+}
+
+// ChannelModes indicates an expected call of ChannelModes.
+func (mr *MockTrackerMockRecorder) ChannelModes(channel, modestr interface{}, modeargs ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{channel, modestr}, modeargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChannelModes", reflect.TypeOf((*MockTracker)(nil).ChannelModes), varargs...)
+}
+
+// DelChannel mocks base method.
+func (m *MockTracker) DelChannel(channel string) *Channel {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DelChannel", channel)
+	ret0, _ := ret[0].(*Channel)
+	return ret0
+}
+
+// DelChannel indicates an expected call of DelChannel.
+func (mr *MockTrackerMockRecorder) DelChannel(channel interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelChannel", reflect.TypeOf((*MockTracker)(nil).DelChannel), channel)
+}
+
+// DelNick mocks base method.
+func (m *MockTracker) DelNick(nick string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DelNick", nick)
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) GetNick(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetNick", arg0)
+// DelNick indicates an expected call of DelNick.
+func (mr *MockTrackerMockRecorder) DelNick(nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DelNick", reflect.TypeOf((*MockTracker)(nil).DelNick), nick)
 }
 
-func (_m *MockTracker) ReNick(old string, neu string) *Nick {
-	ret := _m.ctrl.Call(_m, "ReNick", old, neu)
+// Dissociate mocks base method.
+func (m *MockTracker) Dissociate(channel, nick string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Dissociate", channel, nick)
+}
+
+// Dissociate indicates an expected call of Dissociate.
+func (mr *MockTrackerMockRecorder) Dissociate(channel, nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dissociate", reflect.TypeOf((*MockTracker)(nil).Dissociate), channel, nick)
+}
+
+// GetChannel mocks base method.
+func (m *MockTracker) GetChannel(channel string) *Channel {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChannel", channel)
+	ret0, _ := ret[0].(*Channel)
+	return ret0
+}
+
+// GetChannel indicates an expected call of GetChannel.
+func (mr *MockTrackerMockRecorder) GetChannel(channel interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChannel", reflect.TypeOf((*MockTracker)(nil).GetChannel), channel)
+}
+
+// GetNick mocks base method.
+func (m *MockTracker) GetNick(nick string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNick", nick)
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) ReNick(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReNick", arg0, arg1)
+// GetNick indicates an expected call of GetNick.
+func (mr *MockTrackerMockRecorder) GetNick(nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNick", reflect.TypeOf((*MockTracker)(nil).GetNick), nick)
+}
+
+// History mocks base method.
+func (m *MockTracker) History(target string, n int) []*Message {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", target, n)
+	ret0, _ := ret[0].([]*Message)
+	return ret0
+}
+
+// History indicates an expected call of History.
+func (mr *MockTrackerMockRecorder) History(target, n interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockTracker)(nil).History), target, n)
+}
+
+// IsOn mocks base method.
+func (m *MockTracker) IsOn(channel, nick string) (*ChanPrivs, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsOn", channel, nick)
+	ret0, _ := ret[0].(*ChanPrivs)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
 }
 
-func (_m *MockTracker) DelNick(nick string) *Nick {
-	ret := _m.ctrl.Call(_m, "DelNick", nick)
+// IsOn indicates an expected call of IsOn.
+func (mr *MockTrackerMockRecorder) IsOn(channel, nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsOn", reflect.TypeOf((*MockTracker)(nil).IsOn), channel, nick)
+}
+
+// Me mocks base method.
+func (m *MockTracker) Me() *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Me")
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) DelNick(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DelNick", arg0)
+// Me indicates an expected call of Me.
+func (mr *MockTrackerMockRecorder) Me() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Me", reflect.TypeOf((*MockTracker)(nil).Me))
+}
+
+// NewChannel mocks base method.
+func (m *MockTracker) NewChannel(channel string) *Channel {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewChannel", channel)
+	ret0, _ := ret[0].(*Channel)
+	return ret0
+}
+
+// NewChannel indicates an expected call of NewChannel.
+func (mr *MockTrackerMockRecorder) NewChannel(channel interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewChannel", reflect.TypeOf((*MockTracker)(nil).NewChannel), channel)
 }
 
-func (_m *MockTracker) NickInfo(nick string, ident string, host string, name string) *Nick {
-	ret := _m.ctrl.Call(_m, "NickInfo", nick, ident, host, name)
+// NewNick mocks base method.
+func (m *MockTracker) NewNick(nick string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewNick", nick)
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) NickInfo(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "NickInfo", arg0, arg1, arg2, arg3)
+// NewNick indicates an expected call of NewNick.
+func (mr *MockTrackerMockRecorder) NewNick(nick interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewNick", reflect.TypeOf((*MockTracker)(nil).NewNick), nick)
 }
 
-func (_m *MockTracker) NickModes(nick string, modestr string) *Nick {
-	ret := _m.ctrl.Call(_m, "NickModes", nick, modestr)
+// NickAccount mocks base method.
+func (m *MockTracker) NickAccount(nick, account string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickAccount", nick, account)
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) NickModes(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "NickModes", arg0, arg1)
+// NickAccount indicates an expected call of NickAccount.
+func (mr *MockTrackerMockRecorder) NickAccount(nick, account interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickAccount", reflect.TypeOf((*MockTracker)(nil).NickAccount), nick, account)
 }
 
-func (_m *MockTracker) NewChannel(channel string) *Channel {
-	ret := _m.ctrl.Call(_m, "NewChannel", channel)
-	ret0, _ := ret[0].(*Channel)
+// NickActive mocks base method.
+func (m *MockTracker) NickActive(nick string, t time.Time) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickActive", nick, t)
+	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) NewChannel(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "NewChannel", arg0)
+// NickActive indicates an expected call of NickActive.
+func (mr *MockTrackerMockRecorder) NickActive(nick, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickActive", reflect.TypeOf((*MockTracker)(nil).NickActive), nick, t)
 }
 
-func (_m *MockTracker) GetChannel(channel string) *Channel {
-	ret := _m.ctrl.Call(_m, "GetChannel", channel)
-	ret0, _ := ret[0].(*Channel)
+// NickAway mocks base method.
+func (m *MockTracker) NickAway(nick string, away bool, msg string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickAway", nick, away, msg)
+	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) GetChannel(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetChannel", arg0)
+// NickAway indicates an expected call of NickAway.
+func (mr *MockTrackerMockRecorder) NickAway(nick, away, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickAway", reflect.TypeOf((*MockTracker)(nil).NickAway), nick, away, msg)
 }
 
-func (_m *MockTracker) DelChannel(channel string) *Channel {
-	ret := _m.ctrl.Call(_m, "DelChannel", channel)
-	ret0, _ := ret[0].(*Channel)
+// NickHost mocks base method.
+func (m *MockTracker) NickHost(nick, ident, host string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickHost", nick, ident, host)
+	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) DelChannel(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DelChannel", arg0)
+// NickHost indicates an expected call of NickHost.
+func (mr *MockTrackerMockRecorder) NickHost(nick, ident, host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickHost", reflect.TypeOf((*MockTracker)(nil).NickHost), nick, ident, host)
 }
 
-func (_m *MockTracker) Topic(channel string, topic string) *Channel {
-	ret := _m.ctrl.Call(_m, "Topic", channel, topic)
-	ret0, _ := ret[0].(*Channel)
+// NickInfo mocks base method.
+func (m *MockTracker) NickInfo(nick, ident, host, name string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickInfo", nick, ident, host, name)
+	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) Topic(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Topic", arg0, arg1)
+// NickInfo indicates an expected call of NickInfo.
+func (mr *MockTrackerMockRecorder) NickInfo(nick, ident, host, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickInfo", reflect.TypeOf((*MockTracker)(nil).NickInfo), nick, ident, host, name)
 }
 
-func (_m *MockTracker) ChannelModes(channel string, modestr string, modeargs ...string) *Channel {
-	_s := []interface{}{channel, modestr}
-	for _, _x := range modeargs {
-		_s = append(_s, _x)
-	}
-	ret := _m.ctrl.Call(_m, "ChannelModes", _s...)
-	ret0, _ := ret[0].(*Channel)
+// NickModes mocks base method.
+func (m *MockTracker) NickModes(nick, modestr string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickModes", nick, modestr)
+	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) ChannelModes(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
-	_s := append([]interface{}{arg0, arg1}, arg2...)
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "ChannelModes", _s...)
+// NickModes indicates an expected call of NickModes.
+func (mr *MockTrackerMockRecorder) NickModes(nick, modestr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickModes", reflect.TypeOf((*MockTracker)(nil).NickModes), nick, modestr)
 }
 
-func (_m *MockTracker) Me() *Nick {
-	ret := _m.ctrl.Call(_m, "Me")
+// NickRealname mocks base method.
+func (m *MockTracker) NickRealname(nick, name string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NickRealname", nick, name)
 	ret0, _ := ret[0].(*Nick)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) Me() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Me")
+// NickRealname indicates an expected call of NickRealname.
+func (mr *MockTrackerMockRecorder) NickRealname(nick, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NickRealname", reflect.TypeOf((*MockTracker)(nil).NickRealname), nick, name)
 }
 
-func (_m *MockTracker) IsOn(channel string, nick string) (*ChanPrivs, bool) {
-	ret := _m.ctrl.Call(_m, "IsOn", channel, nick)
-	ret0, _ := ret[0].(*ChanPrivs)
-	ret1, _ := ret[1].(bool)
-	return ret0, ret1
+// ReNick mocks base method.
+func (m *MockTracker) ReNick(old, neu string) *Nick {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReNick", old, neu)
+	ret0, _ := ret[0].(*Nick)
+	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) IsOn(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsOn", arg0, arg1)
+// ReNick indicates an expected call of ReNick.
+func (mr *MockTrackerMockRecorder) ReNick(old, neu interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReNick", reflect.TypeOf((*MockTracker)(nil).ReNick), old, neu)
 }
 
-func (_m *MockTracker) Associate(channel string, nick string) *ChanPrivs {
-	ret := _m.ctrl.Call(_m, "Associate", channel, nick)
-	ret0, _ := ret[0].(*ChanPrivs)
+// Restore mocks base method.
+func (m *MockTracker) Restore(s *Snapshot) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", s)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) Associate(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Associate", arg0, arg1)
+// Restore indicates an expected call of Restore.
+func (mr *MockTrackerMockRecorder) Restore(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockTracker)(nil).Restore), s)
 }
 
-func (_m *MockTracker) Dissociate(channel string, nick string) {
-	_m.ctrl.Call(_m, "Dissociate", channel, nick)
+// SetHistory mocks base method.
+func (m *MockTracker) SetHistory(size int, maxAge time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetHistory", size, maxAge)
 }
 
-func (_mr *_MockTrackerRecorder) Dissociate(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Dissociate", arg0, arg1)
+// SetHistory indicates an expected call of SetHistory.
+func (mr *MockTrackerMockRecorder) SetHistory(size, maxAge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHistory", reflect.TypeOf((*MockTracker)(nil).SetHistory), size, maxAge)
 }
 
-func (_m *MockTracker) Wipe() {
-	_m.ctrl.Call(_m, "Wipe")
+// Snapshot mocks base method.
+func (m *MockTracker) Snapshot() *Snapshot {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Snapshot")
+	ret0, _ := ret[0].(*Snapshot)
+	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) Wipe() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Wipe")
+// Snapshot indicates an expected call of Snapshot.
+func (mr *MockTrackerMockRecorder) Snapshot() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Snapshot", reflect.TypeOf((*MockTracker)(nil).Snapshot))
 }
 
-func (_m *MockTracker) String() string {
-	ret := _m.ctrl.Call(_m, "String")
+// String mocks base method.
+func (m *MockTracker) String() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "String")
 	ret0, _ := ret[0].(string)
 	return ret0
 }
 
-func (_mr *_MockTrackerRecorder) String() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "String")
+// String indicates an expected call of String.
+func (mr *MockTrackerMockRecorder) String() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "String", reflect.TypeOf((*MockTracker)(nil).String))
+}
+
+// Topic mocks base method.
+func (m *MockTracker) Topic(channel, topic string) *Channel {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Topic", channel, topic)
+	ret0, _ := ret[0].(*Channel)
+	return ret0
+}
+
+// Topic indicates an expected call of Topic.
+func (mr *MockTrackerMockRecorder) Topic(channel, topic interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Topic", reflect.TypeOf((*MockTracker)(nil).Topic), channel, topic)
+}
+
+// Verify mocks base method.
+func (m *MockTracker) Verify() []*Inconsistency {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify")
+	ret0, _ := ret[0].([]*Inconsistency)
+	return ret0
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockTrackerMockRecorder) Verify() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockTracker)(nil).Verify))
+}
+
+// Wipe mocks base method.
+func (m *MockTracker) Wipe() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Wipe")
+}
+
+// Wipe indicates an expected call of Wipe.
+func (mr *MockTrackerMockRecorder) Wipe() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Wipe", reflect.TypeOf((*MockTracker)(nil).Wipe))
 }
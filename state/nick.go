@@ -2,8 +2,10 @@ package state
 
 import (
 	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/mask"
 
 	"reflect"
+	"time"
 )
 
 // A Nick is returned from the state tracker and contains
@@ -12,6 +14,17 @@ type Nick struct {
 	Nick, Ident, Host, Name string
 	Modes                   *NickMode
 	Channels                map[string]*ChanPrivs
+
+	// Whether the nick is marked as away, and the message they set.
+	// The message may be empty even if they are away, if we haven't
+	// been told it yet.
+	Away    bool
+	AwayMsg string
+	// The services account the nick is logged in to, or "" if none.
+	Account string
+	// When the nick was last seen speaking or joining a channel, or as
+	// reported by WHOIS idle time. Zero if unknown.
+	LastActive time.Time
 }
 
 // Internal bookkeeping struct for nicks.
@@ -20,6 +33,9 @@ type nick struct {
 	modes                   *NickMode
 	lookup                  map[string]*channel
 	chans                   map[*channel]*ChanPrivs
+	away                    bool
+	awayMsg, account        string
+	active                  time.Time
 }
 
 // A struct representing the modes of an IRC Nick (User Modes)
@@ -72,6 +88,11 @@ func (nk *nick) Nick() *Nick {
 		Name:     nk.name,
 		Modes:    nk.modes.Copy(),
 		Channels: make(map[string]*ChanPrivs),
+
+		Away:       nk.away,
+		AwayMsg:    nk.awayMsg,
+		Account:    nk.account,
+		LastActive: nk.active,
 	}
 	for c, cp := range nk.chans {
 		n.Channels[c.name] = cp.Copy()
@@ -142,6 +163,16 @@ func (nk *Nick) Equals(other *Nick) bool {
 	return reflect.DeepEqual(nk, other)
 }
 
+// Returns the nick for matching against masks.
+func (nk *Nick) User() mask.User {
+	return mask.User{
+		Nick:    nk.Nick,
+		Ident:   nk.Ident,
+		Host:    nk.Host,
+		Account: nk.Account,
+	}
+}
+
 // Duplicates a NickMode struct.
 func (nm *NickMode) Copy() *NickMode {
 	if nm == nil { return nil }
@@ -166,6 +197,12 @@ func (nk *Nick) String() string {
 	str := "Nick: " + nk.Nick + "\n\t"
 	str += "Hostmask: " + nk.Ident + "@" + nk.Host + "\n\t"
 	str += "Real Name: " + nk.Name + "\n\t"
+	if nk.Account != "" {
+		str += "Account: " + nk.Account + "\n\t"
+	}
+	if nk.Away {
+		str += "Away: " + nk.AwayMsg + "\n\t"
+	}
 	str += "Modes: " + nk.Modes.String() + "\n\t"
 	str += "Channels: \n"
 	for ch, cp := range nk.Channels {
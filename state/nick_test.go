@@ -27,6 +27,13 @@ func TestNewNick(t *testing.T) {
 	compareNick(t, nk)
 }
 
+func TestNickUser(t *testing.T) {
+	n := &Nick{Nick: "test1", Ident: "ident", Host: "host.com", Account: "acct"}
+	if u := n.User(); u.String() != "test1!ident@host.com" || u.Account != "acct" {
+		t.Errorf("Nick not converted to mask.User correctly: %#v", u)
+	}
+}
+
 func TestAddChannel(t *testing.T) {
 	nk := newNick("test1")
 	ch := newChannel("#test1")
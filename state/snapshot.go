@@ -0,0 +1,115 @@
+package state
+
+import (
+	"fmt"
+)
+
+// A Snapshot is a copy of the entire state of a tracker at a particular
+// time. It can be encoded with encoding/json, for example to diagnose a
+// crash or compare the state of two clients, and restored into a tracker
+// later with Restore or NewTrackerFromSnapshot.
+//
+// Each nick's privileges are recorded in both Nicks and Channels, to make
+// the snapshot easy to inspect. Restoring uses those in Channels.
+type Snapshot struct {
+	Me       string              `json:"me"`
+	Nicks    map[string]*Nick    `json:"nicks"`
+	Channels map[string]*Channel `json:"channels"`
+}
+
+// Snapshot returns a copy of the tracker's state.
+func (st *stateTracker) Snapshot() *Snapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := &Snapshot{
+		Me:       st.me.nick,
+		Nicks:    make(map[string]*Nick, len(st.nicks)),
+		Channels: make(map[string]*Channel, len(st.chans)),
+	}
+	for n, nk := range st.nicks {
+		s.Nicks[n] = nk.Nick()
+	}
+	for c, ch := range st.chans {
+		s.Channels[c] = ch.Channel()
+	}
+	return s
+}
+
+// Restore replaces the tracker's state with that in the snapshot. The
+// snapshot is checked for consistency first, and the tracker is left
+// unchanged if it is not consistent. Like Wipe, no changes are emitted.
+func (st *stateTracker) Restore(s *Snapshot) error {
+	me, nicks, chans, err := s.build()
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.me, st.nicks, st.chans = me, nicks, chans
+	return nil
+}
+
+// NewTrackerFromSnapshot creates a new tracker with the state in the
+// snapshot, e.g. to warm-start a client after a quick reconnect.
+func NewTrackerFromSnapshot(s *Snapshot) (*stateTracker, error) {
+	st := NewTracker("")
+	if err := st.Restore(s); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// build creates the tracker's internal bookkeeping from a snapshot.
+func (s *Snapshot) build() (*nick, map[string]*nick, map[string]*channel, error) {
+	if s == nil {
+		return nil, nil, nil, fmt.Errorf("Tracker.Restore(): nil snapshot")
+	}
+	nicks := make(map[string]*nick, len(s.Nicks))
+	for n, sn := range s.Nicks {
+		if sn == nil || sn.Nick != n {
+			return nil, nil, nil, fmt.Errorf(
+				"Tracker.Restore(): snapshot nick %q has mismatched data", n)
+		}
+		nk := newNick(n)
+		nk.ident, nk.host, nk.name = sn.Ident, sn.Host, sn.Name
+		nk.away, nk.awayMsg, nk.account = sn.Away, sn.AwayMsg, sn.Account
+		nk.active = sn.LastActive
+		if sn.Modes != nil {
+			*nk.modes = *sn.Modes
+		}
+		nicks[n] = nk
+	}
+	me, ok := nicks[s.Me]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf(
+			"Tracker.Restore(): snapshot doesn't contain my nick %q", s.Me)
+	}
+	chans := make(map[string]*channel, len(s.Channels))
+	for c, sc := range s.Channels {
+		if sc == nil || sc.Name != c {
+			return nil, nil, nil, fmt.Errorf(
+				"Tracker.Restore(): snapshot channel %q has mismatched data", c)
+		}
+		ch := newChannel(c)
+		ch.topic = sc.Topic
+		if sc.Modes != nil {
+			*ch.modes = *sc.Modes
+		}
+		for n, cp := range sc.Nicks {
+			nk, ok := nicks[n]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf(
+					"Tracker.Restore(): snapshot channel %q contains unknown nick %q", c, n)
+			}
+			if cp == nil {
+				cp = new(ChanPrivs)
+			}
+			// The channel and nick must share a ChanPrivs.
+			cp = cp.Copy()
+			ch.addNick(nk, cp)
+			nk.addChannel(ch, cp)
+		}
+		chans[c] = ch
+	}
+	return me, nicks, chans, nil
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSTSnapshot(t *testing.T) {
+	st := NewTracker("mynick")
+	st.NickInfo("mynick", "ident", "host", "name")
+	st.NickModes("mynick", "+iw")
+	st.NewChannel("#test1")
+	st.NewChannel("#test2")
+	st.NewNick("test1")
+	st.NickInfo("test1", "foo", "bar", "baz")
+	st.NickAway("test1", true, "gone")
+	st.NickAccount("test1", "acct")
+	st.NickActive("test1", time.Unix(1500000000, 0).UTC())
+	st.Associate("#test1", "mynick")
+	st.Associate("#test1", "test1")
+	st.Associate("#test2", "mynick")
+	st.Topic("#test1", "a topic")
+	st.ChannelModes("#test1", "+ntklo", "key", "10", "test1")
+
+	data, err := json.Marshal(st.Snapshot())
+	if err != nil {
+		t.Fatalf("Marshalling snapshot failed: %s", err)
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		t.Fatalf("Unmarshalling snapshot failed: %s", err)
+	}
+	neu, err := NewTrackerFromSnapshot(s)
+	if err != nil {
+		t.Fatalf("Restoring snapshot failed: %s", err)
+	}
+
+	if !neu.Me().Equals(st.Me()) {
+		t.Errorf("Restored me differs:\n%s\n%s", neu.Me(), st.Me())
+	}
+	for _, n := range []string{"mynick", "test1"} {
+		if !neu.GetNick(n).Equals(st.GetNick(n)) {
+			t.Errorf("Restored nick %s differs.", n)
+		}
+	}
+	for _, c := range []string{"#test1", "#test2"} {
+		if !neu.GetChannel(c).Equals(st.GetChannel(c)) {
+			t.Errorf("Restored channel %s differs.", c)
+		}
+	}
+	if len(neu.nicks) != 2 || len(neu.chans) != 2 || neu.nicks["mynick"] != neu.me {
+		t.Errorf("Restored tracker has bad internal state.")
+	}
+
+	// The restored nick and channel must share privileges.
+	neu.ChannelModes("#test1", "+v", "test1")
+	if cp, _ := neu.IsOn("#test1", "test1"); !cp.Op || !cp.Voice {
+		t.Errorf("Privileges not restored correctly: %s", cp)
+	}
+	if nk := neu.GetNick("test1"); !nk.Channels["#test1"].Voice {
+		t.Errorf("Restored nick and channel don't share privileges.")
+	}
+}
+
+func TestSTRestoreErrors(t *testing.T) {
+	st := NewTracker("mynick")
+	st.NewChannel("#test1")
+	st.Associate("#test1", "mynick")
+
+	bad := []*Snapshot{
+		nil,
+		{Me: "nobody", Nicks: map[string]*Nick{}},
+		{Me: "me", Nicks: map[string]*Nick{"me": {Nick: "you"}}},
+		{Me: "me", Nicks: map[string]*Nick{"me": {Nick: "me"}},
+			Channels: map[string]*Channel{"#c": {Name: "#c",
+				Nicks: map[string]*ChanPrivs{"you": {}}}}},
+	}
+	for i, s := range bad {
+		if err := st.Restore(s); err == nil {
+			t.Errorf("Restoring bad snapshot %d succeeded.", i)
+		}
+	}
+	if st.GetChannel("#test1") == nil || st.Me().Nick != "mynick" {
+		t.Errorf("Failed restore changed tracker state.")
+	}
+}
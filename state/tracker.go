@@ -4,8 +4,11 @@ import (
 	"github.com/fluffle/goirc/logging"
 
 	"sync"
+	"time"
 )
 
+//go:generate mockgen -source=tracker.go -package=state -self_package=github.com/fluffle/goirc/state -destination=mock_tracker.go
+
 // The state manager interface
 type Tracker interface {
 	// Nick methods
@@ -15,6 +18,11 @@ type Tracker interface {
 	DelNick(nick string) *Nick
 	NickInfo(nick, ident, host, name string) *Nick
 	NickModes(nick, modestr string) *Nick
+	NickAway(nick string, away bool, msg string) *Nick
+	NickAccount(nick, account string) *Nick
+	NickHost(nick, ident, host string) *Nick
+	NickRealname(nick, name string) *Nick
+	NickActive(nick string, t time.Time) *Nick
 	// Channel methods
 	NewChannel(channel string) *Channel
 	GetChannel(channel string) *Channel
@@ -28,6 +36,15 @@ type Tracker interface {
 	Associate(channel, nick string) *ChanPrivs
 	Dissociate(channel, nick string)
 	Wipe()
+	// Copying and restoring the entire state
+	Snapshot() *Snapshot
+	Restore(s *Snapshot) error
+	// Checking the internal state is consistent
+	Verify() []*Inconsistency
+	// Recent messages in channels and private conversations
+	SetHistory(size int, maxAge time.Duration)
+	AddMessage(m *Message)
+	History(target string, n int) []*Message
 	// The state tracker can output a debugging string
 	String() string
 }
@@ -44,6 +61,19 @@ type stateTracker struct {
 
 	// And we need to protect against data races *cough*.
 	mu sync.Mutex
+
+	// Changes are collected in pending while mu is held, and passed
+	// to notify once it is released.
+	notify  func(*Change)
+	pending []*Change
+
+	// Recent messages, keyed by channel or the nick we're talking to,
+	// and the limits on how many are kept and for how long.
+	history  map[string]*history
+	histSize int
+	histAge  time.Duration
+	// When expired messages were last removed from all the history.
+	pruned time.Time
 }
 
 var _ Tracker = (*stateTracker)(nil)
@@ -104,7 +134,7 @@ func (st *stateTracker) GetNick(n string) *Nick {
 // under a "neu" nick rather than the old one.
 func (st *stateTracker) ReNick(old, neu string) *Nick {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	nk, ok := st.nicks[old]
 	if !ok {
 		logging.Warn("Tracker.ReNick(): %s not tracked.", old)
@@ -124,21 +154,33 @@ func (st *stateTracker) ReNick(old, neu string) *Nick {
 		delete(ch.lookup, old)
 		ch.lookup[neu] = nk
 	}
+	if h, ok := st.history[old]; ok {
+		// Our conversation with the nick continues under the new one.
+		delete(st.history, old)
+		st.history[neu] = h
+	}
+	st.changed(NickRenamed, "", neu, old, neu)
 	return nk.Nick()
 }
 
 // Removes a nick from being tracked.
 func (st *stateTracker) DelNick(n string) *Nick {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	if nk, ok := st.nicks[n]; ok {
 		if nk == st.me {
 			logging.Warn("Tracker.DelNick(): won't delete myself.")
 			return nil
 		}
+		delete(st.history, n)
+		for ch := range nk.chans {
+			st.changed(NickLeftChannel, ch.name, nk.nick, "", "")
+		}
 		st.delNick(nk)
 		return nk.Nick()
 	}
+	// We may have been talking to a nick we're not in any channels with.
+	delete(st.history, n)
 	logging.Warn("Tracker.DelNick(): %s not tracked.", n)
 	return nil
 }
@@ -180,12 +222,98 @@ func (st *stateTracker) NickInfo(n, ident, host, name string) *Nick {
 // Sets user modes for the nick.
 func (st *stateTracker) NickModes(n, modes string) *Nick {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	nk, ok := st.nicks[n]
 	if !ok {
 		return nil
 	}
+	old := modeString(nk.modes.String())
 	nk.parseModes(modes)
+	if neu := modeString(nk.modes.String()); neu != old {
+		st.changed(NickModeChanged, "", n, old, neu)
+	}
+	return nk.Nick()
+}
+
+// Sets whether the nick is away, and their away message.
+func (st *stateTracker) NickAway(n string, away bool, msg string) *Nick {
+	st.mu.Lock()
+	defer st.unlock()
+	nk, ok := st.nicks[n]
+	if !ok {
+		return nil
+	}
+	if !away {
+		msg = ""
+	}
+	if away != nk.away || msg != nk.awayMsg {
+		st.changed(NickAwayChanged, "", n, nk.awayMsg, msg)
+		nk.away, nk.awayMsg = away, msg
+	}
+	return nk.Nick()
+}
+
+// Sets the services account the nick is logged in to, or "" if none.
+func (st *stateTracker) NickAccount(n, account string) *Nick {
+	st.mu.Lock()
+	defer st.unlock()
+	nk, ok := st.nicks[n]
+	if !ok {
+		return nil
+	}
+	if old := nk.account; old != account {
+		nk.account = account
+		st.changed(NickAccountChanged, "", n, old, account)
+	}
+	return nk.Nick()
+}
+
+// Sets the ident and host for the nick, e.g. when they change it.
+func (st *stateTracker) NickHost(n, ident, host string) *Nick {
+	st.mu.Lock()
+	defer st.unlock()
+	nk, ok := st.nicks[n]
+	if !ok {
+		return nil
+	}
+	old, neu := nk.ident+"@"+nk.host, ident+"@"+host
+	if old != neu {
+		// Learning the host of a nick for the first time isn't a change.
+		if nk.ident != "" || nk.host != "" {
+			st.changed(NickHostChanged, "", n, old, neu)
+		}
+		nk.ident, nk.host = ident, host
+	}
+	return nk.Nick()
+}
+
+// Sets the "real" name for the nick, e.g. when they change it.
+func (st *stateTracker) NickRealname(n, name string) *Nick {
+	st.mu.Lock()
+	defer st.unlock()
+	nk, ok := st.nicks[n]
+	if !ok {
+		return nil
+	}
+	if old := nk.name; old != name {
+		nk.name = name
+		st.changed(NickRealnameChanged, "", n, old, name)
+	}
+	return nk.Nick()
+}
+
+// Records that the nick was active at time t, if that is more recent
+// than their last recorded activity.
+func (st *stateTracker) NickActive(n string, t time.Time) *Nick {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	nk, ok := st.nicks[n]
+	if !ok {
+		return nil
+	}
+	if t.After(nk.active) {
+		nk.active = t
+	}
 	return nk.Nick()
 }
 
@@ -222,6 +350,7 @@ func (st *stateTracker) DelChannel(c string) *Channel {
 	defer st.mu.Unlock()
 	if ch, ok := st.chans[c]; ok {
 		st.delChannel(ch)
+		delete(st.history, c)
 		return ch.Channel()
 	}
 	logging.Warn("Tracker.DelChannel(): %s not tracked.", c)
@@ -244,24 +373,52 @@ func (st *stateTracker) delChannel(ch *channel) {
 // Sets the topic of a channel.
 func (st *stateTracker) Topic(c, topic string) *Channel {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	ch, ok := st.chans[c]
 	if !ok {
 		return nil
 	}
-	ch.topic = topic
+	if old := ch.topic; old != topic {
+		ch.topic = topic
+		st.changed(TopicChanged, c, "", old, topic)
+	}
 	return ch.Channel()
 }
 
 // Sets modes for a channel, including privileges like +o.
 func (st *stateTracker) ChannelModes(c, modes string, args ...string) *Channel {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	ch, ok := st.chans[c]
 	if !ok {
 		return nil
 	}
+	// Only nicks named in the mode arguments can have their privileges
+	// changed, so there's no need to compare everyone on the channel.
+	oldModes := modeString(ch.modes.String())
+	oldPrivs := make(map[*nick]string)
+	for _, a := range args {
+		if nk, ok := ch.lookup[a]; ok {
+			oldPrivs[nk] = modeString(ch.nicks[nk].String())
+		}
+	}
 	ch.parseModes(modes, args...)
+	if neu := modeString(ch.modes.String()); neu != oldModes {
+		st.changed(ChannelModeChanged, c, "", oldModes, neu)
+	}
+	for _, a := range args {
+		nk, ok := ch.lookup[a]
+		if !ok {
+			continue
+		}
+		if old, ok := oldPrivs[nk]; ok {
+			// Only report each nick once, however many modes changed.
+			delete(oldPrivs, nk)
+			if neu := modeString(ch.nicks[nk].String()); neu != old {
+				st.changed(PrivilegeChanged, c, nk.nick, old, neu)
+			}
+		}
+	}
 	return ch.Channel()
 }
 
@@ -286,7 +443,7 @@ func (st *stateTracker) IsOn(c, n string) (*ChanPrivs, bool) {
 // Associates an already known nick with an already known channel.
 func (st *stateTracker) Associate(c, n string) *ChanPrivs {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	nk, nok := st.nicks[n]
 	ch, cok := st.chans[c]
 
@@ -309,6 +466,7 @@ func (st *stateTracker) Associate(c, n string) *ChanPrivs {
 	cp := new(ChanPrivs)
 	ch.addNick(nk, cp)
 	nk.addChannel(ch, cp)
+	st.changed(NickJoinedChannel, c, n, "", "")
 	return cp.Copy()
 }
 
@@ -317,7 +475,7 @@ func (st *stateTracker) Associate(c, n string) *ChanPrivs {
 // any common channels with, and channels we're no longer on.
 func (st *stateTracker) Dissociate(c, n string) {
 	st.mu.Lock()
-	defer st.mu.Unlock()
+	defer st.unlock()
 	nk, nok := st.nicks[n]
 	ch, cok := st.chans[c]
 
@@ -336,10 +494,13 @@ func (st *stateTracker) Dissociate(c, n string) {
 	} else if nk == st.me {
 		// I'm leaving the channel for some reason, so it won't be tracked.
 		st.delChannel(ch)
+		delete(st.history, c)
+		st.changed(NickLeftChannel, c, n, "", "")
 	} else {
 		// Remove the nick from the channel and the channel from the nick.
 		ch.delNick(nk)
 		nk.delChannel(ch)
+		st.changed(NickLeftChannel, c, n, "", "")
 		if len(nk.chans) == 0 {
 			// We're no longer in any channels with this nick.
 			st.delNick(nk)
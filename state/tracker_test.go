@@ -2,6 +2,7 @@ package state
 
 import (
 	"testing"
+	"time"
 )
 
 // There is some awkwardness in these tests. Items retrieved directly from the
@@ -562,3 +563,120 @@ func TestSTWipe(t *testing.T) {
 		t.Errorf("Nick chan lists wrong length after wipe.")
 	}
 }
+
+func TestSTChanges(t *testing.T) {
+	st := NewTracker("mynick")
+	var got []Change
+	st.OnChange(func(c *Change) {
+		// The tracker must be unlocked when changes are emitted.
+		st.GetNick("mynick")
+		got = append(got, *c)
+	})
+	expect := func(name string, want ...Change) {
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d changes, got %d: %#v",
+				name, len(want), len(got), got)
+		} else {
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s: change %d expected %#v, got %#v",
+						name, i, want[i], got[i])
+				}
+			}
+		}
+		got = nil
+	}
+
+	st.NewChannel("#test1")
+	st.NewNick("test1")
+	expect("New")
+
+	st.Associate("#test1", "mynick")
+	st.Associate("#test1", "test1")
+	expect("Associate",
+		Change{Kind: NickJoinedChannel, Channel: "#test1", Nick: "mynick"},
+		Change{Kind: NickJoinedChannel, Channel: "#test1", Nick: "test1"})
+
+	st.ChannelModes("#test1", "+ntov", "test1", "test1")
+	expect("ChannelModes",
+		Change{ChannelModeChanged, "#test1", "", "", "+tn"},
+		Change{PrivilegeChanged, "#test1", "test1", "", "+ov"})
+
+	// Modes that are already set don't count as changes.
+	st.ChannelModes("#test1", "+o-k", "test1")
+	expect("ChannelModes no-op")
+
+	st.Topic("#test1", "a topic")
+	st.Topic("#test1", "a topic")
+	expect("Topic", Change{TopicChanged, "#test1", "", "", "a topic"})
+
+	st.NickModes("mynick", "+i")
+	expect("NickModes", Change{NickModeChanged, "", "mynick", "", "+i"})
+
+	st.ReNick("test1", "test2")
+	expect("ReNick", Change{NickRenamed, "", "test2", "test1", "test2"})
+
+	st.NickInfo("test2", "ident", "host", "name")
+	expect("NickInfo")
+	st.NickHost("test2", "ident", "host2")
+	st.NickRealname("test2", "name2")
+	st.NickAccount("test2", "acct")
+	st.NickAway("test2", true, "gone")
+	st.NickAway("test2", true, "gone")
+	st.NickAway("test2", false, "gone")
+	expect("Nick info",
+		Change{NickHostChanged, "", "test2", "ident@host", "ident@host2"},
+		Change{NickRealnameChanged, "", "test2", "name", "name2"},
+		Change{NickAccountChanged, "", "test2", "", "acct"},
+		Change{NickAwayChanged, "", "test2", "", "gone"},
+		Change{NickAwayChanged, "", "test2", "gone", ""})
+
+	st.Dissociate("#test1", "test2")
+	expect("Dissociate",
+		Change{Kind: NickLeftChannel, Channel: "#test1", Nick: "test2"})
+
+	st.NewNick("test3")
+	st.Associate("#test1", "test3")
+	got = nil
+	st.DelNick("test3")
+	expect("DelNick",
+		Change{Kind: NickLeftChannel, Channel: "#test1", Nick: "test3"})
+
+	st.NewNick("test4")
+	st.Associate("#test1", "test4")
+	got = nil
+	// Learning a nick's host for the first time isn't a change.
+	st.NickHost("test4", "ident", "host")
+	expect("NickHost first seen")
+	st.Dissociate("#test1", "mynick")
+	expect("Dissociate me",
+		Change{Kind: NickLeftChannel, Channel: "#test1", Nick: "mynick"})
+}
+
+func TestSTNickAwayAccountActive(t *testing.T) {
+	st := NewTracker("mynick")
+	st.NewNick("test1")
+
+	if nk := st.NickAway("test1", true, "gone"); !nk.Away || nk.AwayMsg != "gone" {
+		t.Errorf("NickAway did not set away status: %#v", nk)
+	}
+	if nk := st.NickAway("test1", false, "ignored"); nk.Away || nk.AwayMsg != "" {
+		t.Errorf("NickAway did not clear away status: %#v", nk)
+	}
+	if nk := st.NickAccount("test1", "acct"); nk.Account != "acct" {
+		t.Errorf("NickAccount did not set account: %#v", nk)
+	}
+
+	now := time.Now()
+	if nk := st.NickActive("test1", now); !nk.LastActive.Equal(now) {
+		t.Errorf("NickActive did not set activity time.")
+	}
+	if nk := st.NickActive("test1", now.Add(-time.Hour)); !nk.LastActive.Equal(now) {
+		t.Errorf("NickActive moved activity time backwards.")
+	}
+
+	if st.NickAway("test2", true, "") != nil || st.NickAccount("test2", "") != nil ||
+		st.NickActive("test2", now) != nil {
+		t.Errorf("Setting info for unknown nick returned non-nil.")
+	}
+}
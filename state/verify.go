@@ -0,0 +1,91 @@
+package state
+
+import (
+	"fmt"
+)
+
+// An Inconsistency is a problem with the tracker's internal state found
+// by Verify. Channel and Nick identify the state concerned, where known.
+type Inconsistency struct {
+	Channel, Nick string
+	Problem       string
+}
+
+func (i *Inconsistency) Error() string {
+	switch {
+	case i.Channel != "" && i.Nick != "":
+		return fmt.Sprintf("%s on %s: %s", i.Nick, i.Channel, i.Problem)
+	case i.Channel != "":
+		return i.Channel + ": " + i.Problem
+	case i.Nick != "":
+		return i.Nick + ": " + i.Problem
+	}
+	return i.Problem
+}
+
+// Verify checks the invariants of the tracker's internal state, and returns
+// any inconsistencies found. In particular, every nick on a channel must
+// have that channel in its own list and share its privileges, every nick
+// other than us must be on at least one channel with us, and we must be
+// on every tracked channel. If these don't hold, the tracker has a bug.
+func (st *stateTracker) Verify() []*Inconsistency {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var bad []*Inconsistency
+	problem := func(c, n, f string, args ...interface{}) {
+		bad = append(bad, &Inconsistency{c, n, fmt.Sprintf(f, args...)})
+	}
+
+	if nk, ok := st.nicks[st.me.nick]; !ok || nk != st.me {
+		problem("", st.me.nick, "my nick is not tracked")
+	}
+	for n, nk := range st.nicks {
+		if nk.nick != n {
+			problem("", n, "tracked under the wrong nick %s", nk.nick)
+		}
+		if len(nk.chans) == 0 && nk != st.me {
+			problem("", n, "not on any tracked channels")
+		}
+		if len(nk.lookup) != len(nk.chans) {
+			problem("", n, "channel lookup has %d entries, expected %d",
+				len(nk.lookup), len(nk.chans))
+		}
+		for ch, cp := range nk.chans {
+			if st.chans[ch.name] != ch {
+				problem(ch.name, n, "channel is not tracked")
+			}
+			if nk.lookup[ch.name] != ch {
+				problem(ch.name, n, "channel missing from nick's lookup")
+			}
+			if chcp, ok := ch.nicks[nk]; !ok {
+				problem(ch.name, n, "nick missing from channel")
+			} else if chcp != cp {
+				problem(ch.name, n, "nick and channel privileges differ")
+			}
+		}
+	}
+	for c, ch := range st.chans {
+		if ch.name != c {
+			problem(c, "", "tracked under the wrong name %s", ch.name)
+		}
+		if _, ok := ch.nicks[st.me]; !ok {
+			problem(c, st.me.nick, "I am not on the channel")
+		}
+		if len(ch.lookup) != len(ch.nicks) {
+			problem(c, "", "nick lookup has %d entries, expected %d",
+				len(ch.lookup), len(ch.nicks))
+		}
+		for nk := range ch.nicks {
+			if st.nicks[nk.nick] != nk {
+				problem(c, nk.nick, "nick is not tracked")
+			}
+			if ch.lookup[nk.nick] != nk {
+				problem(c, nk.nick, "nick missing from channel's lookup")
+			}
+			if _, ok := nk.chans[ch]; !ok {
+				problem(c, nk.nick, "channel missing from nick")
+			}
+		}
+	}
+	return bad
+}
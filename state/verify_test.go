@@ -0,0 +1,38 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestSTVerify(t *testing.T) {
+	st := NewTracker("mynick")
+	st.NewChannel("#test1")
+	st.NewNick("test1")
+	st.Associate("#test1", "mynick")
+	st.Associate("#test1", "test1")
+	st.ChannelModes("#test1", "+o", "test1")
+
+	if bad := st.Verify(); len(bad) != 0 {
+		t.Errorf("Consistent tracker failed verification: %v", bad)
+	}
+
+	// Break the relationship between test1 and #test1 in one direction.
+	n1, c1 := st.nicks["test1"], st.chans["#test1"]
+	c1.nicks[n1] = new(ChanPrivs)
+	bad := st.Verify()
+	if len(bad) != 1 || bad[0].Channel != "#test1" || bad[0].Nick != "test1" {
+		t.Errorf("Expected one inconsistency for test1 on #test1, got %v", bad)
+	}
+	if s := bad[0].Error(); s != "test1 on #test1: nick and channel privileges differ" {
+		t.Errorf("Unexpected inconsistency string: %s", s)
+	}
+
+	delete(c1.nicks, n1)
+	delete(c1.nicks, st.me)
+	bad = st.Verify()
+	// Both nicks are missing from the channel, I am not on it, and its
+	// lookup map now has too many entries.
+	if len(bad) != 4 {
+		t.Errorf("Expected 4 inconsistencies, got %d: %v", len(bad), bad)
+	}
+}